@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ScanJob is a scan requested by the backend for an agent to run.
+type ScanJob struct {
+	JobID   string   `json:"job_id"`
+	Email   string   `json:"email"`
+	Policy  string   `json:"policy,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// JobResponse is the answer to a poll. Job is nil when there is nothing to
+// do, and PollAfter optionally tells the agent when to ask again.
+type JobResponse struct {
+	Job       *ScanJob `json:"job"`
+	PollAfter int      `json:"poll_after,omitempty"`
+}
+
+// JobResult reports the outcome of a job back to the backend.
+type JobResult struct {
+	AgentID  string `json:"agent_id"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	ScanID   int    `json:"scan_id,omitempty"`
+	Critical int    `json:"critical"`
+	High     int    `json:"high"`
+	Medium   int    `json:"medium"`
+	Low      int    `json:"low"`
+	Info     int    `json:"info"`
+	Duration int    `json:"duration_seconds"`
+}
+
+// agentPollInterval is how long the agent waits between polls unless the
+// backend says otherwise.
+var agentPollInterval = 5 * time.Minute
+
+// agent polls the backend for scan jobs and runs them one at a time.
+type agent struct {
+	id       string
+	hostname string
+	// execute runs a job. It is runScan in production and a fake in tests.
+	execute func(ctx context.Context, opts scanOptions) scanResult
+	// schedule also runs the scheduled scans when they are due, between
+	// jobs, so that the installed service runs them unattended.
+	schedule bool
+}
+
+func newAgent() (*agent, error) {
+	id, err := loadAgentID()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &agent{id: id, hostname: hostname, execute: runScan, schedule: true}, nil
+}
+
+// loadAgentID returns the ID that identifies this machine to the jobs
+// endpoint, generating and storing one on first use.
+func loadAgentID() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "agent_id")
+	data, err := ioutil.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading agent ID: %v", err)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating agent ID: %v", err)
+	}
+	id := hex.EncodeToString(b)
+	if err := ioutil.WriteFile(path, []byte(id+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("error writing agent ID: %v", err)
+	}
+	return id, nil
+}
+
+// poll asks the backend for the next job.
+func (a *agent) poll(ctx context.Context) (JobResponse, error) {
+	query := url.Values{"agent_id": {a.id}, "hostname": {a.hostname}, "operating_system": {capitalizeFirstLetter(runtime.GOOS)}}
+	var resp JobResponse
+	err := apiRequest(ctx, http.MethodGet, "jobs/next?"+query.Encode(), nil, &resp, nil, statusPolicy)
+	return resp, err
+}
+
+// report sends the result of a job to the backend.
+func (a *agent) report(ctx context.Context, job *ScanJob, result scanResult) error {
+	body := JobResult{
+		AgentID:  a.id,
+		ExitCode: result.ExitCode,
+		ScanID:   result.ScanID,
+		Critical: result.Final.Critical,
+		High:     result.Final.High,
+		Medium:   result.Final.Medium,
+		Low:      result.Final.Low,
+		Info:     result.Final.Info,
+		Duration: int(result.Duration.Seconds()),
+	}
+	if result.Err != nil {
+		body.Error = result.Err.Error()
+	}
+	path := fmt.Sprintf("jobs/%s/result", url.PathEscape(job.JobID))
+	return apiRequest(ctx, http.MethodPost, path, body, nil, nil, mutatePolicy)
+}
+
+// runOnce polls once and runs the job, if any. It returns how long to wait
+// before the next poll.
+func (a *agent) runOnce(ctx context.Context) time.Duration {
+	resp, err := a.poll(ctx)
+	if err != nil {
+		fmt.Println("Error polling for jobs:", err)
+		return agentPollInterval
+	}
+	wait := agentPollInterval
+	if resp.PollAfter > 0 {
+		wait = time.Duration(resp.PollAfter) * time.Second
+	}
+	if resp.Job == nil {
+		debugPrint("No scan jobs, polling again in %s\n", wait)
+		return wait
+	}
+
+	job := resp.Job
+	fmt.Printf("Running scan job %s\n", job.JobID)
+	// The tunnel is only up while runScan is running.
+	result := a.execute(ctx, scanOptions{Unattended: true, Email: job.Email, Policy: job.Policy, Targets: job.Targets, Export: config.Export})
+	fmt.Printf("Scan job %s finished with exit code %d\n", job.JobID, result.ExitCode)
+
+	// Report even if the run was interrupted, so the job is not left hanging.
+	if err := a.report(context.Background(), job, result); err != nil {
+		fmt.Println("Error reporting job result:", err)
+	}
+	// Ask again straight away in case more jobs are queued.
+	return 0
+}
+
+// run polls for jobs until ctx is cancelled, checking the schedule every
+// scheduleCheckInterval in between.
+func (a *agent) run(ctx context.Context) error {
+	fmt.Printf("Agent %s started, polling for scan jobs...\n", a.id)
+	nextPoll := time.Now()
+	for {
+		if !time.Now().Before(nextPoll) {
+			nextPoll = time.Now().Add(a.runOnce(ctx))
+		}
+		wait := time.Until(nextPoll)
+		if a.schedule {
+			if err := runDueSchedules(ctx, a.execute); err != nil && ctx.Err() == nil {
+				fmt.Println("Error running scheduled scans:", err)
+			}
+			if wait > scheduleCheckInterval {
+				wait = scheduleCheckInterval
+			}
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// agentCommand implements the agent subcommands and returns the exit code.
+func agentCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: agent install|uninstall|run")
+		return exitFailure
+	}
+	if !isPrivileged() {
+		fmt.Println("Please run as root or administrator to use agent mode.")
+		return exitPrivileges
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installAgentService()
+	case "uninstall":
+		err = uninstallAgentService()
+	case "run":
+		var a *agent
+		a, err = newAgent()
+		if err == nil {
+			err = runAgentService(a.run)
+		}
+	default:
+		err = fmt.Errorf("unknown agent command %q", args[0])
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// installedBinaryPath is where the agent service runs the client from, so
+// that the service keeps working after the downloaded copy is deleted.
+func installedBinaryPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	name := "nessus-remote-scanner"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// copyExecutable copies the running binary to installedBinaryPath.
+func copyExecutable() (string, error) {
+	src, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("error getting executable path: %v", err)
+	}
+	dst, err := installedBinaryPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("error reading executable: %v", err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0755); err != nil {
+		return "", fmt.Errorf("error copying executable: %v", err)
+	}
+	return dst, nil
+}
+
+// installedConfigPath is the copy of the config file the agent service
+// reads. The service starts in / or System32, where the relative default
+// config file would not be found.
+func installedConfigPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "client_config.json"), nil
+}
+
+// removeInstalledFiles removes the copies of the binary and the config file
+// made when the agent service was installed.
+func removeInstalledFiles() {
+	for _, installed := range []func() (string, error){installedBinaryPath, installedConfigPath} {
+		path, err := installed()
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			removeTempFile(path)
+		}
+	}
+}
+
+// copyConfig copies the config file to installedConfigPath, readable by
+// administrators only since it may hold passwords and keys. Without a
+// config file an earlier copy is removed so the service uses the defaults.
+func copyConfig() (string, error) {
+	dst, err := installedConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if src, err := filepath.Abs(configFile); err == nil && src == dst {
+		return dst, nil
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("error removing %s: %v", dst, err)
+		}
+		return dst, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading config file: %v", err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+		return "", fmt.Errorf("error copying config file: %v", err)
+	}
+	return dst, nil
+}
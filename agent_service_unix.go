@@ -0,0 +1,146 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+)
+
+const systemdUnitPath = "/etc/systemd/system/nessus-remote-scanner.service"
+const launchdPlistPath = "/Library/LaunchDaemons/com.smbdefence.nessus-remote-scanner.plist"
+
+const systemdUnit = `[Unit]
+Description=Nessus Remote Scanner agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s -config "%s" agent run
+Restart=on-failure
+RestartSec=60
+KillSignal=SIGINT
+TimeoutStopSec=300
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.smbdefence.nessus-remote-scanner</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>-config</string>
+		<string>%s</string>
+		<string>agent</string>
+		<string>run</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ExitTimeOut</key>
+	<integer>300</integer>
+</dict>
+</plist>
+`
+
+// installAgentService registers the agent as a systemd unit or launchd daemon.
+// A service left from an earlier install is stopped first so that its
+// binary can be replaced.
+func installAgentService() error {
+	switch runtime.GOOS {
+	case "linux":
+		// Stopping fails if the service is not installed, which is fine.
+		_ = runCommands(context.Background(), [][]string{{"systemctl", "stop", "nessus-remote-scanner.service"}})
+	case "darwin":
+		if _, err := os.Stat(launchdPlistPath); err == nil {
+			_ = runCommands(context.Background(), [][]string{{"launchctl", "unload", "-w", launchdPlistPath}})
+		}
+	default:
+		return fmt.Errorf("agent mode is not supported on %s", runtime.GOOS)
+	}
+	binary, err := copyExecutable()
+	if err != nil {
+		return err
+	}
+	configPath, err := copyConfig()
+	if err != nil {
+		return err
+	}
+
+	var commands [][]string
+	switch runtime.GOOS {
+	case "linux":
+		if err := ioutil.WriteFile(systemdUnitPath, []byte(fmt.Sprintf(systemdUnit, binary, configPath)), 0644); err != nil {
+			return fmt.Errorf("error writing systemd unit: %v", err)
+		}
+		commands = [][]string{
+			{"systemctl", "daemon-reload"},
+			{"systemctl", "enable", "--now", "nessus-remote-scanner.service"},
+		}
+	case "darwin":
+		if err := ioutil.WriteFile(launchdPlistPath, []byte(fmt.Sprintf(launchdPlist, binary, configPath)), 0644); err != nil {
+			return fmt.Errorf("error writing launchd plist: %v", err)
+		}
+		commands = [][]string{{"launchctl", "load", "-w", launchdPlistPath}}
+	}
+
+	if err := runCommands(context.Background(), commands); err != nil {
+		return err
+	}
+	fmt.Println("Agent service installed and started.")
+	return nil
+}
+
+// uninstallAgentService stops and removes the service and the copies of the
+// binary and config file.
+func uninstallAgentService() error {
+	var commands [][]string
+	var path string
+	switch runtime.GOOS {
+	case "linux":
+		commands = [][]string{{"systemctl", "disable", "--now", "nessus-remote-scanner.service"}}
+		path = systemdUnitPath
+	case "darwin":
+		commands = [][]string{{"launchctl", "unload", "-w", launchdPlistPath}}
+		path = launchdPlistPath
+	default:
+		return fmt.Errorf("agent mode is not supported on %s", runtime.GOOS)
+	}
+
+	if err := runCommands(context.Background(), commands); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing %s: %v", path, err)
+	}
+	removeInstalledFiles()
+	if runtime.GOOS == "linux" {
+		if err := runCommands(context.Background(), [][]string{{"systemctl", "daemon-reload"}}); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Agent service removed.")
+	return nil
+}
+
+// runAgentService runs the agent in the foreground. systemd and launchd stop
+// it with a signal, which unwinds any scan in progress.
+func runAgentService(run func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
+	if err := run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+const agentServiceName = "NessusRemoteScanner"
+
+// installAgentService registers the agent as an automatically started
+// Windows service. A service left from an earlier install is stopped so
+// that its binary can be replaced, and reconfigured.
+func installAgentService() error {
+	create := "create"
+	if _, err := runner.Run(context.Background(), "sc", "query", agentServiceName); err == nil {
+		create = "config"
+		// Stopping fails if the service is not running, which is fine.
+		_ = runCommands(context.Background(), [][]string{{"sc", "stop", agentServiceName}})
+		time.Sleep(5 * time.Second)
+	}
+	binary, err := copyExecutable()
+	if err != nil {
+		return err
+	}
+	configPath, err := copyConfig()
+	if err != nil {
+		return err
+	}
+
+	commands := [][]string{
+		{"sc", create, agentServiceName, "binPath=", fmt.Sprintf("\"%s\" -config \"%s\" agent run", binary, configPath), "start=", "auto", "DisplayName=", "Nessus Remote Scanner agent"},
+		{"sc", "failure", agentServiceName, "reset=", "86400", "actions=", "restart/60000"},
+		{"sc", "start", agentServiceName},
+	}
+	if err := runCommands(context.Background(), commands); err != nil {
+		return err
+	}
+	fmt.Println("Agent service installed and started.")
+	return nil
+}
+
+// uninstallAgentService stops and removes the service and the copies of the
+// binary and config file.
+func uninstallAgentService() error {
+	// Stopping fails if the service is not running, which is fine.
+	_ = runCommands(context.Background(), [][]string{{"sc", "stop", agentServiceName}})
+	time.Sleep(5 * time.Second)
+	if err := runCommands(context.Background(), [][]string{{"sc", "delete", agentServiceName}}); err != nil {
+		return err
+	}
+	removeInstalledFiles()
+	fmt.Println("Agent service removed.")
+	return nil
+}
+
+// agentService adapts the agent loop to the service control manager.
+type agentService struct {
+	run func(ctx context.Context) error
+}
+
+func (s *agentService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(ctx)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil && ctx.Err() == nil {
+				fmt.Println("Error:", err)
+				return false, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// Cancelling unwinds any scan in progress before the loop returns.
+				changes <- svc.Status{State: svc.StopPending, WaitHint: 300000}
+				cancel()
+			}
+		}
+	}
+}
+
+// runAgentService runs the agent under the service control manager, or in
+// the foreground when started from a console.
+func runAgentService(run func(ctx context.Context) error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("error detecting service mode: %v", err)
+	}
+	if isService {
+		return svc.Run(agentServiceName, &agentService{run: run})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
+	if err := run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJobServer hands out queued jobs and records the results reported.
+type fakeJobServer struct {
+	mu      sync.Mutex
+	jobs    []ScanJob
+	polls   int
+	results map[string]JobResult
+}
+
+func (f *fakeJobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/jobs/next":
+		f.polls++
+		if r.URL.Query().Get("agent_id") != "agent-1" {
+			http.Error(w, "unknown agent", http.StatusForbidden)
+			return
+		}
+		resp := JobResponse{PollAfter: 42}
+		if len(f.jobs) > 0 {
+			resp.Job = &f.jobs[0]
+			f.jobs = f.jobs[1:]
+		}
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == http.MethodPost && len(r.URL.Path) > len("/jobs/"):
+		var result JobResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.results[r.URL.Path] = result
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func useFakeAPI(t *testing.T, h http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	old := baseAPI
+	baseAPI = srv.URL + "/"
+	t.Cleanup(func() {
+		baseAPI = old
+		srv.Close()
+	})
+}
+
+func TestAgentRunsJobsAndReports(t *testing.T) {
+	fake := &fakeJobServer{
+		jobs:    []ScanJob{{JobID: "job-7", Email: "it@example.com", Policy: "basic", Targets: []string{"192.168.1.20"}}},
+		results: map[string]JobResult{},
+	}
+	useFakeAPI(t, fake)
+
+	var ran []scanOptions
+	a := &agent{id: "agent-1", hostname: "host", execute: func(ctx context.Context, opts scanOptions) scanResult {
+		ran = append(ran, opts)
+		return scanResult{ExitCode: exitOK, ScanID: 99, Final: scanProgress{Critical: 1, High: 2, Info: 5}, Duration: 90 * time.Second}
+	}}
+
+	if wait := a.runOnce(context.Background()); wait != 0 {
+		t.Errorf("wait after a job = %s, want 0", wait)
+	}
+	if len(ran) != 1 || !ran[0].Unattended || ran[0].Email != "it@example.com" || ran[0].Policy != "basic" || len(ran[0].Targets) != 1 {
+		t.Fatalf("executed %+v", ran)
+	}
+	got, ok := fake.results["/jobs/job-7/result"]
+	if !ok {
+		t.Fatalf("no result reported, got %v", fake.results)
+	}
+	want := JobResult{AgentID: "agent-1", ExitCode: exitOK, ScanID: 99, Critical: 1, High: 2, Info: 5, Duration: 90}
+	if got != want {
+		t.Errorf("reported %+v, want %+v", got, want)
+	}
+
+	// With the queue empty the agent waits as long as the server asks.
+	if wait := a.runOnce(context.Background()); wait != 42*time.Second {
+		t.Errorf("wait with no jobs = %s, want 42s", wait)
+	}
+	if len(ran) != 1 {
+		t.Errorf("executed %d jobs, want 1", len(ran))
+	}
+}
+
+func TestAgentReportsFailure(t *testing.T) {
+	fake := &fakeJobServer{jobs: []ScanJob{{JobID: "job-8", Email: "it@example.com"}}, results: map[string]JobResult{}}
+	useFakeAPI(t, fake)
+
+	a := &agent{id: "agent-1", execute: func(ctx context.Context, opts scanOptions) scanResult {
+		return scanResult{ExitCode: exitScanAborted, Err: &scanEndedError{State: stateAborted}}
+	}}
+	a.runOnce(context.Background())
+
+	got := fake.results["/jobs/job-8/result"]
+	if got.ExitCode != exitScanAborted || got.Error != "scan aborted" {
+		t.Errorf("reported %+v", got)
+	}
+}
+
+func TestAgentStopsWhenCancelled(t *testing.T) {
+	fake := &fakeJobServer{results: map[string]JobResult{}}
+	useFakeAPI(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &agent{id: "agent-1", execute: runScan}
+	done := make(chan error, 1)
+	go func() { done <- a.run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("run() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after cancel")
+	}
+	if fake.polls != 1 {
+		t.Errorf("polls = %d, want 1", fake.polls)
+	}
+}
+
+func TestCopyConfig(t *testing.T) {
+	oldFile, oldDir := configFile, dataDirOverride
+	t.Cleanup(func() { configFile, dataDirOverride = oldFile, oldDir })
+	dataDirOverride = t.TempDir()
+	configFile = filepath.Join(t.TempDir(), "client_config.json")
+	if err := ioutil.WriteFile(configFile, []byte(`{"policy": "basic"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := copyConfig()
+	if err != nil || !filepath.IsAbs(path) || filepath.Dir(path) != dataDirOverride {
+		t.Fatalf("copyConfig() = %q, %v", path, err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != `{"policy": "basic"}` {
+		t.Errorf("copied config = %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("copied config mode = %v, %v", info.Mode(), err)
+	}
+
+	// Without a config file the service falls back to the defaults.
+	os.Remove(configFile)
+	if _, err := copyConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stale config copy left: %v", err)
+	}
+}
+
+func TestRemoveInstalledFiles(t *testing.T) {
+	oldDir := dataDirOverride
+	t.Cleanup(func() { dataDirOverride = oldDir })
+	dataDirOverride = t.TempDir()
+	binary, _ := installedBinaryPath()
+	config, _ := installedConfigPath()
+	for _, path := range []string{binary, config} {
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeInstalledFiles()
+	for _, path := range []string{binary, config} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left after uninstall: %v", path, err)
+		}
+	}
+}
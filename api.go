@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryPolicy describes how a single API call is retried. Every request to
+// baseAPI goes through apiRequest with one of the policies below so that the
+// client behaves the same way whichever endpoint is having a bad day.
+type retryPolicy struct {
+	MaxAttempts    int           // total attempts, including the first
+	BaseDelay      time.Duration // delay before the first retry, doubled each time
+	MaxDelay       time.Duration // upper bound for a single backoff delay
+	AttemptTimeout time.Duration // timeout for one HTTP round trip
+	Deadline       time.Duration // overall budget for the call including retries
+}
+
+var (
+	// statusPolicy is used for the API health check before anything is scanned.
+	statusPolicy = retryPolicy{MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 10 * time.Second, AttemptTimeout: 5 * time.Second, Deadline: 45 * time.Second}
+	// pollPolicy is used for scan status polling, which can tolerate a long outage.
+	pollPolicy = retryPolicy{MaxAttempts: 20, BaseDelay: 2 * time.Second, MaxDelay: 60 * time.Second, AttemptTimeout: 15 * time.Second, Deadline: 10 * time.Minute}
+	// mutatePolicy is used for calls that create, export, stop or delete scans.
+	mutatePolicy = retryPolicy{MaxAttempts: 6, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second, AttemptTimeout: 30 * time.Second, Deadline: 3 * time.Minute}
+	// createPolicy creates something on a scanner that ignores the
+	// Idempotency-Key header, where a retry after a lost reply would create
+	// it twice. The caller looks for it by name instead.
+	createPolicy = retryPolicy{MaxAttempts: 1, AttemptTimeout: 30 * time.Second, Deadline: time.Minute}
+)
+
+// apiError is returned when the API answers with a non-200 status code.
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("received non-200 status code: %d %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether a request that failed with this status code is
+// worth sending again.
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before retry number attempt (starting at 1) using
+// exponential backoff with full jitter.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date. It returns zero when the header is absent or invalid.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// newIdempotencyKey returns a random key sent with create_scan so that a
+// retried request does not start a second scan on the server.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// apiServer is a JSON API the client sends requests to: the smbdefence API
+// or a scanner driven directly.
+type apiServer struct {
+	base    string
+	client  *http.Client
+	headers map[string]string // sent with every request, such as API keys
+	// dryRunResponse returns the response assumed for a request in a dry run.
+	dryRunResponse func(method, path string) (string, bool)
+}
+
+// smbdefenceAPI is the API at baseAPI.
+func smbdefenceAPI() apiServer {
+	return apiServer{base: baseAPI, client: apiClient, dryRunResponse: smbdefenceDryRunResponse}
+}
+
+// apiRequest sends a request to baseAPI+path, retrying according to the
+// policy. in is marshaled as the JSON body when non-nil and the response body
+// is unmarshaled into out when non-nil, or copied as is if out is a *[]byte.
+func apiRequest(ctx context.Context, method, path string, in, out interface{}, headers map[string]string, policy retryPolicy) error {
+	return smbdefenceAPI().request(ctx, method, path, in, out, headers, policy)
+}
+
+// request sends a request to the server as apiRequest does.
+func (s apiServer) request(ctx context.Context, method, path string, in, out interface{}, headers map[string]string, policy retryPolicy) (err error) {
+	var payload []byte
+	if in != nil {
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error marshaling JSON: %v", err)
+		}
+	}
+
+	if dryRun {
+		return s.dryRunRequest(method, path, payload, out)
+	}
+	defer func() {
+		action := method + " " + path
+		if payload != nil {
+			action += " " + redactJSON(payload)
+		}
+		audit("api", action, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, policy.Deadline)
+	defer cancel()
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		body, wait, err := s.attempt(ctx, method, path, payload, headers, policy.AttemptTimeout)
+		if err == nil {
+			if raw, ok := out.(*[]byte); ok {
+				*raw = body
+				return nil
+			}
+			if out != nil {
+				if err := json.Unmarshal(body, out); err != nil {
+					return fmt.Errorf("error unmarshaling JSON response: %v", err)
+				}
+			}
+			return nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && !retryable(apiErr.StatusCode) {
+			return err
+		}
+		if attempt == policy.MaxAttempts {
+			break
+		}
+
+		delay := policy.backoff(attempt)
+		if wait > delay {
+			delay = wait
+		}
+		debugPrint("%s %s failed (attempt %d/%d): %v\nRetrying in %s\n", method, path, attempt, policy.MaxAttempts, err, delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s %s: %v: %v", method, path, ctx.Err(), lastErr)
+	}
+	return fmt.Errorf("%s %s: giving up after %d attempts: %v", method, path, policy.MaxAttempts, lastErr)
+}
+
+// attempt performs a single round trip. The returned duration is the
+// server's Retry-After hint, if any.
+func (s apiServer) attempt(ctx context.Context, method, path string, payload []byte, headers map[string]string, timeout time.Duration) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, s.base+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error creating %s request: %v", method, err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range []map[string]string{s.headers, headers} {
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error sending %s request: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, retryAfter(resp.Header.Get("Retry-After")), &apiError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+	return body, 0, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 8 * time.Second, 80: 8 * time.Second} {
+		for i := 0; i < 100; i++ {
+			// Full jitter keeps at least half of the delay.
+			if d := p.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, d, max/2, max)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for header, want := range map[string]time.Duration{"": 0, "0": 0, "5": 5 * time.Second, "-1": 0, "soon": 0, "Mon, 02 Jan 2006 15:04:05 GMT": 0} {
+		if got := retryAfter(header); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", header, got, want)
+		}
+	}
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if got := retryAfter(date); got < 28*time.Second || got > 30*time.Second {
+		t.Errorf("retryAfter(%q) = %s, want about 30s", date, got)
+	}
+}
+
+// useRetryServer serves the status codes in turn, repeating the last one,
+// and returns the API and the number of requests received.
+func useRetryServer(t *testing.T, codes ...int) (apiServer, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&requests, 1)) - 1
+		if i >= len(codes) {
+			i = len(codes) - 1
+		}
+		w.WriteHeader(codes[i])
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	return apiServer{base: srv.URL + "/", client: srv.Client()}, &requests
+}
+
+var fastPolicy = retryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, AttemptTimeout: time.Second, Deadline: 5 * time.Second}
+
+func TestRequestRetries(t *testing.T) {
+	api, requests := useRetryServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	if err := api.request(context.Background(), http.MethodGet, "status", nil, nil, nil, fastPolicy); err != nil || *requests != 3 {
+		t.Errorf("request() = %v after %d requests, want success after 3", err, *requests)
+	}
+
+	api, requests = useRetryServer(t, http.StatusBadGateway)
+	if err := api.request(context.Background(), http.MethodGet, "status", nil, nil, nil, fastPolicy); err == nil || !strings.Contains(err.Error(), "giving up after 5 attempts") || *requests != 5 {
+		t.Errorf("request() = %v after %d requests, want to give up after 5", err, *requests)
+	}
+}
+
+func TestRequestStopsOnClientErrors(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict} {
+		api, requests := useRetryServer(t, code)
+		err := api.request(context.Background(), http.MethodPost, "create_scan", nil, nil, nil, fastPolicy)
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != code || *requests != 1 {
+			t.Errorf("request() on %d = %v after %d requests, want the error after 1", code, err, *requests)
+		}
+	}
+}
+
+func TestRequestStopsAtDeadline(t *testing.T) {
+	api, _ := useRetryServer(t, http.StatusServiceUnavailable)
+	policy := retryPolicy{MaxAttempts: 1000, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, AttemptTimeout: time.Second, Deadline: 100 * time.Millisecond}
+	start := time.Now()
+	err := api.request(context.Background(), http.MethodGet, "status", nil, nil, nil, policy)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("request() = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request() took %s with a deadline of %s", elapsed, policy.Deadline)
+	}
+}
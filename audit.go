@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the audit log. Each entry carries the hash of
+// the one before it, so editing, removing or reordering entries breaks the
+// chain. The hashes are HMACs keyed with auditKey, which is kept in its own
+// file: whoever can change the log but not read the key cannot forge a
+// chain. Root can read both, so the chain does not stop an administrator
+// of this machine from rewriting the log.
+type auditEntry struct {
+	Seq    int       `json:"seq"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"` // command, file, registry, api, undo or consent
+	Action string    `json:"action"`
+	Result string    `json:"result"` // "ok" or the error
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+}
+
+// computeHash returns the HMAC of the entry without its own hash.
+func (e auditEntry) computeHash(key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditLog appends entries to audit.jsonl in the data directory. The file
+// is only ever opened for appending.
+type auditLog struct {
+	mu       sync.Mutex
+	reported bool // a write error was already shown
+}
+
+// auditTrail records what the client does to this machine. It is nil, and
+// nothing is recorded, in dry runs and in tests that do not set it.
+var auditTrail *auditLog
+
+func auditPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+func auditKeyPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit_key"), nil
+}
+
+// loadAuditKey reads the key the audit log is hashed with, generating and
+// storing one on first use when create is set.
+func loadAuditKey(path string, create bool) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("audit key %s is damaged", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, fmt.Errorf("error reading audit key: %v", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating audit key: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("error writing audit key: %v", err)
+	}
+	return key, nil
+}
+
+// audit records an action and its outcome. A failure to write the log is
+// reported once and does not stop the run.
+func audit(kind, action string, err error) {
+	if auditTrail == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	auditTrail.mu.Lock()
+	defer auditTrail.mu.Unlock()
+	if err := appendAuditEntry(kind, redactSecrets(action), redactSecrets(result)); err != nil && !auditTrail.reported {
+		fmt.Println("Error writing the audit log:", err)
+		auditTrail.reported = true
+	}
+}
+
+func appendAuditEntry(kind, action, result string) error {
+	path, err := auditPath()
+	if err != nil {
+		return err
+	}
+	keyPath, err := auditKeyPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+	// The scheduler, the agent service and an interactive run may all be
+	// writing, so the last entry is read and the next one appended under a
+	// lock on the file.
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("error locking audit log: %v", err)
+	}
+	defer unlockFile(f)
+
+	key, err := loadAuditKey(keyPath, true)
+	if err != nil {
+		return err
+	}
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+	e := auditEntry{Seq: last.Seq + 1, Time: time.Now().UTC(), Kind: kind, Action: action, Result: result, Prev: last.Hash}
+	e.Hash = e.computeHash(key)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshaling audit entry: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %v", err)
+	}
+	return nil
+}
+
+// lastAuditEntry returns the last entry of the log, or a zero entry when
+// the log is empty.
+func lastAuditEntry(f *os.File) (auditEntry, error) {
+	// Entries are short, so the last one is within the tail of the file.
+	const tail = 64 << 10
+	info, err := f.Stat()
+	if err != nil {
+		return auditEntry{}, fmt.Errorf("error reading audit log: %v", err)
+	}
+	offset := info.Size() - tail
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return auditEntry{}, fmt.Errorf("error reading audit log: %v", err)
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return auditEntry{}, nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	var last auditEntry
+	if err := json.Unmarshal(data, &last); err != nil {
+		return auditEntry{}, fmt.Errorf("last audit entry is damaged: %v", err)
+	}
+	return last, nil
+}
+
+// verifyAudit checks every entry of the log against its hash and the hash
+// of the entry before it. It returns the last valid entry and the number of
+// entries checked.
+func verifyAudit(r io.Reader, key []byte) (auditEntry, int, error) {
+	var prev auditEntry
+	n := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		n++
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return prev, n - 1, fmt.Errorf("line %d is not a valid entry: %v", n, err)
+		}
+		switch {
+		case !hmac.Equal([]byte(e.Hash), []byte(e.computeHash(key))):
+			return prev, n - 1, fmt.Errorf("line %d (entry %d) was modified: its hash does not match", n, e.Seq)
+		case e.Seq != prev.Seq+1 || e.Prev != prev.Hash:
+			return prev, n - 1, fmt.Errorf("line %d (entry %d) does not follow entry %d: entries were removed, added or reordered", n, e.Seq, prev.Seq)
+		}
+		prev = e
+	}
+	if err := scanner.Err(); err != nil {
+		return prev, n, fmt.Errorf("error reading audit log: %v", err)
+	}
+	return prev, n, nil
+}
+
+// verifyAuditCommand implements "verify-audit".
+func verifyAuditCommand(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	file := fs.String("file", "", "Audit log to verify, such as a copy sent by a customer (default: this machine's log)")
+	keyFile := fs.String("key", "", "Key the audit log was written with, such as the audit_key sent with a copy (default: this machine's key)")
+	if err := fs.Parse(args); err != nil {
+		return exitFailure
+	}
+	path, keyPath := *file, *keyFile
+	if path == "" || keyPath == "" {
+		if !isPrivileged() {
+			fmt.Println("Please run as root or administrator to read the audit log.")
+			return exitPrivileges
+		}
+		var err error
+		if path == "" {
+			path, err = auditPath()
+		}
+		if err == nil && keyPath == "" {
+			keyPath, err = auditKeyPath()
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return exitFailure
+		}
+	}
+	key, err := loadAuditKey(keyPath, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFailure
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error opening audit log:", err)
+		return exitFailure
+	}
+	defer f.Close()
+	last, n, err := verifyAudit(f, key)
+	if err != nil {
+		fmt.Printf("Audit log %s is NOT intact after %d valid entries: %v\n", path, n, err)
+		return exitFailure
+	}
+	if n == 0 {
+		fmt.Printf("Audit log %s is empty.\n", path)
+		return exitOK
+	}
+	// Removing entries from the end cannot be detected from the log alone,
+	// so the last hash is shown to compare with an earlier copy.
+	fmt.Printf("Audit log %s is intact: %d entries, the last at %s with hash %s.\n", path, n, last.Time.Format(time.RFC3339), last.Hash)
+	return exitOK
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeAuditLog records a few entries in a temporary data directory and
+// returns the lines of the log and its key.
+func writeAuditLog(t *testing.T) ([]string, []byte) {
+	t.Helper()
+	oldDataDir, oldAudit := dataDirOverride, auditTrail
+	t.Cleanup(func() { dataDirOverride, auditTrail = oldDataDir, oldAudit })
+	dataDirOverride = t.TempDir()
+	auditTrail = &auditLog{}
+
+	audit("command", "net start Winmgmt", nil)
+	audit("command", "netbird up --setup-key "+netbirdSetupKey, nil)
+	audit("api", "POST create_scan", errors.New("received non-200 status code: 500"))
+	audit("undo", "uninstall tunnel", nil)
+
+	return readAuditLog(t)
+}
+
+func readAuditLog(t *testing.T) ([]string, []byte) {
+	t.Helper()
+	path, _ := auditPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath, _ := auditKeyPath()
+	key, err := loadAuditKey(keyPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n"), key
+}
+
+func TestAuditChain(t *testing.T) {
+	lines, key := writeAuditLog(t)
+	last, n, err := verifyAudit(strings.NewReader(strings.Join(lines, "\n")), key)
+	if err != nil || n != 4 || last.Seq != 4 || last.Action != "uninstall tunnel" {
+		t.Fatalf("verifyAudit() = %+v, %d, %v", last, n, err)
+	}
+	if strings.Contains(lines[1], netbirdSetupKey) {
+		t.Errorf("audit log contains the setup key: %s", lines[1])
+	}
+}
+
+func TestAuditTampering(t *testing.T) {
+	lines, key := writeAuditLog(t)
+	// An edited entry hashed again without the key.
+	var forged auditEntry
+	if err := json.Unmarshal([]byte(lines[1]), &forged); err != nil {
+		t.Fatal(err)
+	}
+	forged.Action = "netbird down"
+	forged.Hash = forged.computeHash(nil)
+	rehashed, _ := json.Marshal(forged)
+
+	tests := map[string][]string{
+		"rehashed":  {lines[0], string(rehashed), lines[2], lines[3]},
+		"edited":    {lines[0], strings.Replace(lines[1], "netbird up", "netbird down", 1), lines[2], lines[3]},
+		"removed":   {lines[0], lines[2], lines[3]},
+		"reordered": {lines[0], lines[2], lines[1], lines[3]},
+		"damaged":   {lines[0], lines[1][:20], lines[2], lines[3]},
+	}
+	for name, tampered := range tests {
+		_, n, err := verifyAudit(strings.NewReader(strings.Join(tampered, "\n")), key)
+		if err == nil {
+			t.Errorf("%s: verifyAudit() found nothing wrong", name)
+		} else if n != 1 {
+			t.Errorf("%s: verifyAudit() stopped after %d valid entries, want 1: %v", name, n, err)
+		}
+	}
+}
+
+func TestAuditConcurrentWriters(t *testing.T) {
+	oldDataDir := dataDirOverride
+	defer func() { dataDirOverride = oldDataDir }()
+	dataDirOverride = t.TempDir()
+
+	// Each writer opens the log on its own, like separate processes, so
+	// only the file lock keeps the chain in order.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := appendAuditEntry("command", fmt.Sprintf("writer %d entry %d", i, j), "ok"); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines, key := readAuditLog(t)
+	if _, n, err := verifyAudit(strings.NewReader(strings.Join(lines, "\n")), key); err != nil || n != 400 {
+		t.Errorf("verifyAudit() = %d entries, %v", n, err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scanBackend is the service that runs the scans. The smbdefence API is the
+// default; a scanner run by the user can be driven directly instead.
+type scanBackend interface {
+	// Online reports whether the backend is ready to scan.
+	Online(ctx context.Context) bool
+	// Policies returns the scan policies offered.
+	Policies(ctx context.Context) []ScanPolicy
+	// Start creates a scan of this machine and the targets and starts it.
+	Start(ctx context.Context, req scanRequest) (int, error)
+	// Status returns the latest status of the scan for this machine.
+	Status(ctx context.Context, scanID int) (scanProgress, error)
+	// Resume resumes a paused scan.
+	Resume(ctx context.Context, scanID int) error
+	// Updates returns status updates pushed by the backend. The channel is
+	// closed when the backend stops pushing or never does, and the status
+	// is then polled.
+	Updates(ctx context.Context, scanID int) <-chan scanProgress
+	// Delete stops the scan if it is still running and deletes it.
+	Delete(ctx context.Context, scanID int) error
+	// Export delivers the report of a completed scan.
+	Export(ctx context.Context, scanID int, email string, options ExportOptions) error
+	// Findings returns the findings of a completed scan.
+	Findings(ctx context.Context, scanID int) ([]HostFindings, error)
+}
+
+// scanRequest is what a scan is created with.
+type scanRequest struct {
+	Email    string
+	Policy   string
+	Username string
+	Password string // an NTLM hash on Windows
+	Targets  []string
+	Consent  *ConsentRecord
+}
+
+// backend is the backend the scans run on, chosen by the config.
+var backend scanBackend = smbdefenceBackend{}
+
+// BackendConfig chooses the scanner. The smbdefence API needs no settings;
+// a Nessus scanner is reached at URL with an API key pair and a Greenbone
+// one with a username and password.
+type BackendConfig struct {
+	// Type is smbdefence (the default), nessus or greenbone.
+	Type string `json:"type,omitempty"`
+	// URL is the address of the scanner, such as https://nessus:8834/, or
+	// tls://greenbone:9390 or unix:///run/gvmd/gvmd.sock for Greenbone.
+	URL string `json:"url,omitempty"`
+	// AccessKey and SecretKey are the scanner's API keys. They are read
+	// from NESSUS_ACCESS_KEY and NESSUS_SECRET_KEY when not set.
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	// Username and Password log in to a Greenbone scanner. The password is
+	// read from GMP_PASSWORD when not set.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// PortList is the UUID of the Greenbone port list to scan, All IANA
+	// assigned TCP by default.
+	PortList string `json:"port_list,omitempty"`
+	// CAFile is a PEM file with the certificate authority of the scanner's
+	// certificate, which is self-signed by default.
+	CAFile string `json:"ca_file,omitempty"`
+	// Insecure skips the verification of the scanner's certificate.
+	Insecure bool `json:"insecure,omitempty"`
+	// Templates limits the Nessus scan templates or Greenbone scan configs
+	// offered to these names.
+	Templates []string `json:"templates,omitempty"`
+	// TunnelSetupKey joins the tunnel network the scanner is on instead of
+	// the smbdefence one.
+	TunnelSetupKey string `json:"tunnel_setup_key,omitempty"`
+	// ReportDir is where reports are saved, a reports directory in the data
+	// directory by default.
+	ReportDir string `json:"report_dir,omitempty"`
+}
+
+// newBackend returns the backend described by the config.
+func newBackend(cfg BackendConfig) (scanBackend, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", "smbdefence":
+		return smbdefenceBackend{}, nil
+	case "nessus":
+		return newNessusBackend(cfg)
+	case "greenbone", "openvas", "gmp":
+		return newGMPBackend(cfg)
+	}
+	return nil, fmt.Errorf("unknown backend %q, expected smbdefence, nessus or greenbone", cfg.Type)
+}
+
+// checkExport checks that the backend can deliver the report asked for,
+// for backends that cannot deliver every format.
+func checkExport(b scanBackend, options ExportOptions) error {
+	if c, ok := b.(interface{ checkExport(ExportOptions) error }); ok {
+		return c.checkExport(options)
+	}
+	return nil
+}
+
+// routeBackend is a backend that can route LAN targets through this
+// machine's tunnel peer. Only the smbdefence API manages the routes of its
+// tunnel network; a scanner driven directly is on a network of its own.
+type routeBackend interface {
+	AddRoutes(ctx context.Context, networks []string) error
+	DeleteRoutes(ctx context.Context) error
+}
+
+// checkRoutes checks that the backend can reach LAN targets.
+func checkRoutes(b scanBackend) error {
+	if _, ok := b.(routeBackend); !ok {
+		return errors.New("LAN targets can only be scanned with the smbdefence backend, which routes them through the tunnel")
+	}
+	return nil
+}
+
+// configureBackend sets the backend, and the tunnel network it needs, from
+// the config.
+func configureBackend(cfg BackendConfig) error {
+	b, err := newBackend(cfg)
+	if err != nil {
+		return err
+	}
+	backend = b
+	if cfg.TunnelSetupKey != "" {
+		netbirdSetupKey = cfg.TunnelSetupKey
+	}
+	return nil
+}
+
+// scannerTLSConfig trusts the scanner's own certificate authority, or any
+// certificate when Insecure is set. It is nil when neither is set.
+func scannerTLSConfig(cfg BackendConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && !cfg.Insecure {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// scannerClient returns a client for a scanner with its own certificate.
+// The scanner is reached over the tunnel, which no proxy can reach, so the
+// client connects to it directly.
+func scannerClient(cfg BackendConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	tlsConfig, err := scannerTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// noUpdates is the status stream of a backend that does not push updates.
+func noUpdates() <-chan scanProgress {
+	updates := make(chan scanProgress)
+	close(updates)
+	return updates
+}
+
+// saveReport saves a report downloaded from a scanner to dir, or to the
+// reports directory in the data directory when dir is empty.
+func saveReport(dir string, scanID int, format string, data []byte) error {
+	if dir == "" && dryRun {
+		// The data directory is not created in a dry run.
+		dir = filepath.Join("[data directory]", "reports")
+	} else if dir == "" {
+		data, err := dataDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(data, "reports")
+	}
+	path := filepath.Join(dir, fmt.Sprintf("scan-%d.%s", scanID, format))
+	if dryRun {
+		planf("save the report to %s", path)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating report directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error saving report: %v", err)
+	}
+	fmt.Print(tr("Report saved to %s\n", path))
+	return nil
+}
+
+// smbdefenceBackend runs scans through the smbdefence API at baseAPI.
+type smbdefenceBackend struct{}
+
+func (smbdefenceBackend) Online(ctx context.Context) bool {
+	return checkAPIStatus(ctx)
+}
+
+func (smbdefenceBackend) Policies(ctx context.Context) []ScanPolicy {
+	return fetchPolicies(ctx)
+}
+
+func (smbdefenceBackend) Start(ctx context.Context, req scanRequest) (int, error) {
+	if req.Consent != nil {
+		if err := registerSigningKey(ctx, req.Consent); err != nil {
+			return 0, err
+		}
+	}
+	return startScan(ctx, req.Email, req.Policy, req.Username, req.Password, req.Targets, req.Consent)
+}
+
+func (smbdefenceBackend) Status(ctx context.Context, scanID int) (scanProgress, error) {
+	return getScanStatus(ctx, scanID)
+}
+
+func (smbdefenceBackend) Resume(ctx context.Context, scanID int) error {
+	return resumeScan(ctx, scanID)
+}
+
+func (smbdefenceBackend) Updates(ctx context.Context, scanID int) <-chan scanProgress {
+	return streamScanStatus(ctx, scanID)
+}
+
+func (smbdefenceBackend) Delete(ctx context.Context, scanID int) error {
+	return deleteScan(ctx, scanID)
+}
+
+// Export asks the API to email the report once the server has processed
+// the scan.
+func (smbdefenceBackend) Export(ctx context.Context, scanID int, email string, options ExportOptions) error {
+	if err := waitExportReady(ctx, scanID); err != nil {
+		return err
+	}
+	return exportReport(ctx, scanID, email, options)
+}
+
+func (smbdefenceBackend) Findings(ctx context.Context, scanID int) ([]HostFindings, error) {
+	return downloadFindings(ctx, scanID)
+}
+
+func (smbdefenceBackend) AddRoutes(ctx context.Context, networks []string) error {
+	return advertiseRoutes(ctx, networks)
+}
+
+func (smbdefenceBackend) DeleteRoutes(ctx context.Context) error {
+	return withdrawRoutes(ctx)
+}
+
+// tunnelAddress returns this machine's address on the tunnel network, which
+// a scanner driven directly scans.
+var tunnelAddress = func(ctx context.Context) (string, error) {
+	if dryRun {
+		return "100.64.0.1", nil
+	}
+	output, err := runner.Run(ctx, tempBinaryPath, "status", "--json")
+	if err != nil {
+		return "", fmt.Errorf("error reading the tunnel status: %v", err)
+	}
+	var status struct {
+		IP string `json:"netbirdIp"`
+	}
+	if err := json.Unmarshal(output, &status); err != nil || status.IP == "" {
+		return "", fmt.Errorf("the tunnel did not report its address: %s", strings.TrimSpace(string(output)))
+	}
+	// The address is given with the network's prefix length.
+	ip, _, _ := strings.Cut(status.IP, "/")
+	return ip, nil
+}
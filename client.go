@@ -2,26 +2,23 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"embed"
-	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/QMUL/ntlmgen"
-	"github.com/schollz/progressbar/v3"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -34,17 +31,23 @@ var netbirdWindows embed.FS
 //go:embed netbird/macosx/amd/x64/netbird
 var netbirdMacOS embed.FS
 
-const baseAPI = "http://api.smbdefence.com/"
+var baseAPI = "http://api.smbdefence.com/"
 
 type ScanRequest struct {
-	Email           string  `json:"email"`
-	Username        *string `json:"username,omitempty"`
-	Password        *string `json:"password,omitempty"`
-	OperatingSystem string  `json:"operating_system"`
+	Email           string   `json:"email"`
+	Username        *string  `json:"username,omitempty"`
+	Password        *string  `json:"password,omitempty"`
+	OperatingSystem string   `json:"operating_system"`
+	Policy          string   `json:"policy,omitempty"`
+	Targets         []string `json:"targets,omitempty"`
+	// Consent is the signed record of the changes the user accepted, for
+	// interactive runs.
+	Consent *ConsentRecord `json:"consent,omitempty"`
 }
 
 type ScanStatusResponse struct {
 	Hosts []struct {
+		Hostname            string `json:"hostname"`
 		Critical            int    `json:"critical"`
 		High                int    `json:"high"`
 		Medium              int    `json:"medium"`
@@ -55,6 +58,7 @@ type ScanStatusResponse struct {
 	} `json:"hosts"`
 	Info struct {
 		Status string `json:"status"`
+		Phase  string `json:"phase"`
 	} `json:"info"`
 }
 
@@ -63,8 +67,13 @@ type ScanResponse struct {
 }
 
 type ExportRequest struct {
-	ScanID int    `json:"scan_id"`
-	Email  string `json:"email"`
+	ScanID      int      `json:"scan_id"`
+	Email       string   `json:"email"`
+	Recipients  []string `json:"recipients,omitempty"`
+	CC          []string `json:"cc,omitempty"`
+	Formats     []string `json:"formats"`
+	Chapters    []string `json:"chapters,omitempty"`
+	MinSeverity int      `json:"min_severity,omitempty"`
 }
 
 type debugWriter struct{}
@@ -81,35 +90,46 @@ func capitalizeFirstLetter(s string) string {
 	return strings.ToUpper(string(s[0])) + s[1:]
 }
 
-func getScanStatus(scanID int) (int, int, int, int, int, int, string, string, error) {
-	resp, err := http.Get(fmt.Sprintf("%sscan_status/%d", baseAPI, scanID))
-	if err != nil {
-		return 0, 0, 0, 0, 0, 0, "", "", fmt.Errorf("error sending GET request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return 0, 0, 0, 0, 0, 0, "", "", fmt.Errorf("error reading response body: %v", err)
-	}
-
+func getScanStatus(ctx context.Context, scanID int) (scanProgress, error) {
 	var scanStatus ScanStatusResponse
-	err = json.Unmarshal(body, &scanStatus)
+	err := apiRequest(ctx, http.MethodGet, fmt.Sprintf("scan_status/%d", scanID), nil, &scanStatus, nil, pollPolicy)
 	if err != nil {
-		return 0, 0, 0, 0, 0, 0, "", "", fmt.Errorf("error unmarshaling JSON response: %v", err)
+		return scanProgress{}, err
 	}
-	if len(scanStatus.Hosts) > 0 {
-		host := scanStatus.Hosts[0]
-		return host.Critical, host.High, host.Medium, host.Low, host.Info, host.ScanProgressCurrent, host.Progress, scanStatus.Info.Status, nil
-	} else {
-		return 0, 0, 0, 0, 0, 0, "", "", fmt.Errorf("error unmarshaling JSON response: %v", err)
+	return scanStatus.progress(), nil
+}
+
+// progress returns the status of the whole scan: the findings of every host
+// scanned, this machine and any LAN targets, and their average progress.
+func (s ScanStatusResponse) progress() scanProgress {
+	progress := scanProgress{State: scanState(s.Info.Status), Phase: s.Info.Phase}
+	// The scanner may not have reported on the hosts yet.
+	for _, host := range s.Hosts {
+		progress.Critical += host.Critical
+		progress.High += host.High
+		progress.Medium += host.Medium
+		progress.Low += host.Low
+		progress.Info += host.Info
+		progress.Current += host.ScanProgressCurrent
+	}
+	switch len(s.Hosts) {
+	case 0:
+	case 1:
+		progress.Percentage = s.Hosts[0].Progress
+	default:
+		progress.Current /= len(s.Hosts)
+		progress.Percentage = fmt.Sprintf("%d%%", progress.Current)
 	}
+	return progress
 }
 
-func startScan(email, username, password string) int {
+func startScan(ctx context.Context, email, policy, username, password string, targets []string, consent *ConsentRecord) (int, error) {
 	var reqBody ScanRequest
+	reqBody.Consent = consent
 
 	reqBody.Email = email
+	reqBody.Policy = policy
+	reqBody.Targets = targets
 	if username != "" {
 		reqBody.Username = &username
 	}
@@ -118,121 +138,280 @@ func startScan(email, username, password string) int {
 	}
 	reqBody.OperatingSystem = capitalizeFirstLetter(runtime.GOOS)
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		os.Exit(1)
-	}
+	// The same key is sent with every retry so the server creates one scan only.
+	headers := map[string]string{"Idempotency-Key": newIdempotencyKey()}
 
-	resp, err := http.Post(baseAPI+"create_scan", "application/json", bytes.NewBuffer(jsonData))
+	var scanResponse ScanResponse
+	err := apiRequest(ctx, http.MethodPost, "create_scan", reqBody, &scanResponse, headers, mutatePolicy)
 	if err != nil {
-		fmt.Println("Error sending POST request:", err)
-		os.Exit(1)
+		return 0, fmt.Errorf("error creating scan: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error: received non-200 status code:", resp.StatusCode)
-		os.Exit(1)
-	}
+	return scanResponse.ScanID, nil
+}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		os.Exit(1)
-	}
+// netbirdSetupKey joins the tunnel network of the backend.
+var netbirdSetupKey = "31847937-F42C-421D-88E5-248096337E2C"
 
-	var scanResponse ScanResponse
-	err = json.Unmarshal(bodyBytes, &scanResponse)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		os.Exit(1)
+// netbirdServiceKey is the registry key of the tunnel service on Windows.
+const netbirdServiceKey = `HKLM\SYSTEM\CurrentControlSet\Services\netbird`
+
+// netbirdDropIn and netbirdPlist give the tunnel service its proxy
+// settings on Linux and macOS. Only root can read them, so the proxy
+// password is not shown to other users, and no other service is given it.
+var (
+	netbirdDropIn = "/etc/systemd/system/netbird.service.d/proxy.conf"
+	netbirdPlist  = "/Library/LaunchDaemons/netbird.plist"
+)
+
+// writeServiceEnvironment gives the Windows tunnel service its environment
+// through the registry; a variable so tests can replace it.
+var writeServiceEnvironment = setServiceEnvironment
+
+// tunnelInstallCommands returns the commands that install and connect the
+// tunnel on goos. env, such as the proxy settings, is given to the tunnel
+// service by writeTunnelEnv once the first command has installed the
+// service.
+func tunnelInstallCommands(goos, binary string, env []string) [][]string {
+	if goos == "windows" {
+		if len(env) == 0 {
+			return [][]string{{"powershell", "-Command",
+				"Start-Process", binary, "'service install'", "-NoNewWindow", "-Wait;",
+				"Start-Process", binary, "'service start'", "-NoNewWindow", "-Wait;",
+				"Start-Process", binary, "'up --setup-key " + netbirdSetupKey + "'", "-NoNewWindow", "-Wait"}}
+		}
+		return [][]string{
+			{"powershell", "-Command", "Start-Process", binary, "'service install'", "-NoNewWindow", "-Wait"},
+			{"powershell", "-Command",
+				"Start-Process", binary, "'service start'", "-NoNewWindow", "-Wait;",
+				"Start-Process", binary, "'up --setup-key " + netbirdSetupKey + "'", "-NoNewWindow", "-Wait"},
+		}
 	}
+	commands := [][]string{{binary, "service", "install"}}
+	// systemd reads the drop-in on the next reload; launchd reads the plist
+	// when the service starts.
+	if len(env) > 0 && goos == "linux" {
+		commands = append(commands, []string{"systemctl", "daemon-reload"})
+	}
+	return append(commands,
+		[]string{binary, "service", "start"},
+		[]string{binary, "up", "--setup-key", netbirdSetupKey},
+	)
+}
 
-	return scanResponse.ScanID
+// tunnelUninstallCommands returns the commands that disconnect and remove
+// the tunnel on goos. The environment written by writeTunnelEnv is removed
+// by removeTunnelEnv first.
+func tunnelUninstallCommands(goos, binary string, env []string) [][]string {
+	if goos == "windows" {
+		return [][]string{{"powershell", "-Command",
+			"Start-Process", binary, "'down'", "-NoNewWindow", "-Wait;",
+			"Start-Process", binary, "'service stop'", "-NoNewWindow", "-Wait;",
+			"Start-Process", binary, "'service uninstall'", "-NoNewWindow", "-Wait"}}
+	}
+	commands := [][]string{
+		{binary, "down"},
+		{binary, "service", "stop"},
+		{binary, "service", "uninstall"},
+	}
+	if len(env) > 0 && goos == "linux" {
+		commands = append(commands, []string{"systemctl", "daemon-reload"})
+	}
+	return commands
 }
 
-func installCommands(tempBinaryPath string) {
-	debugPrint("Running Install commands")
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "linux":
-		installCmd := fmt.Sprintf("%s service install; %s service start; %s up --setup-key 31847937-F42C-421D-88E5-248096337E2C", tempBinaryPath, tempBinaryPath, tempBinaryPath)
-		cmd = exec.Command("bash", "-c", installCmd)
+// writeTunnelEnv gives the installed tunnel service env: the Environment
+// value of its registry key on Windows, which goes away when the service is
+// uninstalled, a systemd drop-in on Linux, and the EnvironmentVariables of
+// its launchd plist on macOS.
+func writeTunnelEnv(goos string, env []string) error {
+	var path string
+	var data []byte
+	switch goos {
 	case "windows":
-		cmd = exec.Command("powershell", "-Command",
-			"Start-Process", tempBinaryPath, "'service install'", "-NoNewWindow", "-Wait;",
-			"Start-Process", tempBinaryPath, "'service start'", "-NoNewWindow", "-Wait;",
-			"Start-Process", tempBinaryPath, "'up --setup-key 31847937-F42C-421D-88E5-248096337E2C'", "-NoNewWindow", "-Wait")
+		if dryRun {
+			planf("write the tunnel service's proxy settings to %s", netbirdServiceKey)
+			return nil
+		}
+		err := writeServiceEnvironment(env)
+		audit("registry", "write "+netbirdServiceKey+" Environment", err)
+		if err != nil {
+			return fmt.Errorf("error writing the tunnel service's proxy settings: %v", err)
+		}
+		return nil
+	case "linux":
+		path = netbirdDropIn
+		// systemd expands % specifiers, which escaped proxy passwords
+		// contain, and unquotes backslashes and double quotes.
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%")
+		var b strings.Builder
+		b.WriteString("[Service]\n")
+		for _, v := range env {
+			fmt.Fprintf(&b, "Environment=\"%s\"\n", quote.Replace(v))
+		}
+		data = []byte(b.String())
 	case "darwin":
-		installCmd := fmt.Sprintf("%s service install; %s service start; %s up --setup-key 31847937-F42C-421D-88E5-248096337E2C", tempBinaryPath, tempBinaryPath, tempBinaryPath)
-		cmd = exec.Command("bash", "-c", installCmd)
+		path = netbirdPlist
+		if !dryRun {
+			plist, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading the tunnel service: %v", err)
+			}
+			if data, err = plistWithEnv(plist, env); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
 	}
+	if dryRun {
+		planf("write the tunnel service's proxy settings to %s", path)
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err == nil {
+		// An existing file keeps its mode on write.
+		err = os.Chmod(path, 0600)
+	}
+	audit("file", "write "+path, err)
+	if err != nil {
+		return fmt.Errorf("error writing the tunnel service's proxy settings: %v", err)
+	}
+	return nil
+}
 
-	cmd.Stdout = debugWriter{}
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
+// removeTunnelEnv removes the systemd drop-in written by writeTunnelEnv.
+// The launchd plist is removed with the service.
+func removeTunnelEnv(goos string) error {
+	if goos != "linux" {
+		return nil
+	}
+	if dryRun {
+		planf("remove %s", netbirdDropIn)
+		return nil
+	}
+	err := os.Remove(netbirdDropIn)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	audit("file", "remove "+netbirdDropIn, err)
 	if err != nil {
-		fmt.Println("Error executing install command:", err)
-		os.Exit(1)
+		return fmt.Errorf("error removing the tunnel service's proxy settings: %v", err)
 	}
+	return nil
 }
 
-func uninstallCommands() {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "linux", "darwin":
-		cmd = exec.Command(tempBinaryPath, "down;", tempBinaryPath, "service stop;", tempBinaryPath, "service uninstall;")
-	case "windows":
-		cmd = exec.Command("powershell", "-Command",
-			"Start-Process", tempBinaryPath, "'down'", "-NoNewWindow", "-Wait;",
-			"Start-Process", tempBinaryPath, "'service stop'", "-NoNewWindow", "-Wait;",
-			"Start-Process", tempBinaryPath, "'service uninstall'", "-NoNewWindow", "-Wait")
+// plistWithEnv adds env to the EnvironmentVariables of a launchd plist.
+func plistWithEnv(plist []byte, env []string) ([]byte, error) {
+	var b strings.Builder
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		b.WriteString("\t\t<key>")
+		xml.EscapeText(&b, []byte(name))
+		b.WriteString("</key>\n\t\t<string>")
+		xml.EscapeText(&b, []byte(value))
+		b.WriteString("</string>\n")
+	}
+	s := string(plist)
+	if i := strings.Index(s, "<key>EnvironmentVariables</key>"); i >= 0 {
+		j := strings.Index(s[i:], "<dict>")
+		if j < 0 {
+			return nil, errors.New("unexpected EnvironmentVariables in the tunnel service")
+		}
+		j += i + len("<dict>")
+		return []byte(s[:j] + "\n" + b.String() + s[j:]), nil
+	}
+	i := strings.LastIndex(s, "</dict>")
+	if i < 0 {
+		return nil, errors.New("the tunnel service is not a launchd plist")
 	}
+	return []byte(s[:i] + "\t<key>EnvironmentVariables</key>\n\t<dict>\n" + b.String() + "\t</dict>\n" + s[i:]), nil
+}
 
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// runEach runs every command even if an earlier one fails, as a shell
+// script does, and returns the error of the last one. The tunnel commands
+// are run this way so that a service left over from an earlier run does not
+// stop the tunnel from coming up.
+func runEach(ctx context.Context, list [][]string) error {
+	var err error
+	for _, c := range list {
+		var output []byte
+		output, err = runner.Run(ctx, c[0], c[1:]...)
+		if err != nil {
+			err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	return err
+}
 
-	err := cmd.Run()
+func installCommands(ctx context.Context, tempBinaryPath string) error {
+	debugPrint("Running Install commands\n")
+	commands := tunnelInstallCommands(runtime.GOOS, tempBinaryPath, tunnelProxyEnv)
+	// The first command installs the service, which is then given its
+	// environment before it starts.
+	err := runEach(ctx, commands[:1])
+	if len(tunnelProxyEnv) > 0 {
+		if err := writeTunnelEnv(runtime.GOOS, tunnelProxyEnv); err != nil {
+			return err
+		}
+	}
+	if len(commands) > 1 {
+		err = runEach(ctx, commands[1:])
+	}
 	if err != nil {
-		fmt.Println("Error executing uninstall command:", err)
-		os.Exit(1)
+		return fmt.Errorf("error executing install command: %v", err)
+	}
+	return nil
+}
+
+func uninstallCommands() error {
+	var envErr error
+	if len(tunnelProxyEnv) > 0 {
+		envErr = removeTunnelEnv(runtime.GOOS)
+	}
+	if err := runEach(context.Background(), tunnelUninstallCommands(runtime.GOOS, tempBinaryPath, tunnelProxyEnv)); err != nil {
+		return fmt.Errorf("error executing uninstall command: %v", err)
 	}
+	return envErr
 }
 
 func privilegesCheck() {
 	if runtime.GOOS == "windows" {
 		if !isAdminWindows() {
-			fmt.Println("Requesting administrator privileges...")
-			cmd := exec.Command("powershell", "-Command", "Start-Process", os.Args[0], "-Verb", "runas")
-			err := cmd.Run()
+			fmt.Println(tr("Requesting administrator privileges..."))
+			_, err := runner.Run(context.Background(), "powershell", "-Command", "Start-Process", os.Args[0], "-Verb", "runas")
 			if err != nil {
 				fmt.Println("Error requesting administrator privileges:", err)
 			}
-			os.Exit(1)
+			os.Exit(exitPrivileges)
 		}
 	} else {
 		if !isRoot() {
 			if runtime.GOOS == "darwin" {
-				fmt.Println("Requesting administrator privileges...")
+				fmt.Println(tr("Requesting administrator privileges..."))
 				executablePath, err := os.Executable()
 				if err != nil {
 					fmt.Println("Error getting executable path:", err)
-					os.Exit(1)
+					os.Exit(exitPrivileges)
 				}
 
-				script := fmt.Sprintf(`tell application "Terminal" to do script "sudo %s"`, executablePath)
-				cmd := exec.Command("osascript", "-e", script)
-				err = cmd.Run()
+				command := "sudo " + executablePath
+				if browserUI {
+					command += " -web"
+				}
+				script := fmt.Sprintf(`tell application "Terminal" to do script "%s"`, command)
+				_, err = runner.Run(context.Background(), "osascript", "-e", script)
 				if err != nil {
 					fmt.Println("Error requesting administrator privileges:", err)
 				}
-				os.Exit(1)
+				os.Exit(exitPrivileges)
 			} else {
-				fmt.Println("Please run as root or with sudo.")
+				fmt.Println(tr("Please run as root or with sudo."))
 			}
-			os.Exit(1)
+			os.Exit(exitPrivileges)
 		}
 	}
 }
@@ -241,13 +420,7 @@ func isRoot() bool {
 	return os.Geteuid() == 0
 }
 
-func installNetbird() string {
-	tempDir, err := ioutil.TempDir("", "netbird")
-	if err != nil {
-		fmt.Println("Error creating temp directory:", err)
-		os.Exit(1)
-	}
-
+func installNetbird() (string, error) {
 	var binaryFS embed.FS
 	var binaryName string
 	switch runtime.GOOS {
@@ -261,115 +434,164 @@ func installNetbird() string {
 		binaryFS = netbirdMacOS
 		binaryName = "netbird/macosx/amd/x64/netbird"
 	default:
-		fmt.Println("Unsupported operating system:", runtime.GOOS)
-		os.Exit(1)
+		return "", fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 
-	binaryFile, err := binaryFS.Open(binaryName)
+	data, err := binaryFS.ReadFile(binaryName)
 	if err != nil {
-		fmt.Println("Error opening binary:", err)
-		os.Exit(1)
+		return "", fmt.Errorf("error reading binary: %v", err)
 	}
 
-	data, err := ioutil.ReadAll(binaryFile)
-	if err != nil {
-		fmt.Println("Error reading binary:", err)
-		os.Exit(1)
+	debugPrint("Binary size: %d bytes\n", len(data))
+
+	if dryRun {
+		path := filepath.Join(os.TempDir(), "netbird123456", filepath.Base(binaryName))
+		planf("write the %d byte netbird binary to %s", len(data), path)
+		return path, nil
 	}
 
-	debugPrint("Binary size: %d bytes\n", len(data))
+	tempDir, err := ioutil.TempDir("", "netbird")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp directory: %v", err)
+	}
 
 	tempBinaryPath := filepath.Join(tempDir, filepath.Base(binaryName))
 
-	debugPrint("Writing binary to", tempBinaryPath)
+	debugPrint("Writing binary to %s\n", tempBinaryPath)
 	err = ioutil.WriteFile(tempBinaryPath, data, 0755)
+	audit("file", "write "+tempBinaryPath, err)
 	if err != nil {
-		fmt.Println("Error writing binary to temp directory:", err)
-		os.Exit(1)
+		removeTempDir(tempDir)
+		return "", fmt.Errorf("error writing binary to temp directory: %v", err)
 	}
 	fileInfo, _ := os.Stat(tempBinaryPath)
 	debugPrint("Written binary size: %d bytes\n", fileInfo.Size())
 
-	return tempBinaryPath
+	return tempBinaryPath, nil
 }
 
 func removeTempFile(tempFilePath string) {
+	if dryRun {
+		planf("remove %s", tempFilePath)
+		return
+	}
 	err := os.Remove(tempFilePath)
+	audit("file", "remove "+tempFilePath, err)
 	if err != nil {
 		fmt.Printf("Error removing temporary file %s: %v\n", tempFilePath, err)
 	}
 }
 
 func removeTempDir(tempDirPath string) {
+	if dryRun {
+		planf("remove %s", tempDirPath)
+		return
+	}
 	err := os.RemoveAll(tempDirPath)
+	audit("file", "remove "+tempDirPath, err)
 	if err != nil {
 		fmt.Printf("Error removing temporary directory %s: %v\n", tempDirPath, err)
 	}
 }
 
-func askForCredentialedScan() bool {
-	reader := bufio.NewReader(os.Stdin)
+// stdin is shared by every prompt so that buffered input is not lost between
+// them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads one line from standard input, giving up when ctx is
+// cancelled so an interrupt is not held up by a pending prompt.
+func readLine(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := stdin.ReadString('\n')
+		ch <- result{line, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		if r.err != nil && r.line == "" {
+			return "", fmt.Errorf("error reading input: %v", r.err)
+		}
+		return r.line, nil
+	}
+}
+
+// readPassword reads a line from the terminal without echoing it. The
+// terminal state is put back if ctx is cancelled while waiting.
+func readPassword(ctx context.Context) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.GetState(fd)
+	if err != nil {
+		// Not a terminal, so there is nothing to hide.
+		line, err := readLine(ctx)
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	type result struct {
+		password []byte
+		err      error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		password, err := terminal.ReadPassword(fd)
+		ch <- result{password, err}
+	}()
+	select {
+	case <-ctx.Done():
+		terminal.Restore(fd, state)
+		return "", ctx.Err()
+	case r := <-ch:
+		if r.err != nil {
+			return "", fmt.Errorf("error reading password: %v", r.err)
+		}
+		return string(r.password), nil
+	}
+}
+
+func askForCredentialedScan(ctx context.Context) (bool, error) {
 	for {
-		fmt.Print("Do you want to run a credentialed/full scan? Y/n: ")
-		input, err := reader.ReadString('\n')
+		fmt.Print(tr("Do you want to run a credentialed/full scan? Y/n: "))
+		input, err := readLine(ctx)
 		if err != nil {
-			fmt.Println("Error reading input:", err)
-			continue
+			return false, err
 		}
 
-		input = strings.ToLower(strings.TrimSpace(input))
-		if input == "y" || input == "yes" || input == "" {
-			return true
-		} else if input == "n" || input == "no" {
-			return false
-		} else {
-			fmt.Println("Invalid input. Please enter Y (yes) or N (no).")
+		if answer, ok := parseYesNo(input); ok {
+			return answer, nil
 		}
+		fmt.Println(tr("Invalid input. Please enter Y (yes) or N (no)."))
 	}
 }
 
-func getEmailAddress() string {
-	reader := bufio.NewReader(os.Stdin)
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
+func getEmailAddress(ctx context.Context) (string, error) {
 	for {
-		fmt.Print("Please enter your email address to receive the scan results: ")
-		input, err := reader.ReadString('\n')
+		fmt.Print(tr("Please enter your email address to receive the scan results: "))
+		input, err := readLine(ctx)
 		if err != nil {
-			fmt.Println("Error reading input:", err)
-			continue
+			return "", err
 		}
 
 		input = strings.TrimSpace(input)
 		if emailRegex.MatchString(input) {
-			return input
+			return input, nil
 		} else {
-			fmt.Println("Invalid email address. Please enter a valid email address.")
+			fmt.Println(tr("Invalid email address. Please enter a valid email address."))
 		}
 	}
 }
 
-func checkAPIStatus() bool {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
-	response, err := client.Get(baseAPI + "status")
-	if err != nil {
-		debugPrint("Error fetching API status:", err)
-		return false
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		debugPrint("Unexpected response status:", response.Status)
-		return false
-	}
-
+func checkAPIStatus(ctx context.Context) bool {
 	var apiResponse map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&apiResponse)
+	err := apiRequest(ctx, http.MethodGet, "status", nil, &apiResponse, nil, statusPolicy)
 	if err != nil {
-		debugPrint("Error decoding API response:", err)
+		debugPrint("Error fetching API status: %v\n", err)
 		return false
 	}
 
@@ -382,70 +604,60 @@ func ntlmPasswordToHash(password string) string {
 	return ntlmHash
 }
 
-func promptCredentials() (string, string) {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter your username with administrative privileges: ")
-	username, _ := reader.ReadString('\n')
+func promptCredentials(ctx context.Context) (string, string, error) {
+	fmt.Print(tr("Enter your username with administrative privileges: "))
+	username, err := readLine(ctx)
+	if err != nil {
+		return "", "", err
+	}
 	username = strings.TrimSpace(username)
 
-	fmt.Print("Enter the password for the account: ")
-	passwordBytes, _ := terminal.ReadPassword(int(os.Stdin.Fd()))
-	password := string(passwordBytes)
+	fmt.Print(tr("Enter the password for the account: "))
+	password, err := readPassword(ctx)
 	fmt.Println()
+	if err != nil {
+		return "", "", err
+	}
 
 	if runtime.GOOS == "windows" {
 		ntlmHash := ntlmPasswordToHash(password)
 		password = ntlmHash
 	}
 
-	return username, password
+	return username, password, nil
 }
 
-func statusLoop(scanID int) {
-	fmt.Printf("Scan started successfully with Scan ID: %d\n", scanID)
-	fmt.Println("Scanning...")
-	bar := progressbar.NewOptions(100,
-		progressbar.OptionSetWidth(40),
-		progressbar.OptionSetDescription("Scanning"),
-		progressbar.OptionFullWidth(),
-		progressbar.OptionSetPredictTime(false),
-		progressbar.OptionSetRenderBlankState(true),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionShowDescriptionAtLineEnd(),
-	)
-	time.Sleep(20 * time.Second)
-	count := 0
-	for {
-		critical, high, medium, low, info, scanProgressCurrent, percentage, status, err := getScanStatus(scanID)
-		if err != nil {
-			debugPrint("Error getting scan status: %v\nTrying again in 20s", err)
-			count++
-			if count > 20 {
-				fmt.Println("Error getting scan status: %v\nExiting", err)
-				os.Exit(1)
-			}
-			time.Sleep(20 * time.Second)
-			continue
-		}
-		count = 0
-
-		// Update the progress bar
-		_ = bar.Set(scanProgressCurrent)
-		bar.Describe(fmt.Sprintf("[reset]%s [red][Critical: %d][yellow][High: %d][light_yellow][Medium: %d][green][Low: %d][blue][Info: %d]", percentage, critical, high, medium, low, info))
-
-		// Print the counts for each severity level and the total count
-		// _ = critical + high + medium + low + info
+func statusLoop(ctx context.Context, b scanBackend, scanID int, policy string, ui scanUI) (scanProgress, error) {
+	fmt.Print(tr("Scan started successfully with Scan ID: %d\n", scanID))
+	fmt.Println(tr("Scanning..."))
 
-		fmt.Printf("\r%s", bar.String())
-
-		// Break the loop if the status is no longer "running"
-		if status != "running" {
-			break
+	// Earlier scans with the same policy give an estimate before the scan
+	// has made measurable progress. The history is optional.
+	var history []HistoryRecord
+	if !dryRun {
+		var err error
+		history, err = loadHistory()
+		if err != nil {
+			debugPrint("%v\n", err)
 		}
+	}
+	eta := newETAEstimator(time.Now(), policy, history)
 
-		// Sleep for a while before checking the status again
-		time.Sleep(20 * time.Second)
+	poll := func(ctx context.Context) (scanProgress, error) {
+		return b.Status(ctx, scanID)
 	}
+	resume := func(ctx context.Context) error {
+		return b.Resume(ctx, scanID)
+	}
+	show := func(p scanProgress) {
+		remaining, ok := eta.remaining(p, time.Now())
+		ui.Progress(scanID, p, formatETA(remaining, ok))
+	}
+	// Follow pushed status events while the server provides them.
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	updates := b.Updates(streamCtx, scanID)
+	return watchScan(ctx, updates, pollInterval, poll, resume, show)
 }
 
 // Declare tempDir as a global variable
@@ -462,22 +674,30 @@ type CurrentSettings struct {
 	LocalAccountTokenFilterPolicy string `json:"local_account_token_filter_policy"`
 }
 
-func uninstallTunnel() {
-	fmt.Println("Uninstalling Tunnel")
-	debugPrint(tempDir)
-	uninstallCommands()
+func uninstallTunnel() error {
+	if tempBinaryPath == "" {
+		// The binary was never written, so nothing was installed.
+		return nil
+	}
+	fmt.Println(tr("Uninstalling Tunnel"))
+	debugPrint("%s\n", tempDir)
+	err := uninstallCommands()
 	removeTempFile(tempBinaryPath)
 	removeTempDir(tempDir)
+	return err
 }
 
-func restore() {
+func restore() error {
 	// Load settings from the JSON file
 	debugPrint("Loading settings from JSON file...")
-	loadedSettings := loadSettingsFromFile(filename)
+	loadedSettings, err := loadSettingsFromFile(filename)
+	if err != nil {
+		return err
+	}
 
 	// Restore the original settings
 	debugPrint("Restoring original settings from JSON file...")
-	restoreOriginalSettings(loadedSettings)
+	return restoreOriginalSettings(loadedSettings)
 }
 
 func isSSHRunning() bool {
@@ -496,52 +716,32 @@ func isSSHRunning() bool {
 	return true
 }
 
-func installTunnel() {
-	fmt.Println("Installing Tunnel...")
-	tempBinaryPath = installNetbird()
-	tempDir = filepath.Dir(tempBinaryPath)
-
-	installCommands(tempBinaryPath)
-}
-
-func exportReport(scanID int, email string) {
-	var reqBody ExportRequest
-
-	reqBody.ScanID = scanID
-	reqBody.Email = email
-
-	jsonData, err := json.Marshal(reqBody)
+func installTunnel(ctx context.Context) error {
+	fmt.Println(tr("Installing Tunnel..."))
+	path, err := installNetbird()
 	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		os.Exit(1)
+		return err
 	}
+	tempBinaryPath = path
+	tempDir = filepath.Dir(tempBinaryPath)
 
-	resp, err := http.Post(baseAPI+"export_report", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Println("Error sending POST request:", err)
-		os.Exit(1)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error: received non-200 status code:", resp.StatusCode)
-		os.Exit(1)
-	}
+	return installCommands(ctx, tempBinaryPath)
+}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+func exportReport(ctx context.Context, scanID int, email string, options ExportOptions) error {
+	reqBody, err := options.exportRequest(scanID, email)
 	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		os.Exit(1)
+		return err
 	}
 
 	var jsonResponse map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &jsonResponse)
+	err = apiRequest(ctx, http.MethodPost, "export_report", reqBody, &jsonResponse, nil, mutatePolicy)
 	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		os.Exit(1)
+		return fmt.Errorf("error exporting report: %v", err)
 	}
 
 	fmt.Printf("JSON Response: %+v\n", jsonResponse)
+	return nil
 }
 
 func debugPrint(format string, a ...interface{}) {
@@ -550,43 +750,30 @@ func debugPrint(format string, a ...interface{}) {
 	}
 }
 
-func deleteScan(scanID int) error {
-	fmt.Println("Deleting scan...")
-	_, _, _, _, _, _, _, status, err := getScanStatus(scanID)
+// scanStopDelay gives the scanner time to stop a scan before it is deleted.
+var scanStopDelay = 5 * time.Second
+
+func deleteScan(ctx context.Context, scanID int) error {
+	fmt.Println(tr("Deleting scan..."))
+	progress, err := getScanStatus(ctx, scanID)
 	if err != nil {
 		return fmt.Errorf("Error getting scan status: %v", err)
 	}
 
-	if status == "running" {
-		stopScanURL := fmt.Sprintf("%sstop_scan/%d", baseAPI, scanID)
-		resp, err := http.Post(stopScanURL, "application/json", nil)
+	if progress.State.active() {
+		err = apiRequest(ctx, http.MethodPost, fmt.Sprintf("stop_scan/%d", scanID), nil, nil, nil, mutatePolicy)
 		if err != nil {
-			return fmt.Errorf("Error sending POST request to stop the scan: %v", err)
+			return fmt.Errorf("Error stopping the scan: %v", err)
 		}
-		resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("Error: received non-200 status code while stopping the scan: %d", resp.StatusCode)
+		if err := sleepCtx(ctx, scanStopDelay); err != nil {
+			return err
 		}
-
-		time.Sleep(5 * time.Second)
-	}
-
-	deleteScanURL := fmt.Sprintf("%sdelete_scan/%d", baseAPI, scanID)
-	req, err := http.NewRequest("DELETE", deleteScanURL, nil)
-	if err != nil {
-		return fmt.Errorf("Error creating DELETE request to delete the scan: %v", err)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	err = apiRequest(ctx, http.MethodDelete, fmt.Sprintf("delete_scan/%d", scanID), nil, nil, nil, mutatePolicy)
 	if err != nil {
-		return fmt.Errorf("Error sending DELETE request to delete the scan: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Error: received non-200 status code while deleting the scan: %d", resp.StatusCode)
+		return fmt.Errorf("Error deleting the scan: %v", err)
 	}
 
 	return nil
@@ -599,90 +786,161 @@ var scanID int
 func main() {
 	// Define the flag
 	flag.BoolVar(&debug, "debug", false, "Enable debug prints")
+	flag.StringVar(&configFile, "config", configFile, "Path to the config file")
+	langFlag := flag.String("lang", "", "Language of prompts and messages: en, es, fr or de (default: from the system locale)")
+	flag.BoolVar(&browserUI, "web", false, "Show the scanner in the default browser instead of the terminal")
+	plainFlag := flag.Bool("plain", false, "Use plain line-by-line prompts instead of the full-screen interface")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print every change and API request the scan would make without doing any of it")
+	flag.StringVar(&baseAPI, "api", baseAPI, "Base URL of the scanning API, for testing against a local backend")
+	backendFlag := flag.String("backend", "", "Scanner to run the scan on: smbdefence, or nessus or greenbone as set up in the config file (default smbdefence)")
+	proxyFlag := flag.String("proxy", "", "Proxy for the API and the tunnel: a URL such as http://proxy:8080 or socks5://proxy:1080, system or direct (default system)")
+	proxyPACFlag := flag.String("proxy-pac", "", "URL or path of a proxy auto-config (PAC) file")
+	proxyAuthFlag := flag.String("proxy-auth", "", "How to log in to the proxy: basic or ntlm (default basic)")
+	proxyUserFlag := flag.String("proxy-user", "", "Username for the proxy, or DOMAIN\\user for NTLM; the password is read from PROXY_PASSWORD")
+	policyFlag := flag.String("policy", "", "ID of the scan policy to use instead of asking")
+	lanFlag := flag.Bool("lan", false, "Also scan other devices on the local network through this machine")
+	failOnFlag := flag.String("fail-on", "", "Exit with code 4 if findings of this severity or higher remain: critical, high, medium or low")
+	formatsFlag := flag.String("formats", "", "Comma separated report formats: pdf, html, csv, nessus (default pdf)")
+	chaptersFlag := flag.String("chapters", "", "Comma separated report chapters: summary, hosts, plugins, remediations (default all)")
+	minSeverityFlag := flag.String("min-severity", "", "Leave findings below this severity out of the report: critical, high, medium, low or info")
+	recipientsFlag := flag.String("recipients", "", "Comma separated extra addresses to send the report to")
+	ccFlag := flag.String("cc", "", "Comma separated addresses to copy the report to")
 
 	// Parse the command-line flags
 	flag.Parse()
-	privilegesCheck()
+	// A dry run changes nothing, so there is nothing to audit.
+	if !dryRun {
+		auditTrail = &auditLog{}
+	}
 
-	installTunnel()
-	// Set up a signal handler to capture Ctrl+C (SIGINT)
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-signalChan
-		fmt.Println("\nReceived an interrupt, restoring settings and exiting...")
-		if runtime.GOOS == "windows" && credentialedScan {
-			restore()
-		}
-		if scanID > 0 {
-			deleteScan(scanID)
-		}
-		uninstallTunnel()
-		os.Exit(1)
-	}()
-	fmt.Println("Attempting to connect to API")
-	time.Sleep(5 * time.Second)
-	maxRetries := 4
-	retries := 0
-	for {
-		apiOnline := checkAPIStatus()
-		if apiOnline {
-			debugPrint("API is online.")
-			break
-		} else if retries < maxRetries {
-			debugPrint("API is offline. Retrying...")
-			retries++
-			time.Sleep(5 * time.Second)
-		} else {
-			fmt.Println("API is offline.")
-			uninstallTunnel()
-			os.Exit(1)
+	var err error
+	config, err = loadConfig(configFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailure)
+	}
+	if *langFlag != "" {
+		config.Language = *langFlag
+	}
+	language = detectLanguage()
+	if config.Language != "" {
+		code, ok := supportedLanguage(config.Language)
+		if !ok {
+			fmt.Printf("Unsupported language %q, expected en, es, fr or de\n", config.Language)
+			os.Exit(exitFailure)
 		}
+		language = code
+	}
+	// These commands only read the data directory, so they run before the
+	// proxy and the scanner are set up and do not fail when those are
+	// unreachable or misconfigured.
+	switch flag.Arg(0) {
+	case "history":
+		os.Exit(historyCommand(flag.Args()[1:]))
+	case "diff":
+		os.Exit(diffCommand(flag.Args()[1:]))
+	case "verify-audit":
+		os.Exit(verifyAuditCommand(flag.Args()[1:]))
+	}
+	if *policyFlag != "" {
+		config.Policy = *policyFlag
+	}
+	if config.BrowserUI {
+		browserUI = true
+	}
+	if *lanFlag {
+		config.ScanLAN = true
+	}
+	if *failOnFlag != "" {
+		config.FailOn = *failOnFlag
+	}
+	failOn, err := parseFailOn(config.FailOn)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailure)
+	}
+	if *formatsFlag != "" {
+		config.Export.Formats = splitList(*formatsFlag)
+	}
+	if *chaptersFlag != "" {
+		config.Export.Chapters = splitList(*chaptersFlag)
+	}
+	if *minSeverityFlag != "" {
+		config.Export.MinSeverity = *minSeverityFlag
+	}
+	if *recipientsFlag != "" {
+		config.Export.Recipients = splitList(*recipientsFlag)
+	}
+	if *ccFlag != "" {
+		config.Export.CC = splitList(*ccFlag)
+	}
+	// Check the export options now rather than after the scan has run.
+	if _, err := config.Export.exportRequest(0, ""); err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailure)
+	}
+	if *proxyFlag != "" {
+		config.Proxy.URL = *proxyFlag
+	}
+	if *proxyPACFlag != "" {
+		config.Proxy.PAC = *proxyPACFlag
+	}
+	if *proxyAuthFlag != "" {
+		config.Proxy.Auth = *proxyAuthFlag
+	}
+	if *proxyUserFlag != "" {
+		config.Proxy.Username = *proxyUserFlag
+	}
+	if err := configureProxy(context.Background(), config.Proxy); err != nil {
+		fmt.Println("Error configuring the proxy:", err)
+		os.Exit(exitFailure)
+	}
+	if *backendFlag != "" {
+		config.Backend.Type = *backendFlag
+	}
+	// The scanner's client goes through the proxy, so it is set up after it.
+	if err := configureBackend(config.Backend); err != nil {
+		fmt.Println("Error configuring the scanner backend:", err)
+		os.Exit(exitFailure)
 	}
 
-	email := getEmailAddress()
-	fmt.Printf("Scan results will be sent to: %s\n", email)
-	credentialedScan = askForCredentialedScan()
-	username, password := "", ""
-	if credentialedScan {
-		username, password = promptCredentials()
-		debugPrint(username, password)
-	}
-	if runtime.GOOS == "windows" && credentialedScan {
-		debugPrint("Storing current settings...")
-		settings = storeCurrentSettings()
-		saveSettingsToFile(settings, filename)
-	}
-
-	if credentialedScan {
-		fmt.Println("Running a credentialed/full scan...")
-		// Do stuff for a credentialed scan
-		if runtime.GOOS == "windows" {
-			// Enable settings for Nessus scan
-			setupWindowsNessus(settings)
-			scanID = startScan(email, username, password)
-			statusLoop(scanID)
-			fmt.Println("\nScan completed.")
-			// Restore original settings
-			restore()
-		} else {
-			if !isSSHRunning() {
-				os.Exit(1)
-			}
-			scanID = startScan(email, username, password)
-			statusLoop(scanID)
-			fmt.Println("\nScan completed.")
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "schedule":
+			os.Exit(scheduleCommand(flag.Args()[1:]))
+		case "agent":
+			os.Exit(agentCommand(flag.Args()[1:]))
+		default:
+			fmt.Printf("Unknown command %q\n", flag.Arg(0))
+			os.Exit(exitFailure)
 		}
+	}
+
+	if dryRun {
+		// Show the commands instead of running them; nothing needs privileges.
+		commandLog.next = dryRunner{}
+		fmt.Println("Dry run: nothing will be changed on this machine and no scan will be created.")
 	} else {
-		fmt.Println("Running a non-credentialed scan...")
-		// Do stuff for a non-credentialed scan
-		scanID = startScan(email, "", "")
-		statusLoop(scanID)
-		fmt.Println("\nScan completed.")
-	}
-	fmt.Println("Exporting full report...")
-	time.Sleep(20 * time.Second)
-	exportReport(scanID, email)
-	deleteScan(scanID)
-	uninstallTunnel()
+		privilegesCheck()
+	}
+
+	// Ctrl+C cancels the root context; the lifecycle then undoes every
+	// change made so far in reverse order.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
+
+	opts := scanOptions{Policy: config.Policy, ScanLAN: config.ScanLAN, FailOn: failOn, Export: config.Export}
+	// The full-screen interface needs a terminal; redirected input and
+	// output keep the plain prompts.
+	var closeUI func()
+	opts.UI, closeUI = chooseUI(cancel, *plainFlag, browserUI)
+	result := runScan(ctx, opts)
+	closeUI()
+	if dryRun {
+		fmt.Printf("Dry run finished: %d steps planned, nothing was changed.\n", planStep)
+	}
+	if result.ExitCode != exitOK {
+		os.Exit(result.ExitCode)
+	}
 }
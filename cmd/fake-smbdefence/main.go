@@ -0,0 +1,115 @@
+// Command fake-smbdefence serves the fake backend so the client can be run
+// against it locally:
+//
+//	go run ./cmd/fake-smbdefence -scenario slow
+//	sudo ./Nessus_Client -api http://127.0.0.1:8099/
+//
+// It can also stand in for a corporate proxy in front of the backend:
+//
+//	go run ./cmd/fake-smbdefence -proxy-addr 127.0.0.1:3128 -proxy-user alice:secret -proxy-ntlm
+//	sudo PROXY_PASSWORD=secret ./Nessus_Client -api http://127.0.0.1:8099/ -proxy http://127.0.0.1:3128 -proxy-auth ntlm -proxy-user alice
+//
+// Or for a Nessus scanner driven directly, with the API keys access and
+// secret and the backend set up in the config file:
+//
+//	go run ./cmd/fake-smbdefence -nessus-addr 127.0.0.1:8834
+//	sudo NESSUS_ACCESS_KEY=access NESSUS_SECRET_KEY=secret ./Nessus_Client -backend nessus
+//
+// Or for a Greenbone manager, with the user admin and the backend URL
+// unix:///tmp/gvmd.sock in the config file:
+//
+//	go run ./cmd/fake-smbdefence -gmp-socket /tmp/gvmd.sock
+//	sudo GMP_PASSWORD=secret ./Nessus_Client -backend greenbone
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/altfreq07/Nessus_Client/fakeapi"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8099", "Address to listen on")
+	name := flag.String("scenario", "complete", "Built-in scenario: "+strings.Join(fakeapi.ScenarioNames(), ", "))
+	file := flag.String("scenario-file", "", "JSON file with a custom scenario, instead of -scenario")
+	proxyAddr := flag.String("proxy-addr", "", "Also serve an HTTP proxy on this address")
+	socksAddr := flag.String("socks-addr", "", "Also serve a SOCKS5 proxy on this address")
+	proxyUser := flag.String("proxy-user", "", "Credentials the proxies require, as user:password")
+	proxyNTLM := flag.Bool("proxy-ntlm", false, "Ask for NTLM instead of Basic authentication on the HTTP proxy")
+	nessusAddr := flag.String("nessus-addr", "", "Also serve a fake Nessus scanner on this address, over plain HTTP")
+	nessusKeys := flag.String("nessus-keys", "access:secret", "API keys the fake Nessus requires, as access:secret")
+	gmpSocket := flag.String("gmp-socket", "", "Also serve a fake Greenbone manager on this Unix socket")
+	gmpUser := flag.String("gmp-user", "admin:secret", "Credentials the fake Greenbone manager requires, as user:password")
+	flag.Parse()
+
+	scenario, ok := fakeapi.Scenarios[*name]
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			log.Fatalf("Error reading scenario: %v", err)
+		}
+		scenario = fakeapi.Scenario{}
+		if err := json.Unmarshal(data, &scenario); err != nil {
+			log.Fatalf("Error parsing scenario %s: %v", *file, err)
+		}
+	} else if !ok {
+		fmt.Printf("Unknown scenario %q\n", *name)
+		os.Exit(1)
+	}
+
+	server := fakeapi.New(scenario)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		server.ServeHTTP(w, r)
+	})
+	proxy := &fakeapi.Proxy{NTLM: *proxyNTLM}
+	proxy.Username, proxy.Password, _ = strings.Cut(*proxyUser, ":")
+	if *proxyAddr != "" {
+		go func() {
+			log.Printf("Serving an HTTP proxy on %s", *proxyAddr)
+			log.Fatal(http.ListenAndServe(*proxyAddr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("proxy: %s %s", r.Method, r.URL)
+				proxy.ServeHTTP(w, r)
+			})))
+		}()
+	}
+	if *socksAddr != "" {
+		l, err := net.Listen("tcp", *socksAddr)
+		if err != nil {
+			log.Fatalf("Error listening for SOCKS5: %v", err)
+		}
+		log.Printf("Serving a SOCKS5 proxy on %s", *socksAddr)
+		go proxy.ServeSOCKS5(l)
+	}
+	if *nessusAddr != "" {
+		accessKey, secretKey, _ := strings.Cut(*nessusKeys, ":")
+		nessus := fakeapi.NewNessus(scenario, accessKey, secretKey)
+		go func() {
+			log.Printf("Serving a fake Nessus on http://%s/", *nessusAddr)
+			log.Fatal(http.ListenAndServe(*nessusAddr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("nessus: %s %s", r.Method, r.URL.Path)
+				nessus.ServeHTTP(w, r)
+			})))
+		}()
+	}
+	if *gmpSocket != "" {
+		username, password, _ := strings.Cut(*gmpUser, ":")
+		os.Remove(*gmpSocket)
+		l, err := net.Listen("unix", *gmpSocket)
+		if err != nil {
+			log.Fatalf("Error listening for GMP: %v", err)
+		}
+		log.Printf("Serving a fake Greenbone manager on unix://%s", *gmpSocket)
+		go fakeapi.NewGMP(scenario, username, password).ServeGMP(l)
+	}
+	log.Printf("Serving the %q scenario on http://%s/", scenario.Name, *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Config holds the options read from the config file. Every field is
+// optional; command-line flags take precedence over the file.
+type Config struct {
+	// Policy is the ID of the scan policy to use without asking.
+	Policy string `json:"policy,omitempty"`
+	// ScanLAN also scans chosen devices on the local network, routed through
+	// this machine's tunnel.
+	ScanLAN bool `json:"scan_lan,omitempty"`
+	// FailOn makes the run exit with exitFindings when findings of this
+	// severity or higher remain: critical, high, medium or low.
+	FailOn string `json:"fail_on,omitempty"`
+	// Notifications are sent on scan start, completion, failure and
+	// cleanup failure.
+	Notifications []NotificationTarget `json:"notifications,omitempty"`
+	// Language overrides the language detected from the system locale.
+	Language string `json:"language,omitempty"`
+	// BrowserUI shows the scanner in the default browser, for users who
+	// start it by double-clicking.
+	BrowserUI bool `json:"browser_ui,omitempty"`
+	// Export chooses the formats, chapters and recipients of the report.
+	Export ExportOptions `json:"export"`
+	// Proxy is used for the API and the tunnel instead of the system's
+	// proxy settings.
+	Proxy ProxyConfig `json:"proxy"`
+	// Backend is the scanner the scans run on, the smbdefence API by default.
+	Backend BackendConfig `json:"backend"`
+}
+
+var configFile string = "client_config.json"
+var config Config
+
+// loadConfig reads the config file. A missing file is not an error and
+// leaves every option at its default.
+func loadConfig(filename string) (Config, error) {
+	var cfg Config
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading config file: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing config file %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// dataDirOverride replaces the machine-wide directory when set, as the
+// end-to-end tests do.
+var dataDirOverride string
+
+// dataDir returns the machine-wide directory where the client keeps state
+// between runs, creating it if needed. When the client runs as root or
+// administrator the directory is limited to administrators, like the changes
+// the client makes; on Windows that replaces the permissions inherited from
+// ProgramData, which let every user create files.
+func dataDir() (string, error) {
+	var dir string
+	switch {
+	case dataDirOverride != "":
+		dir = dataDirOverride
+	case runtime.GOOS == "windows":
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		dir = filepath.Join(programData, "NessusRemoteScanner")
+	case runtime.GOOS == "darwin":
+		dir = "/Library/Application Support/NessusRemoteScanner"
+	default:
+		dir = "/var/lib/nessus-remote-scanner"
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating data directory: %v", err)
+	}
+	if dataDirOverride == "" && isPrivileged() {
+		if err := restrictDir(dir); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// isPrivileged reports whether the client runs as root or administrator.
+func isPrivileged() bool {
+	if runtime.GOOS == "windows" {
+		return isAdminWindows()
+	}
+	return isRoot()
+}
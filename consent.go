@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// plannedChange is one change to this machine that the user is asked to
+// accept before it is made.
+type plannedChange struct {
+	Description string   `json:"description"`
+	Commands    []string `json:"commands,omitempty"` // as they will be run, with secrets redacted
+}
+
+// ConsentRecord is the signed proof that the user accepted the changes. It
+// is stored locally and sent with the scan request.
+type ConsentRecord struct {
+	User            string          `json:"user"`
+	Email           string          `json:"email"`
+	Machine         string          `json:"machine"`
+	OperatingSystem string          `json:"operating_system"`
+	Time            time.Time       `json:"time"`
+	Policy          string          `json:"policy"`
+	Changes         []plannedChange `json:"changes"`
+	PublicKey       string          `json:"public_key"`
+	Signature       string          `json:"signature,omitempty"`
+}
+
+// describeCommands formats commands for the consent screen.
+func describeCommands(commands [][]string) []string {
+	var lines []string
+	for _, c := range commands {
+		lines = append(lines, redactSecrets(formatCommand(c[0], c[1:]...)))
+	}
+	return lines
+}
+
+// tunnelChanges are made by every scan, before the scan type is known.
+func tunnelChanges(goos string) []plannedChange {
+	return []plannedChange{
+		{Description: tr("Copy the NetBird tunnel client to a temporary directory")},
+		{
+			Description: tr("Install NetBird as a system service and connect this machine to the scanner's private network"),
+			Commands:    describeCommands(tunnelInstallCommands(goos, "netbird", tunnelProxyEnv)),
+		},
+	}
+}
+
+// windowsSetupDescriptions explain windowsSetupCommands, in the same order.
+var windowsSetupDescriptions = []string{
+	"Start the Windows Management Instrumentation service",
+	"Set the Remote Registry service to start automatically",
+	"Start the Remote Registry service",
+	"Enable the File and Printer Sharing firewall rules",
+	"Enable the Windows Management Instrumentation (WMI) firewall rules",
+	"Set LocalAccountTokenFilterPolicy to 1, which lets local administrator accounts log in over the network",
+}
+
+// scanChanges are made for the chosen scan type: logging in for a
+// credentialed scan and routing the chosen devices.
+func scanChanges(goos string, credentialed bool, username string, networks []string) []plannedChange {
+	var changes []plannedChange
+	if credentialed {
+		if goos == "windows" {
+			changes = append(changes, plannedChange{Description: tr("Let the scanner log in to this machine over the network as %s", username)})
+			for i, c := range windowsSetupCommands() {
+				changes = append(changes, plannedChange{Description: tr(windowsSetupDescriptions[i]), Commands: describeCommands([][]string{c})})
+			}
+		} else {
+			changes = append(changes, plannedChange{Description: tr("Let the scanner log in to this machine over SSH as %s", username)})
+		}
+	}
+	for _, network := range networks {
+		changes = append(changes, plannedChange{Description: tr("Route the scanner's traffic to %s through this machine", network)})
+	}
+	return changes
+}
+
+// askConsent asks the user to accept changes and fails if they do not.
+func askConsent(ctx context.Context, ui scanUI, changes []plannedChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	ok, err := ui.Consent(ctx, changes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("the changes to this machine were not accepted")
+	}
+	return nil
+}
+
+// consentUser returns who is running the client, looking past sudo.
+func consentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// loadSigningKey returns the key that signs the consent records of this
+// machine, generating and storing one on first use.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	if dryRun {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "signing_key")
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing key %s is damaged", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading signing key: %v", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating signing key: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("error writing signing key: %v", err)
+	}
+	return key, nil
+}
+
+// signedPayload is what the signature covers: the record without it.
+func (r ConsentRecord) signedPayload() ([]byte, error) {
+	r.Signature = ""
+	return json.Marshal(r)
+}
+
+// newConsentRecord signs a record of the accepted changes.
+func newConsentRecord(email, policy string, changes []plannedChange, key ed25519.PrivateKey) (*ConsentRecord, error) {
+	hostname, _ := os.Hostname()
+	r := &ConsentRecord{
+		User:            consentUser(),
+		Email:           email,
+		Machine:         hostname,
+		OperatingSystem: capitalizeFirstLetter(runtime.GOOS),
+		Time:            time.Now().UTC(),
+		Policy:          policy,
+		Changes:         changes,
+		PublicKey:       base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+	payload, err := r.signedPayload()
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling consent record: %v", err)
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return r, nil
+}
+
+// verify checks that a record was signed with pinned, the key registered
+// for its machine. The key in the record is only a hint: anyone can sign a
+// record with a key of their own.
+func (r ConsentRecord) verify(pinned ed25519.PublicKey) error {
+	key, err := base64.StdEncoding.DecodeString(r.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("consent record has an invalid public key")
+	}
+	if !pinned.Equal(ed25519.PublicKey(key)) {
+		return errors.New("consent record is signed with a key that is not registered")
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return errors.New("consent record has an invalid signature")
+	}
+	payload, err := r.signedPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return errors.New("consent record signature does not match")
+	}
+	return nil
+}
+
+// consentKeyRequest registers the public signing key of a machine.
+type consentKeyRequest struct {
+	Machine   string `json:"machine"`
+	PublicKey string `json:"public_key"`
+}
+
+// registerSigningKey sends the public key of the consent records to the
+// API the first time a record is sent with it. The API pins the first key
+// of each machine and rejects records signed with any other, so a record
+// cannot be changed and signed again with a new key. A marker file stops
+// the key from being sent again.
+func registerSigningKey(ctx context.Context, r *ConsentRecord) error {
+	body := consentKeyRequest{Machine: r.Machine, PublicKey: r.PublicKey}
+	if dryRun {
+		return apiRequest(ctx, http.MethodPost, "register_consent_key", body, nil, nil, mutatePolicy)
+	}
+	dir, err := dataDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "signing_key.registered")
+	if data, err := ioutil.ReadFile(path); err == nil && strings.TrimSpace(string(data)) == r.PublicKey {
+		return nil
+	}
+	if err := apiRequest(ctx, http.MethodPost, "register_consent_key", body, nil, nil, mutatePolicy); err != nil {
+		return fmt.Errorf("error registering signing key: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(r.PublicKey+"\n"), 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// saveConsentRecord keeps a copy of the record for the user's own records.
+func saveConsentRecord(r *ConsentRecord) error {
+	if dryRun {
+		planf("save the signed consent record of %d changes", len(r.Changes))
+		return nil
+	}
+	dir, err := dataDir()
+	if err != nil {
+		return err
+	}
+	dir = filepath.Join(dir, "consent")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating consent directory: %v", err)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling consent record: %v", err)
+	}
+	path := filepath.Join(dir, r.Time.Format("20060102T150405Z")+".json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing consent record: %v", err)
+	}
+	audit("consent", fmt.Sprintf("%s accepted %d changes, signature %s", r.User, len(r.Changes), r.Signature), nil)
+	debugPrint("Consent record saved to %s\n", path)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConsentRecordSignature(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := newConsentRecord("it@example.com", "basic", tunnelChanges("linux"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := key.Public().(ed25519.PublicKey)
+	if err := record.verify(pinned); err != nil {
+		t.Fatalf("verify() = %v", err)
+	}
+
+	tampered := *record
+	tampered.Changes = tampered.Changes[:1]
+	if err := tampered.verify(pinned); err == nil {
+		t.Error("verify() accepted a record with a change removed")
+	}
+	tampered = *record
+	tampered.Email = "someone@example.com"
+	if err := tampered.verify(pinned); err == nil {
+		t.Error("verify() accepted a record with another email address")
+	}
+
+	// A record changed and signed again with another key is rejected.
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resigned, err := newConsentRecord("someone@example.com", "basic", tunnelChanges("linux"), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resigned.verify(pinned); err == nil {
+		t.Error("verify() accepted a record signed with a key that is not registered")
+	}
+}
+
+func TestRegisterSigningKey(t *testing.T) {
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+	var registered []consentKeyRequest
+	useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req consentKeyRequest
+		if r.URL.Path != "/register_consent_key" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		registered = append(registered, req)
+		w.Write([]byte("{}"))
+	}))
+
+	key, err := loadSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := newConsentRecord("it@example.com", "basic", tunnelChanges("linux"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := registerSigningKey(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(registered) != 1 || registered[0].PublicKey != record.PublicKey || registered[0].Machine != record.Machine {
+		t.Errorf("registered %+v, want the record's key once", registered)
+	}
+}
+
+func TestSigningKeyIsKept(t *testing.T) {
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+
+	first, err := loadSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(second) {
+		t.Error("loadSigningKey() generated a new key instead of reusing the stored one")
+	}
+}
+
+func TestScanChanges(t *testing.T) {
+	if len(windowsSetupDescriptions) != len(windowsSetupCommands()) {
+		t.Fatalf("%d descriptions for %d Windows setup commands", len(windowsSetupDescriptions), len(windowsSetupCommands()))
+	}
+	changes := scanChanges("windows", true, "admin", []string{"192.168.1.0/24"})
+	if len(changes) != len(windowsSetupCommands())+2 {
+		t.Errorf("scanChanges() = %d changes", len(changes))
+	}
+	if changes := scanChanges("linux", false, "", nil); len(changes) != 0 {
+		t.Errorf("scanChanges() for a plain scan = %v, want none", changes)
+	}
+
+	for _, goos := range []string{"windows", "linux", "darwin"} {
+		for _, c := range tunnelChanges(goos) {
+			for _, command := range c.Commands {
+				if strings.Contains(command, netbirdSetupKey) {
+					t.Errorf("%s: consent screen shows the setup key: %s", goos, command)
+				}
+			}
+		}
+	}
+}
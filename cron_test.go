@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// bitsOf lists the values set in a cron field.
+func bitsOf(bits uint64) []int {
+	var values []int
+	for v := 0; v < 64; v++ {
+		if bits&(1<<uint(v)) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", 0, 59, []int{5}},
+		{"1-5", 0, 23, []int{1, 2, 3, 4, 5}},
+		{"1,15,30", 1, 31, []int{1, 15, 30}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"10-20/5", 0, 59, []int{10, 15, 20}},
+		{"5/20", 0, 59, []int{5, 25, 45}},
+		{"1-3,10-12/2", 1, 12, []int{1, 2, 3, 10, 12}},
+		{"0,7", 0, 7, []int{0, 7}},
+	}
+	for _, tt := range tests {
+		bits, err := parseCronField(tt.field, tt.min, tt.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q) = %v", tt.field, err)
+			continue
+		}
+		if got := bitsOf(bits); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+
+	for _, field := range []string{"60", "5-1", "*/0", "a", "1-", "-1", "1/x", "0"} {
+		min := 0
+		if field == "0" {
+			min = 1
+		}
+		if _, err := parseCronField(field, min, 59); err == nil {
+			t.Errorf("parseCronField(%q) accepted an invalid field", field)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"0 2 1 * *", "@monthly", " @weekly ", "*/30 8-18 * * 1-5"} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("parseCron(%q) = %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"", "0 2 1 *", "0 2 1 * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "@often"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) accepted an invalid expression", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"0 2 1 * *", "2024-01-15 10:00", "2024-02-01 02:00"},
+		{"@monthly", "2024-01-31 23:59", "2024-02-01 00:00"},
+		// The next run is strictly after the given time.
+		{"0 2 1 * *", "2024-02-01 02:00", "2024-03-01 02:00"},
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"0 0 29 2 *", "2023-03-01 00:00", "2024-02-29 00:00"},
+		// 2024-09-01 is a Sunday; 7 is Sunday too.
+		{"0 9 * * 5", "2024-09-01 00:00", "2024-09-06 09:00"},
+		{"0 9 * * 7", "2024-09-02 00:00", "2024-09-08 09:00"},
+		// A restricted day of month only.
+		{"0 9 13 * *", "2024-09-01 00:00", "2024-09-13 09:00"},
+		// Both days restricted: either one matches, as in cron.
+		{"0 9 13 * 5", "2024-09-01 00:00", "2024-09-06 09:00"},
+		{"0 9 13 * 5", "2024-09-12 10:00", "2024-09-13 09:00"},
+		// A day field starting with "*" is not a restriction: both must
+		// match. 2024-09-09 is the first odd Monday.
+		{"0 9 */2 * 1", "2024-09-01 00:00", "2024-09-09 09:00"},
+		{"30 8-18/4 * * 1-5", "2024-09-06 17:00", "2024-09-09 08:30"},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q) = %v", tt.expr, err)
+		}
+		if got := c.next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+
+	c, _ := parseCron("0 0 30 2 *")
+	if got := c.next(at("2024-01-01 00:00")); !got.IsZero() {
+		t.Errorf("30 February matched at %s", got)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// restrictDir limits dir to its owner, root when the client is privileged,
+// in case it was created with a wider mode.
+func restrictDir(dir string) error {
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("error setting permissions on %s: %v", dir, err)
+	}
+	return nil
+}
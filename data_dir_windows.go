@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// adminOnlySDDL gives SYSTEM and Administrators full control of the data
+// directory and everything in it, and nobody else any access. The DACL is
+// protected so the permissions ProgramData grants every user, such as
+// creating files, are not inherited.
+const adminOnlySDDL = "D:PAI(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)"
+
+// restrictDir limits dir to SYSTEM and Administrators.
+func restrictDir(dir string) error {
+	sd, err := windows.SecurityDescriptorFromString(adminOnlySDDL)
+	if err != nil {
+		return fmt.Errorf("error parsing security descriptor: %v", err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return fmt.Errorf("error reading security descriptor: %v", err)
+	}
+	err = windows.SetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+	if err != nil {
+		return fmt.Errorf("error setting permissions on %s: %v", dir, err)
+	}
+	return nil
+}
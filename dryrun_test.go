@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	body := `{"email":"it@example.com","username":"admin","password":"hunter2","nested":[{"api_token":"abc","name":"x"}],"secret":null}`
+	got := redactJSON([]byte(body))
+	for _, secret := range []string{"hunter2", "abc"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("redactJSON() = %s, leaks %q", got, secret)
+		}
+	}
+	for _, kept := range []string{"it@example.com", `"username":"admin"`, `"name":"x"`, `"secret":null`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("redactJSON() = %s, want %s kept", got, kept)
+		}
+	}
+	if got := redactSecrets("netbird up --setup-key " + netbirdSetupKey); strings.Contains(got, netbirdSetupKey) {
+		t.Errorf("redactSecrets() = %q", got)
+	}
+}
+
+func TestDryRunSendsNothing(t *testing.T) {
+	useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("dry run sent %s %s", r.Method, r.URL.Path)
+	}))
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	if !checkAPIStatus(context.Background()) {
+		t.Error("dry run API is not online")
+	}
+	id, err := startScan(context.Background(), "it@example.com", "basic", "admin", "hunter2", nil, nil)
+	if err != nil || id == 0 {
+		t.Errorf("startScan() = %d, %v", id, err)
+	}
+	progress, err := getScanStatus(context.Background(), id)
+	if err != nil || progress.State != stateCompleted {
+		t.Errorf("getScanStatus() = %+v, %v", progress, err)
+	}
+	if got := fetchPolicies(context.Background()); len(got) != len(defaultPolicies) {
+		t.Errorf("fetchPolicies() = %d policies, want the defaults", len(got))
+	}
+	if _, err := (dryRunner{}).Run(context.Background(), "net", "start", "Winmgmt"); err != nil {
+		t.Errorf("dryRunner.Run() = %v", err)
+	}
+}
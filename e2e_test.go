@@ -0,0 +1,382 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/altfreq07/Nessus_Client/fakeapi"
+)
+
+// fakeHost records what the faked tunnel and host preparation did.
+type fakeHost struct {
+	tunnelUp       bool
+	tunnelInstalls int
+	prepared       bool
+	restored       bool
+}
+
+// endToEnd runs the client's full flow against the fake backend with the
+// steps that change this machine faked and every delay shortened.
+func endToEnd(t *testing.T, scenario fakeapi.Scenario) (*fakeapi.Server, *fakeHost) {
+	t.Helper()
+	server := fakeapi.New(scenario)
+	useFakeAPI(t, server)
+	host := &fakeHost{}
+
+	oldSetUp, oldTearDown, oldPrepare := setUpTunnel, tearDownTunnel, prepareHost
+	oldSettle, oldStop, oldSlow, oldFast, oldPush, oldExport := apiSettleDelay, scanStopDelay, scanPollSlow, scanPollFast, scanPollWithPush, exportFallbackDelay
+	oldPolicies := []retryPolicy{statusPolicy, pollPolicy, mutatePolicy}
+	oldConfig, oldDataDir, oldStdin, oldAudit := config, dataDirOverride, stdin, auditTrail
+	t.Cleanup(func() {
+		setUpTunnel, tearDownTunnel, prepareHost = oldSetUp, oldTearDown, oldPrepare
+		apiSettleDelay, scanStopDelay, scanPollSlow, scanPollFast, scanPollWithPush, exportFallbackDelay = oldSettle, oldStop, oldSlow, oldFast, oldPush, oldExport
+		statusPolicy, pollPolicy, mutatePolicy = oldPolicies[0], oldPolicies[1], oldPolicies[2]
+		config, dataDirOverride, stdin, auditTrail = oldConfig, oldDataDir, oldStdin, oldAudit
+	})
+
+	setUpTunnel = func(ctx context.Context) error {
+		host.tunnelUp = true
+		host.tunnelInstalls++
+		return nil
+	}
+	tearDownTunnel = func() error {
+		host.tunnelUp = false
+		return nil
+	}
+	prepareHost = func(lc *lifecycle) (*undoAction, error) {
+		host.prepared = true
+		return lc.onUndo("restore settings", func(ctx context.Context) error {
+			host.restored = true
+			return nil
+		}), nil
+	}
+
+	apiSettleDelay, scanStopDelay = 0, 0
+	scanPollSlow, scanPollFast, scanPollWithPush = 2*time.Millisecond, time.Millisecond, 10*time.Millisecond
+	exportFallbackDelay = time.Millisecond
+	for _, p := range []*retryPolicy{&statusPolicy, &pollPolicy, &mutatePolicy} {
+		p.BaseDelay, p.MaxDelay = time.Millisecond, 5*time.Millisecond
+	}
+	config = Config{}
+	dataDirOverride = t.TempDir()
+	auditTrail = &auditLog{}
+	return server, host
+}
+
+func onlyScan(t *testing.T, server *fakeapi.Server) fakeapi.Scan {
+	t.Helper()
+	scans := server.Scans()
+	if len(scans) != 1 {
+		t.Fatalf("server has %d scans, want 1", len(scans))
+	}
+	for _, scan := range scans {
+		return scan
+	}
+	return fakeapi.Scan{}
+}
+
+func unattended() scanOptions {
+	return scanOptions{Unattended: true, Email: "it@example.com", Policy: "basic"}
+}
+
+func TestEndToEndComplete(t *testing.T) {
+	server, host := endToEnd(t, fakeapi.Scenarios["complete"])
+
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitOK || result.Err != nil {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	scan := onlyScan(t, server)
+	if result.ScanID != scan.ID || result.Final.High != 2 || result.Final.Medium != 5 {
+		t.Errorf("result = %+v", result)
+	}
+	if scan.Request["email"] != "it@example.com" || scan.Request["policy"] != "basic" {
+		t.Errorf("scan created with %v", scan.Request)
+	}
+	if len(scan.Exports) != 1 || !scan.Deleted || scan.Stopped {
+		t.Errorf("exports = %d, deleted = %v, stopped = %v", len(scan.Exports), scan.Deleted, scan.Stopped)
+	}
+	if host.tunnelUp || host.tunnelInstalls != 1 || host.prepared {
+		t.Errorf("host = %+v", host)
+	}
+
+	records, err := loadHistory()
+	if err != nil || len(records) != 1 || records[0].ScanID != scan.ID {
+		t.Fatalf("history = %+v, %v", records, err)
+	}
+	if r := records[0]; r.ScanDuration <= 0 || r.ScanDuration > r.Duration {
+		t.Errorf("history record took %gs of a %gs run, want the scan's own duration", r.ScanDuration, r.Duration)
+	}
+
+	// Every API call and undo action is in an intact audit log.
+	path, _ := auditPath()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	keyPath, _ := auditKeyPath()
+	key, err := loadAuditKey(keyPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, n, err := verifyAudit(f, key)
+	if err != nil {
+		t.Fatalf("verifyAudit() = %v", err)
+	}
+	calls := 0
+	for _, r := range server.Requests() {
+		// The status event stream is read directly, not through apiRequest.
+		if !strings.Contains(r, "scan_events") {
+			calls++
+		}
+	}
+	// Deleting the scan and uninstalling the tunnel are recorded as undo
+	// actions; there are no LAN targets, so no routes to withdraw.
+	if last.Kind != "undo" || last.Action != "uninstall tunnel" || n != calls+2 {
+		t.Errorf("audit log has %d entries ending with %+v, want %d API calls and 2 undo actions", n, last, calls)
+	}
+}
+
+func TestEndToEndThroughProxy(t *testing.T) {
+	server, _ := endToEnd(t, fakeapi.Scenarios["complete"])
+	proxy := &fakeapi.Proxy{Username: "alice", Password: "secret"}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	oldClient, oldEnv, oldPassword := apiClient, tunnelProxyEnv, proxyPassword
+	t.Cleanup(func() { apiClient, tunnelProxyEnv, proxyPassword = oldClient, oldEnv, oldPassword })
+
+	if err := configureProxy(context.Background(), ProxyConfig{URL: "http://alice:secret@" + strings.TrimPrefix(proxyServer.URL, "http://")}); err != nil {
+		t.Fatal(err)
+	}
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitOK || result.Err != nil {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	// Every request reached the backend through the proxy.
+	if got, want := len(proxy.Requests()), len(server.Requests()); got != want || got == 0 {
+		t.Errorf("proxy passed on %d requests, backend got %d", got, want)
+	}
+	if len(tunnelProxyEnv) != 2 || !strings.HasPrefix(tunnelProxyEnv[0], "HTTPS_PROXY=http://alice:secret@") {
+		t.Errorf("tunnel environment = %q", tunnelProxyEnv)
+	}
+}
+
+func TestEndToEndSlow(t *testing.T) {
+	server, _ := endToEnd(t, fakeapi.Scenarios["slow"])
+
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitOK {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	// One poll per status, and one more before the scan is deleted.
+	if scan := onlyScan(t, server); scan.Polls != len(fakeapi.Scenarios["slow"].Statuses)+1 {
+		t.Errorf("polls = %d, want one per status and one to delete", scan.Polls)
+	}
+}
+
+func TestEndToEndUnreliableAPI(t *testing.T) {
+	for _, name := range []string{"flapping", "server-errors"} {
+		t.Run(name, func(t *testing.T) {
+			server, host := endToEnd(t, fakeapi.Scenarios[name])
+
+			result := runScan(context.Background(), unattended())
+			if result.ExitCode != exitOK {
+				t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+			}
+			// Retried create_scan calls reuse the idempotency key, so only
+			// one scan exists.
+			scan := onlyScan(t, server)
+			if len(scan.Exports) != 1 || !scan.Deleted || host.tunnelUp {
+				t.Errorf("scan = %+v, host = %+v", scan, host)
+			}
+		})
+	}
+}
+
+func TestEndToEndAborted(t *testing.T) {
+	server, host := endToEnd(t, fakeapi.Scenarios["aborted"])
+
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitScanAborted {
+		t.Fatalf("runScan() exit %d, want %d: %v", result.ExitCode, exitScanAborted, result.Err)
+	}
+	scan := onlyScan(t, server)
+	if len(scan.Exports) != 0 || !scan.Deleted || host.tunnelUp {
+		t.Errorf("scan = %+v, host = %+v", scan, host)
+	}
+}
+
+func TestEndToEndPaused(t *testing.T) {
+	server, _ := endToEnd(t, fakeapi.Scenarios["paused"])
+
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitOK {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	if scan := onlyScan(t, server); scan.Resumed != 1 {
+		t.Errorf("resumed %d times, want 1", scan.Resumed)
+	}
+}
+
+func TestEndToEndOffline(t *testing.T) {
+	server, host := endToEnd(t, fakeapi.Scenarios["offline"])
+
+	result := runScan(context.Background(), unattended())
+	if result.ExitCode != exitAPIOffline {
+		t.Fatalf("runScan() exit %d, want %d: %v", result.ExitCode, exitAPIOffline, result.Err)
+	}
+	if len(server.Scans()) != 0 || host.tunnelUp {
+		t.Errorf("scans = %d, host = %+v", len(server.Scans()), host)
+	}
+}
+
+func TestEndToEndCredentialed(t *testing.T) {
+	server, host := endToEnd(t, fakeapi.Scenarios["complete"])
+	// Accept the tunnel, then the login once the scan type is known.
+	stdin = bufio.NewReader(strings.NewReader("y\nit@example.com\ny\nadmin\nsecret\ny\n"))
+
+	result := runScan(context.Background(), scanOptions{Policy: "basic"})
+	if result.ExitCode != exitOK || !result.Credentialed {
+		t.Fatalf("runScan() exit %d, credentialed %v: %v", result.ExitCode, result.Credentialed, result.Err)
+	}
+	if !host.prepared || !host.restored {
+		t.Errorf("host = %+v, want prepared and restored", host)
+	}
+	scan := onlyScan(t, server)
+	if scan.Request["username"] != "admin" || scan.Request["email"] != "it@example.com" {
+		t.Errorf("scan created with %v", scan.Request)
+	}
+
+	// The signed consent record is sent and kept locally, and its key is
+	// registered first.
+	data, _ := json.Marshal(scan.Request["consent"])
+	var consent ConsentRecord
+	if err := json.Unmarshal(data, &consent); err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := base64.StdEncoding.DecodeString(server.ConsentKey(consent.Machine))
+	if err := consent.verify(pinned); err != nil {
+		t.Errorf("consent record sent: %v", err)
+	}
+	if len(consent.Changes) != 3 || consent.Email != "it@example.com" {
+		t.Errorf("consent record = %+v, want the tunnel and login changes", consent)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(dataDirOverride, "consent", "*.json")); len(saved) != 1 {
+		t.Errorf("consent records saved: %v", saved)
+	}
+}
+
+func TestEndToEndConsentDeclined(t *testing.T) {
+	server, host := endToEnd(t, fakeapi.Scenarios["complete"])
+	stdin = bufio.NewReader(strings.NewReader("\nn\n"))
+
+	result := runScan(context.Background(), scanOptions{Policy: "basic"})
+	if result.ExitCode != exitFailure || result.Err == nil {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	if host.tunnelInstalls != 0 || len(server.Requests()) != 0 {
+		t.Errorf("declined run installed the tunnel %d times and sent %v", host.tunnelInstalls, server.Requests())
+	}
+}
+
+func TestEndToEndInterrupted(t *testing.T) {
+	scenario := fakeapi.Scenarios["complete"]
+	scenario.Statuses = []fakeapi.Status{{State: "running", Progress: 10}}
+	server, host := endToEnd(t, scenario)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for len(server.Scans()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	result := runScan(ctx, unattended())
+	if result.ExitCode != exitFailure {
+		t.Errorf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	scan := onlyScan(t, server)
+	if !scan.Stopped || !scan.Deleted || host.tunnelUp {
+		t.Errorf("scan = %+v, host = %+v, want stopped, deleted and tunnel down", scan, host)
+	}
+	exports, _ := json.Marshal(scan.Exports)
+	if len(scan.Exports) != 0 {
+		t.Errorf("exported %s after an interrupt", exports)
+	}
+}
+
+func TestEndToEndNessus(t *testing.T) {
+	_, host := endToEnd(t, fakeapi.Scenarios["complete"])
+	fake, b := useFakeNessus(t, fakeapi.Scenarios["paused"])
+	old := backend
+	backend = b
+	t.Cleanup(func() { backend = old })
+
+	opts := unattended()
+	opts.Export.Formats = []string{"html"}
+	result := runScan(context.Background(), opts)
+	if result.ExitCode != exitOK || result.Err != nil {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	scans := fake.Scans()
+	scan, ok := scans[result.ScanID]
+	if len(scans) != 1 || !ok || result.Final.High != 2 {
+		t.Fatalf("scans = %+v, result = %+v", scans, result)
+	}
+	if scan.Resumed != 1 || len(scan.Exports) != 2 || !scan.Deleted || host.tunnelUp {
+		t.Errorf("scan = %+v, host = %+v", scan, host)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(b.reportDir, fmt.Sprintf("scan-%d.html", scan.ID))); err != nil {
+		t.Error(err)
+	}
+	// The findings of this machine are kept under its hostname for the next
+	// comparison.
+	if history, err := loadHostFindings(machineName()); err != nil || len(history) != 1 {
+		t.Errorf("findings history = %+v, %v", history, err)
+	}
+}
+
+func TestEndToEndGreenbone(t *testing.T) {
+	_, host := endToEnd(t, fakeapi.Scenarios["complete"])
+	fake, b := useFakeGMP(t, fakeapi.Scenarios["complete"], false)
+	old := backend
+	backend = b
+	t.Cleanup(func() { backend = old })
+
+	opts := unattended()
+	opts.Policy = gmpFullAndFast
+	opts.Export.Formats = []string{"pdf"}
+	result := runScan(context.Background(), opts)
+	if result.ExitCode != exitOK || result.Err != nil {
+		t.Fatalf("runScan() exit %d: %v", result.ExitCode, result.Err)
+	}
+	tasks := fake.Tasks()
+	if len(tasks) != 1 || result.Final.High != 2 {
+		t.Fatalf("tasks = %+v, result = %+v", tasks, result)
+	}
+	for _, task := range tasks {
+		if len(task.Reports) != 1 || !task.Deleted || !task.TargetDeleted || host.tunnelUp {
+			t.Errorf("task = %+v, host = %+v", task, host)
+		}
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(b.reportDir, fmt.Sprintf("scan-%d.pdf", result.ScanID))); err != nil {
+		t.Error(err)
+	}
+	if history, err := loadHostFindings(machineName()); err != nil || len(history) != 1 {
+		t.Errorf("findings history = %+v, %v", history, err)
+	}
+}
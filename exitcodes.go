@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes returned by the client, so that RMM tools and CI scripts can
+// branch on the outcome. They are also listed in README.md.
+const (
+	exitOK           = 0 // scan completed and everything was restored
+	exitFailure      = 1 // any other error
+	exitScanCanceled = 2 // the scan was canceled on the server
+	exitScanAborted  = 3 // the scan was aborted by the scanner
+	exitFindings     = 4 // findings at or above the -fail-on severity
+	exitAPIOffline   = 5 // the API could not be reached through the tunnel
+	exitTunnel       = 6 // the tunnel could not be installed
+	exitPrivileges   = 7 // not running as root or administrator
+	exitRestore      = 8 // a change could not be undone; check the machine
+)
+
+// codedError attaches an exit code to an error.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// exitCode maps the result of a run, and the number of undo actions that
+// failed while unwinding it, to the process exit code. A failed undo takes
+// precedence because it means the machine was left modified.
+func exitCode(err error, failedUndos int) int {
+	var ended *scanEndedError
+	var coded *codedError
+	switch {
+	case failedUndos > 0:
+		return exitRestore
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.As(err, &ended) && ended.State == stateCanceled:
+		return exitScanCanceled
+	case errors.As(err, &ended) && ended.State == stateAborted:
+		return exitScanAborted
+	}
+	return exitFailure
+}
+
+// severityThresholds maps the -fail-on values to the lowest severity that
+// fails the run.
+var severityThresholds = map[string]int{"critical": 4, "high": 3, "medium": 2, "low": 1}
+
+func parseFailOn(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	threshold, ok := severityThresholds[strings.ToLower(value)]
+	if !ok {
+		return 0, fmt.Errorf("invalid -fail-on %q, expected critical, high, medium or low", value)
+	}
+	return threshold, nil
+}
+
+// exceedsThreshold reports whether the final counts include findings at or
+// above the threshold severity. A zero threshold never fails.
+func exceedsThreshold(p scanProgress, threshold int) bool {
+	counts := []int{p.Info, p.Low, p.Medium, p.High, p.Critical}
+	if threshold <= 0 {
+		return false
+	}
+	for severity := threshold; severity < len(counts); severity++ {
+		if counts[severity] > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExportOptions choose what the emailed report contains and who gets it.
+// Empty fields fall back to a PDF with every chapter, sent to the address
+// given for the scan only.
+type ExportOptions struct {
+	Formats     []string `json:"formats,omitempty"`      // pdf, html, csv, nessus
+	Chapters    []string `json:"chapters,omitempty"`     // see reportChapters
+	MinSeverity string   `json:"min_severity,omitempty"` // critical, high, medium, low or info
+	Recipients  []string `json:"recipients,omitempty"`   // in addition to the scan's address
+	CC          []string `json:"cc,omitempty"`
+}
+
+var reportFormats = []string{"pdf", "html", "csv", "nessus"}
+
+// reportChapters maps the chapter names accepted by the client to the
+// Nessus report chapters.
+var reportChapters = map[string]string{
+	"summary":      "vuln_hosts_summary",
+	"hosts":        "vuln_by_host",
+	"plugins":      "vuln_by_plugin",
+	"remediations": "remediations",
+}
+
+// maxRecipients matches the limit of the backend's mailer.
+const maxRecipients = 20
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// exportRequest validates the options and builds the export_report request.
+// Formats and chapters are matched case-insensitively and deduplicated.
+func (o ExportOptions) exportRequest(scanID int, email string) (ExportRequest, error) {
+	req := ExportRequest{ScanID: scanID, Email: email}
+
+	for _, format := range o.Formats {
+		format = strings.ToLower(strings.TrimPrefix(format, "."))
+		if !contains(reportFormats, format) {
+			return req, fmt.Errorf("invalid report format %q, expected %s", format, strings.Join(reportFormats, ", "))
+		}
+		if !contains(req.Formats, format) {
+			req.Formats = append(req.Formats, format)
+		}
+	}
+	if len(req.Formats) == 0 {
+		req.Formats = []string{"pdf"}
+	}
+
+	for _, chapter := range o.Chapters {
+		name, ok := reportChapters[strings.ToLower(chapter)]
+		if !ok {
+			return req, fmt.Errorf("invalid report chapter %q, expected summary, hosts, plugins or remediations", chapter)
+		}
+		if !contains(req.Chapters, name) {
+			req.Chapters = append(req.Chapters, name)
+		}
+	}
+	if len(req.Chapters) > 0 && !contains(req.Formats, "pdf") && !contains(req.Formats, "html") {
+		return req, fmt.Errorf("report chapters only apply to the pdf and html formats")
+	}
+
+	if o.MinSeverity != "" && !strings.EqualFold(o.MinSeverity, "info") {
+		threshold, ok := severityThresholds[strings.ToLower(o.MinSeverity)]
+		if !ok {
+			return req, fmt.Errorf("invalid minimum severity %q, expected critical, high, medium, low or info", o.MinSeverity)
+		}
+		req.MinSeverity = threshold
+	}
+
+	// Every address must be valid and appear only once across To and CC.
+	seen := map[string]bool{}
+	if email != "" {
+		seen[strings.ToLower(email)] = true
+	}
+	check := func(list []string) ([]string, error) {
+		var out []string
+		for _, address := range list {
+			address = strings.TrimSpace(address)
+			if !emailRegex.MatchString(address) {
+				return nil, fmt.Errorf("invalid email address %q", address)
+			}
+			if seen[strings.ToLower(address)] {
+				continue
+			}
+			seen[strings.ToLower(address)] = true
+			out = append(out, address)
+		}
+		return out, nil
+	}
+	var err error
+	if req.Recipients, err = check(o.Recipients); err != nil {
+		return req, err
+	}
+	if req.CC, err = check(o.CC); err != nil {
+		return req, err
+	}
+	if len(seen) > maxRecipients {
+		return req, fmt.Errorf("too many recipients: %d, at most %d", len(seen), maxRecipients)
+	}
+	return req, nil
+}
+
+// ExportStatusResponse tells whether the server has finished processing a
+// completed scan and its report can be exported.
+type ExportStatusResponse struct {
+	Ready bool `json:"ready"`
+}
+
+var (
+	exportReadyTimeout = 10 * time.Minute
+	// exportFallbackDelay is waited instead when the server cannot report
+	// whether the export is ready.
+	exportFallbackDelay = 20 * time.Second
+)
+
+// waitExportReady polls export_status until the report can be exported.
+func waitExportReady(ctx context.Context, scanID int) error {
+	deadline := time.Now().Add(exportReadyTimeout)
+	for {
+		var status ExportStatusResponse
+		err := apiRequest(ctx, http.MethodGet, fmt.Sprintf("export_status/%d", scanID), nil, &status, nil, pollPolicy)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			debugPrint("Server does not report export status, waiting %s\n", exportFallbackDelay)
+			return sleepCtx(ctx, exportFallbackDelay)
+		}
+		if err != nil {
+			return fmt.Errorf("error getting export status: %v", err)
+		}
+		if status.Ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("report was not ready for export after %s", exportReadyTimeout)
+		}
+		if err := sleepCtx(ctx, scanPollFast); err != nil {
+			return err
+		}
+	}
+}
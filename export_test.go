@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ExportOptions
+		want    ExportRequest
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: ExportRequest{ScanID: 3, Email: "it@example.com", Formats: []string{"pdf"}},
+		},
+		{
+			name: "everything",
+			options: ExportOptions{
+				Formats:     []string{"PDF", ".nessus", "pdf"},
+				Chapters:    []string{"summary", "Remediations"},
+				MinSeverity: "medium",
+				Recipients:  []string{"boss@example.com", "IT@example.com"},
+				CC:          []string{"audit@example.com", "boss@example.com"},
+			},
+			want: ExportRequest{
+				ScanID: 3, Email: "it@example.com",
+				Formats:     []string{"pdf", "nessus"},
+				Chapters:    []string{"vuln_hosts_summary", "remediations"},
+				MinSeverity: 2,
+				Recipients:  []string{"boss@example.com"},
+				CC:          []string{"audit@example.com"},
+			},
+		},
+		{name: "info is no filter", options: ExportOptions{MinSeverity: "info"}, want: ExportRequest{ScanID: 3, Email: "it@example.com", Formats: []string{"pdf"}}},
+		{name: "unknown format", options: ExportOptions{Formats: []string{"docx"}}, wantErr: true},
+		{name: "unknown chapter", options: ExportOptions{Chapters: []string{"appendix"}}, wantErr: true},
+		{name: "chapters without pdf or html", options: ExportOptions{Formats: []string{"csv"}, Chapters: []string{"hosts"}}, wantErr: true},
+		{name: "bad severity", options: ExportOptions{MinSeverity: "severe"}, wantErr: true},
+		{name: "bad cc", options: ExportOptions{CC: []string{"not-an-address"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.options.exportRequest(3, "it@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("exportRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exportRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	many := ExportOptions{}
+	for i := 0; i < maxRecipients; i++ {
+		many.CC = append(many.CC, string(rune('a'+i))+"@example.com")
+	}
+	if _, err := many.exportRequest(3, "it@example.com"); err == nil {
+		t.Error("exportRequest() accepted more than maxRecipients addresses")
+	}
+}
+
+func TestWaitExportReady(t *testing.T) {
+	oldFast, oldFallback := scanPollFast, exportFallbackDelay
+	scanPollFast, exportFallbackDelay = time.Millisecond, time.Millisecond
+	defer func() { scanPollFast, exportFallbackDelay = oldFast, oldFallback }()
+
+	polls := 0
+	useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/export_status/4" {
+			http.NotFound(w, r)
+			return
+		}
+		polls++
+		fmt.Fprintf(w, `{"ready": %t}`, polls == 3)
+	}))
+
+	if err := waitExportReady(context.Background(), 4); err != nil || polls != 3 {
+		t.Errorf("waitExportReady() = %v after %d polls, want ready after 3", err, polls)
+	}
+	// A server without export_status falls back to a fixed wait.
+	if err := waitExportReady(context.Background(), 5); err != nil {
+		t.Errorf("waitExportReady() without export_status = %v", err)
+	}
+}
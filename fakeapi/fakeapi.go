@@ -0,0 +1,355 @@
+// Package fakeapi is a stand-in for the smbdefence backend. It implements the
+// endpoints the client needs for a full scan with scriptable scenarios, so
+// the client can be run and tested end to end without a Nessus server.
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is one answer of scan_status.
+type Status struct {
+	State    string `json:"state"`
+	Progress int    `json:"progress"`
+	Critical int    `json:"critical"`
+	High     int    `json:"high"`
+	Medium   int    `json:"medium"`
+	Low      int    `json:"low"`
+	Info     int    `json:"info"`
+}
+
+// Scenario scripts how the fake behaves.
+type Scenario struct {
+	Name string `json:"name"`
+	// Statuses are returned by successive scan_status calls for a scan; the
+	// last one repeats.
+	Statuses []Status `json:"statuses"`
+	// Offline makes status report the API as offline.
+	Offline bool `json:"offline,omitempty"`
+	// LatencyMS is added to every response, in milliseconds.
+	LatencyMS int `json:"latency_ms,omitempty"`
+	// FlapEvery answers every nth request with 503, counting all endpoints.
+	FlapEvery int `json:"flap_every,omitempty"`
+	// Failures answers the first n calls of an endpoint, named by its first
+	// path segment such as "create_scan", with 500.
+	Failures map[string]int `json:"failures,omitempty"`
+}
+
+func running(progress, high, medium int) Status {
+	return Status{State: "running", Progress: progress, High: high, Medium: medium, Info: progress / 10}
+}
+
+var completed = Status{State: "completed", Progress: 100, High: 2, Medium: 5, Low: 1, Info: 14}
+
+// Scenarios are the built-in scenarios by name.
+var Scenarios = map[string]Scenario{
+	"complete": {
+		Name:     "complete",
+		Statuses: []Status{{State: "pending"}, running(30, 1, 2), running(80, 2, 4), {State: "processing", Progress: 100, High: 2, Medium: 5, Low: 1, Info: 14}, completed},
+	},
+	"slow": {
+		Name: "slow",
+		Statuses: []Status{{State: "pending"}, {State: "pending"}, running(2, 0, 0), running(5, 0, 0), running(10, 0, 1),
+			running(20, 1, 1), running(40, 1, 2), running(60, 1, 3), running(80, 2, 4), running(95, 2, 5), completed},
+		LatencyMS: 50,
+	},
+	"flapping": {
+		Name:      "flapping",
+		Statuses:  []Status{{State: "pending"}, running(50, 1, 2), completed},
+		FlapEvery: 3,
+	},
+	"server-errors": {
+		Name:     "server-errors",
+		Statuses: []Status{{State: "pending"}, running(50, 1, 2), completed},
+		Failures: map[string]int{"status": 1, "create_scan": 2, "scan_status": 2, "export_report": 1},
+	},
+	"aborted": {
+		Name:     "aborted",
+		Statuses: []Status{{State: "pending"}, running(40, 1, 1), {State: "aborted", Progress: 40, High: 1, Medium: 1}},
+	},
+	"paused": {
+		Name:     "paused",
+		Statuses: []Status{{State: "pending"}, running(30, 0, 1), {State: "paused", Progress: 30, Medium: 1}, running(70, 1, 3), completed},
+	},
+	"offline": {
+		Name:    "offline",
+		Offline: true,
+	},
+}
+
+// ScenarioNames lists the built-in scenarios.
+func ScenarioNames() []string {
+	var names []string
+	for name := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scan is a scan created on the fake.
+type Scan struct {
+	ID      int                    `json:"id"`
+	Request map[string]interface{} `json:"request"`
+	Polls   int                    `json:"polls"`
+	Resumed int                    `json:"resumed"`
+	Stopped bool                   `json:"stopped"`
+	Deleted bool                   `json:"deleted"`
+	// Launched is set when a scan created on the fake Nessus is launched.
+	Launched bool              `json:"launched,omitempty"`
+	Exports  []json.RawMessage `json:"exports"`
+
+	step    int // index into the scenario's statuses
+	resumes int // resumes already acted on
+}
+
+// Policies are the scan policies the fake backend offers.
+var Policies = []map[string]interface{}{
+	{"id": "basic", "name": "Basic Network Scan", "description": "Full system scan suitable for any host", "credentials_supported": true},
+	{"id": "compliance", "name": "Policy Compliance Auditing", "description": "Audit the host against CIS benchmarks", "credentials_required": true, "credentials_supported": true},
+}
+
+// Server is the fake backend. It is safe for concurrent use.
+type Server struct {
+	mu          sync.Mutex
+	scenario    Scenario
+	nextID      int
+	scans       map[int]*Scan
+	idempotency map[string]int
+	requests    []string
+	calls       map[string]int
+	total       int
+	consentKeys map[string]string
+}
+
+// New returns a server playing the scenario.
+func New(scenario Scenario) *Server {
+	return &Server{
+		scenario:    scenario,
+		nextID:      100,
+		scans:       map[int]*Scan{},
+		idempotency: map[string]int{},
+		calls:       map[string]int{},
+		consentKeys: map[string]string{},
+	}
+}
+
+// Requests returns every request received as "METHOD /path", in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Scans returns a copy of every scan created, by ID.
+func (s *Server) Scans() map[int]Scan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scans := map[int]Scan{}
+	for id, scan := range s.scans {
+		scans[id] = *scan
+	}
+	return scans
+}
+
+// ConsentKey returns the signing key registered for a machine, or "".
+func (s *Server) ConsentKey(machine string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.consentKeys[machine]
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.Trim(r.URL.Path, "/")
+	s.requests = append(s.requests, r.Method+" /"+path)
+	if s.scenario.LatencyMS > 0 {
+		// Sleep without the lock so concurrent requests are not serialised.
+		s.mu.Unlock()
+		time.Sleep(time.Duration(s.scenario.LatencyMS) * time.Millisecond)
+		s.mu.Lock()
+	}
+
+	endpoint, arg := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		endpoint, arg = path[:i], path[i+1:]
+	}
+	s.total++
+	s.calls[endpoint]++
+	if s.scenario.FlapEvery > 0 && s.total%s.scenario.FlapEvery == 0 {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if s.calls[endpoint] <= s.scenario.Failures[endpoint] {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && endpoint == "status":
+		status := "online"
+		if s.scenario.Offline {
+			status = "offline"
+		}
+		writeJSON(w, map[string]string{"status": status})
+	case r.Method == http.MethodGet && endpoint == "policies":
+		writeJSON(w, map[string]interface{}{"policies": Policies})
+	case r.Method == http.MethodPost && endpoint == "register_consent_key":
+		s.registerConsentKey(w, r)
+	case r.Method == http.MethodPost && endpoint == "create_scan":
+		s.createScan(w, r)
+	case r.Method == http.MethodGet && endpoint == "scan_status":
+		s.withScan(w, arg, s.scanStatus)
+	case r.Method == http.MethodPost && endpoint == "resume_scan":
+		s.withScan(w, arg, func(w http.ResponseWriter, scan *Scan) {
+			scan.Resumed++
+			writeJSON(w, map[string]string{})
+		})
+	case r.Method == http.MethodPost && endpoint == "export_report":
+		var req struct {
+			ScanID int `json:"scan_id"`
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil || json.Unmarshal(raw, &req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		s.withScan(w, strconv.Itoa(req.ScanID), func(w http.ResponseWriter, scan *Scan) {
+			scan.Exports = append(scan.Exports, raw)
+			writeJSON(w, map[string]string{"message": "Report sent"})
+		})
+	case r.Method == http.MethodPost && endpoint == "stop_scan":
+		s.withScan(w, arg, func(w http.ResponseWriter, scan *Scan) {
+			scan.Stopped = true
+			writeJSON(w, map[string]string{})
+		})
+	case r.Method == http.MethodDelete && endpoint == "delete_scan":
+		s.withScan(w, arg, func(w http.ResponseWriter, scan *Scan) {
+			scan.Deleted = true
+			writeJSON(w, map[string]string{})
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// registerConsentKey pins the first key registered for a machine, as the
+// real API does.
+func (s *Server) registerConsentKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Machine   string `json:"machine"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PublicKey == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if key, ok := s.consentKeys[req.Machine]; ok && key != req.PublicKey {
+		http.Error(w, "another key is registered for this machine", http.StatusConflict)
+		return
+	}
+	s.consentKeys[req.Machine] = req.PublicKey
+	writeJSON(w, map[string]string{})
+}
+
+func (s *Server) createScan(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if email, _ := req["email"].(string); email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	// A retried request with the same key gets the scan created first.
+	key := r.Header.Get("Idempotency-Key")
+	if id, ok := s.idempotency[key]; ok && key != "" {
+		writeJSON(w, map[string]int{"scan_id": id})
+		return
+	}
+	s.nextID++
+	s.scans[s.nextID] = &Scan{ID: s.nextID, Request: req, Exports: []json.RawMessage{}}
+	if key != "" {
+		s.idempotency[key] = s.nextID
+	}
+	writeJSON(w, map[string]int{"scan_id": s.nextID})
+}
+
+func (s *Server) withScan(w http.ResponseWriter, id string, fn func(http.ResponseWriter, *Scan)) {
+	n, err := strconv.Atoi(id)
+	scan, ok := s.scans[n]
+	if err != nil || !ok || scan.Deleted {
+		http.Error(w, fmt.Sprintf("scan %s not found", id), http.StatusNotFound)
+		return
+	}
+	fn(w, scan)
+}
+
+// scanStatus answers in the format of the Nessus scan details.
+func (s *Server) scanStatus(w http.ResponseWriter, scan *Scan) {
+	status := s.scenario.next(scan)
+	resp := map[string]interface{}{"info": map[string]string{"status": status.State}}
+	if status.State != "pending" {
+		resp["hosts"] = []map[string]interface{}{status.host("")}
+	}
+	writeJSON(w, resp)
+}
+
+// next returns the status of a scan on its next poll.
+func (sc Scenario) next(scan *Scan) Status {
+	statuses := sc.Statuses
+	if len(statuses) == 0 {
+		statuses = []Status{completed}
+	}
+	if scan.step >= len(statuses) {
+		scan.step = len(statuses) - 1
+	}
+	// A paused scan stays paused until it is resumed.
+	if statuses[scan.step].State == "paused" && scan.Resumed > scan.resumes {
+		scan.resumes++
+		if scan.step < len(statuses)-1 {
+			scan.step++
+		}
+	}
+	status := statuses[scan.step]
+	if status.State != "paused" {
+		scan.step++
+	}
+	if scan.Stopped && (status.State == "running" || status.State == "pending") {
+		status.State = "canceled"
+	}
+	scan.Polls++
+	return status
+}
+
+// host is the row of a host in the scan details.
+func (st Status) host(name string) map[string]interface{} {
+	host := map[string]interface{}{
+		"critical":            st.Critical,
+		"high":                st.High,
+		"medium":              st.Medium,
+		"low":                 st.Low,
+		"info":                st.Info,
+		"scanprogresscurrent": st.Progress,
+		"progress":            fmt.Sprintf("%d%%", st.Progress),
+	}
+	if name != "" {
+		host["hostname"] = name
+	}
+	return host
+}
@@ -0,0 +1,445 @@
+package fakeapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ScanConfigs are the scan configs the fake Greenbone manager offers, by
+// UUID. The last one is a compliance policy, which cannot be scanned with.
+var ScanConfigs = []struct{ ID, Name, UsageType string }{
+	{"daa20556-f6ea-11e0-b6b5-406186ea4fc5", "Full and fast", "scan"},
+	{"8715c877-47a0-438d-98a3-27c7a6ab2196", "Discovery", "scan"},
+	{"9f822ad3-9208-4e02-ac03-78dce3ca9a23", "IT-Grundschutz", "policy"},
+}
+
+// GMPTask is a task created on the fake Greenbone manager.
+type GMPTask struct {
+	Scan
+	Name   string   `json:"name"`
+	Config string   `json:"config"`
+	Hosts  []string `json:"hosts"`
+	// Login is the username of the target's SSH credential, if any.
+	Login string `json:"login"`
+	// Reports are the format IDs of the reports downloaded.
+	Reports []string `json:"reports"`
+	// TargetDeleted and CredentialDeleted are set when the task's target
+	// and credential were deleted.
+	TargetDeleted     bool `json:"target_deleted"`
+	CredentialDeleted bool `json:"credential_deleted"`
+
+	target string
+	last   Status
+}
+
+type gmpTarget struct {
+	hosts      []string
+	credential string
+	deleted    bool
+}
+
+// GMP is a stand-in for gvmd, the Greenbone manager. It speaks the parts
+// of the Greenbone Management Protocol the client uses on any connection,
+// such as a Unix socket or TLS, and plays the scenario for every task
+// started, reporting findings on the target's first host.
+type GMP struct {
+	// Username and Password must be given to authenticate.
+	Username string
+	Password string
+	// LostReplies closes the connection instead of answering that many
+	// create commands, once the object is created, as when the reply is
+	// lost.
+	LostReplies int
+
+	mu          sync.Mutex
+	scenario    Scenario
+	next        int
+	tasks       map[string]*GMPTask
+	targets     map[string]*gmpTarget
+	credentials map[string]string // logins by ID
+	deleted     map[string]bool   // credentials deleted
+	names       map[string]string // names of everything created, by ID
+	requests    []string
+}
+
+// NewGMP returns a manager playing the scenario.
+func NewGMP(scenario Scenario, username, password string) *GMP {
+	return &GMP{
+		Username:    username,
+		Password:    password,
+		scenario:    scenario,
+		tasks:       map[string]*GMPTask{},
+		targets:     map[string]*gmpTarget{},
+		credentials: map[string]string{},
+		deleted:     map[string]bool{},
+		names:       map[string]string{},
+	}
+}
+
+// Requests returns the name of every command received, in order.
+func (g *GMP) Requests() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.requests...)
+}
+
+// Tasks returns a copy of every task created, by ID.
+func (g *GMP) Tasks() map[string]GMPTask {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tasks := map[string]GMPTask{}
+	for id, task := range g.tasks {
+		t := *task
+		t.TargetDeleted = g.targets[t.target].deleted
+		t.CredentialDeleted = g.deleted[g.targets[t.target].credential]
+		tasks[id] = t
+	}
+	return tasks
+}
+
+// ServeGMP accepts connections on l until it is closed.
+func (g *GMP) ServeGMP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go g.Serve(conn)
+	}
+}
+
+// gmpCommand is any command: its attributes and the XML inside it.
+type gmpCommand struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+func (c *gmpCommand) attr(name string) string {
+	for _, a := range c.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// decode reads the XML inside the command into v.
+func (c *gmpCommand) decode(v interface{}) error {
+	return xml.Unmarshal(append(append([]byte("<command>"), c.Inner...), "</command>"...), v)
+}
+
+// Serve answers the commands sent on conn until it is closed. Every
+// command but get_version needs the connection to be authenticated first.
+func (g *GMP) Serve(conn net.Conn) {
+	defer conn.Close()
+	dec := xml.NewDecoder(conn)
+	authenticated := false
+	for {
+		var cmd gmpCommand
+		if err := dec.Decode(&cmd); err != nil {
+			return
+		}
+		name := cmd.XMLName.Local
+		var resp string
+		switch {
+		case name == "authenticate":
+			var creds struct {
+				Username string `xml:"credentials>username"`
+				Password string `xml:"credentials>password"`
+			}
+			cmd.decode(&creds)
+			authenticated = creds.Username == g.Username && creds.Password == g.Password
+			if authenticated {
+				resp = `<authenticate_response status="200" status_text="OK"><role>Admin</role></authenticate_response>`
+			} else {
+				resp = `<authenticate_response status="400" status_text="Authentication failed"/>`
+			}
+		case name == "get_version":
+			resp = `<get_version_response status="200" status_text="OK"><version>22.4</version></get_version_response>`
+		case !authenticated:
+			resp = fmt.Sprintf(`<%s_response status="400" status_text="Only command GET_VERSION is allowed before AUTHENTICATE"/>`, name)
+		default:
+			g.mu.Lock()
+			g.requests = append(g.requests, name)
+			resp = g.handle(&cmd)
+			lost := strings.HasPrefix(name, "create_") && strings.Contains(resp, `status="201"`) && g.LostReplies > 0
+			if lost {
+				g.LostReplies--
+			}
+			g.mu.Unlock()
+			if lost {
+				return
+			}
+		}
+		if _, err := conn.Write([]byte(resp)); err != nil {
+			return
+		}
+	}
+}
+
+func (g *GMP) id() string {
+	g.next++
+	return gmpID(g.next)
+}
+
+func gmpID(n int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+}
+
+func reply(name, code, text string) string {
+	return fmt.Sprintf(`<%s_response status="%s" status_text="%s"/>`, name, code, text)
+}
+
+func (g *GMP) handle(cmd *gmpCommand) string {
+	name := cmd.XMLName.Local
+	switch name {
+	case "get_configs":
+		var b strings.Builder
+		b.WriteString(`<get_configs_response status="200" status_text="OK">`)
+		for _, c := range ScanConfigs {
+			fmt.Fprintf(&b, `<config id="%s"><name>%s</name><comment></comment><usage_type>%s</usage_type></config>`, c.ID, c.Name, c.UsageType)
+		}
+		b.WriteString(`</get_configs_response>`)
+		return b.String()
+	case "create_credential":
+		var req struct {
+			Name     string `xml:"name"`
+			Login    string `xml:"login"`
+			Password string `xml:"password"`
+		}
+		if cmd.decode(&req) != nil || req.Login == "" || req.Password == "" {
+			return reply(name, "400", "CREATE_CREDENTIAL requires a login and password")
+		}
+		id := g.id()
+		g.credentials[id] = req.Login
+		g.names[id] = req.Name
+		return fmt.Sprintf(`<create_credential_response status="201" status_text="OK, resource created" id="%s"/>`, id)
+	case "create_target":
+		var req struct {
+			Name     string `xml:"name"`
+			Hosts    string `xml:"hosts"`
+			PortList struct {
+				ID string `xml:"id,attr"`
+			} `xml:"port_list"`
+			SSH struct {
+				ID string `xml:"id,attr"`
+			} `xml:"ssh_credential"`
+		}
+		if cmd.decode(&req) != nil || req.Hosts == "" || req.PortList.ID == "" {
+			return reply(name, "400", "CREATE_TARGET requires hosts and a port list")
+		}
+		if _, ok := g.credentials[req.SSH.ID]; req.SSH.ID != "" && !ok {
+			return reply(name, "404", "Failed to find credential")
+		}
+		id := g.id()
+		g.targets[id] = &gmpTarget{hosts: strings.Split(req.Hosts, ","), credential: req.SSH.ID}
+		g.names[id] = req.Name
+		return fmt.Sprintf(`<create_target_response status="201" status_text="OK, resource created" id="%s"/>`, id)
+	case "create_task":
+		var req struct {
+			Name   string `xml:"name"`
+			Config struct {
+				ID string `xml:"id,attr"`
+			} `xml:"config"`
+			Target struct {
+				ID string `xml:"id,attr"`
+			} `xml:"target"`
+		}
+		cmd.decode(&req)
+		target, ok := g.targets[req.Target.ID]
+		if !ok || target.deleted {
+			return reply(name, "404", "Failed to find target")
+		}
+		known := false
+		for _, c := range ScanConfigs {
+			known = known || (c.ID == req.Config.ID && c.UsageType == "scan")
+		}
+		if !known {
+			return reply(name, "404", "Failed to find config")
+		}
+		id := g.id()
+		g.names[id] = req.Name
+		g.tasks[id] = &GMPTask{Scan: Scan{Exports: []json.RawMessage{}}, Name: req.Name, Config: req.Config.ID, Hosts: target.hosts, Login: g.credentials[target.credential], target: req.Target.ID}
+		return fmt.Sprintf(`<create_task_response status="201" status_text="OK, resource created" id="%s"/>`, id)
+	}
+
+	switch {
+	case name == "get_credentials":
+		return g.list("credential", cmd.attr("filter"), func(id string) bool { _, ok := g.credentials[id]; return ok && !g.deleted[id] })
+	case name == "get_targets":
+		return g.list("target", cmd.attr("filter"), func(id string) bool { t, ok := g.targets[id]; return ok && !t.deleted })
+	case name == "get_tasks" && cmd.attr("task_id") == "":
+		return g.list("task", cmd.attr("filter"), func(id string) bool { t, ok := g.tasks[id]; return ok && !t.Deleted })
+	}
+
+	task, ok := g.tasks[cmd.attr("task_id")]
+	if ok && task.Deleted {
+		ok = false
+	}
+	switch name {
+	case "start_task", "resume_task":
+		if !ok {
+			return reply(name, "404", "Failed to find task")
+		}
+		if name == "start_task" {
+			task.Launched = true
+		} else {
+			task.Resumed++
+		}
+		return fmt.Sprintf(`<%s_response status="202" status_text="OK, request submitted"><report_id>report-%s</report_id></%[1]s_response>`, name, cmd.attr("task_id"))
+	case "stop_task":
+		if !ok {
+			return reply(name, "404", "Failed to find task")
+		}
+		task.Stopped = true
+		return reply(name, "202", "OK, request submitted")
+	case "get_tasks":
+		if !ok {
+			return reply(name, "404", "Failed to find task")
+		}
+		state, progress := "New", -1
+		if task.Launched {
+			task.last = g.scenario.next(&task.Scan)
+			state, progress = gmpStatus(task.last.State), task.last.Progress
+			if state != "Running" {
+				progress = -1
+			}
+		}
+		return fmt.Sprintf(`<get_tasks_response status="200" status_text="OK"><task id="%s"><name>%s</name><status>%s</status><progress>%d</progress></task></get_tasks_response>`,
+			cmd.attr("task_id"), escape(task.Name), state, progress)
+	case "get_results":
+		return g.results(cmd.attr("filter"))
+	case "get_reports":
+		task, ok := g.reportTask(cmd.attr("report_id"))
+		if !ok {
+			return reply(name, "404", "Failed to find report")
+		}
+		task.Reports = append(task.Reports, cmd.attr("format_id"))
+		content := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s report, filter %s\n", cmd.attr("format_id"), cmd.attr("filter"))))
+		return fmt.Sprintf(`<get_reports_response status="200" status_text="OK"><report id="%s" format_id="%s">%s<filters id=""><term>%s</term></filters></report></get_reports_response>`,
+			cmd.attr("report_id"), cmd.attr("format_id"), content, escape(cmd.attr("filter")))
+	case "delete_task":
+		if !ok {
+			return reply(name, "404", "Failed to find task")
+		}
+		task.Deleted = true
+		return reply(name, "200", "OK")
+	case "delete_target":
+		target, ok := g.targets[cmd.attr("target_id")]
+		if !ok || target.deleted {
+			return reply(name, "404", "Failed to find target")
+		}
+		for _, t := range g.tasks {
+			if t.target == cmd.attr("target_id") && !t.Deleted {
+				return reply(name, "400", "Target is in use")
+			}
+		}
+		target.deleted = true
+		return reply(name, "200", "OK")
+	case "delete_credential":
+		id := cmd.attr("credential_id")
+		if _, ok := g.credentials[id]; !ok || g.deleted[id] {
+			return reply(name, "404", "Failed to find credential")
+		}
+		for _, t := range g.targets {
+			if t.credential == id && !t.deleted {
+				return reply(name, "400", "Credential is in use")
+			}
+		}
+		g.deleted[id] = true
+		return reply(name, "200", "OK")
+	}
+	return reply(name, "400", "Bogus command name")
+}
+
+// list answers a get command for every object of kind that exists,
+// keeping those with the name given by a name="..." filter keyword.
+func (g *GMP) list(kind, filter string, exists func(id string) bool) string {
+	var name string
+	if _, v, ok := strings.Cut(filter, `name="`); ok {
+		name, _, _ = strings.Cut(v, `"`)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<get_%ss_response status="200" status_text="OK">`, kind)
+	for i := 1; i <= g.next; i++ {
+		key := gmpID(i)
+		if exists(key) && (name == "" || g.names[key] == name) {
+			fmt.Fprintf(&b, `<%s id="%s"><name>%s</name></%[1]s>`, kind, key, escape(g.names[key]))
+		}
+	}
+	fmt.Fprintf(&b, `<filters id=""><term>%s</term></filters></get_%ss_response>`, escape(filter), kind)
+	return b.String()
+}
+
+// gmpStatus maps the scenario's Nessus states onto task statuses.
+func gmpStatus(state string) string {
+	switch state {
+	case "pending":
+		return "Queued"
+	case "running":
+		return "Running"
+	case "processing":
+		return "Processing"
+	case "paused", "canceled":
+		return "Stopped"
+	case "aborted":
+		return "Interrupted"
+	case "completed":
+		return "Done"
+	}
+	return state
+}
+
+func (g *GMP) reportTask(reportID string) (*GMPTask, bool) {
+	task, ok := g.tasks[strings.TrimPrefix(reportID, "report-")]
+	return task, ok && task.Launched && !task.Deleted
+}
+
+// results lists one result per count of the last status reported on the
+// first host, and one informational result on each other host, for the
+// filter's report_id.
+func (g *GMP) results(filter string) string {
+	keywords := map[string]string{}
+	for _, term := range strings.Fields(filter) {
+		if k, v, ok := strings.Cut(term, "="); ok {
+			keywords[k] = v
+		}
+	}
+	task, ok := g.reportTask(keywords["report_id"])
+	if !ok {
+		return reply("get_results", "404", "Failed to find report")
+	}
+	var b bytes.Buffer
+	b.WriteString(`<get_results_response status="200" status_text="OK">`)
+	for i, host := range task.Hosts {
+		last := Status{Info: 1}
+		if i == 0 {
+			last = task.last
+		}
+		scores := []string{"0.0", "2.6", "5.0", "7.5", "10.0"}
+		for severity, count := range []int{last.Info, last.Low, last.Medium, last.High, last.Critical} {
+			for i := 0; i < count; i++ {
+				oid := fmt.Sprintf("1.3.6.1.4.1.25623.1.0.%d", 10000*(severity+1)+i)
+				fmt.Fprintf(&b, `<result id="%[1]s"><name>NVT %[1]s</name><host>%[2]s<asset asset_id=""/><hostname></hostname></host><port>%[3]d/tcp</port><nvt oid="%[1]s"><type>nvt</type><name>NVT %[1]s</name></nvt><severity>%[4]s</severity><qod><value>80</value></qod></result>`,
+					oid, host, 22+severity, scores[severity])
+			}
+		}
+		// False positives are reported with a negative score.
+		fmt.Fprintf(&b, `<result id="fp"><name>False positive</name><host>%s</host><port>general/tcp</port><nvt oid="1.3.6.1.4.1.25623.1.0.1"/><severity>-1.0</severity></result>`, host)
+	}
+	b.WriteString(`<filters id=""><term>` + escape(filter) + `</term></filters></get_results_response>`)
+	return b.String()
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,299 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Templates are the scan templates the fake Nessus offers.
+var Templates = []struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Desc  string `json:"desc"`
+	Agent bool   `json:"is_agent,omitempty"`
+}{
+	{"731a8e52-3ea6-a291-ec0a-d2ff0619c19d7bd788d6be818b65", "basic", "Basic Network Scan", "A full system scan suitable for any host.", false},
+	{"ad629e16-03b6-8c1d-cef6-ef8c9dd3c658d24bd260ef5f9e66", "advanced", "Advanced Scan", "Configure a scan without using any recommendations.", false},
+	{"bbd4f805-3966-d464-b2d1-0079eb89d69708c3a05ec2812bcf", "compliance", "Policy Compliance Auditing", "Audit system configurations against a known baseline.", false},
+	{"7ae3c6d1-64a4-1d4c-5a7c-d3b6d4ab2c2a3e6f5b1c0d9e8f7a", "agent_basic", "Basic Agent Scan", "A full system scan suitable for any host.", true},
+}
+
+// Nessus is a stand-in for a Nessus scanner's REST API. Scans play the
+// scenario once launched and are exported as files that are ready on the
+// second status check.
+type Nessus struct {
+	// AccessKey and SecretKey are required in the X-ApiKeys header.
+	AccessKey string
+	SecretKey string
+	// LostReplies answers that many scan creations with 504 once the scan
+	// is created, as when the reply is lost.
+	LostReplies int
+
+	mu       sync.Mutex
+	scenario Scenario
+	nextID   int
+	scans    map[int]*Scan
+	hosts    map[int][]string
+	exports  map[int]nessusExport
+	requests []string
+}
+
+type nessusExport struct {
+	scan   int
+	format string
+	checks int
+}
+
+// NewNessus returns a scanner playing the scenario.
+func NewNessus(scenario Scenario, accessKey, secretKey string) *Nessus {
+	return &Nessus{
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		scenario:  scenario,
+		nextID:    10,
+		scans:     map[int]*Scan{},
+		hosts:     map[int][]string{},
+		exports:   map[int]nessusExport{},
+	}
+}
+
+// Requests returns every request received as "METHOD /path", in order.
+func (n *Nessus) Requests() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.requests...)
+}
+
+// Scans returns a copy of every scan created, by ID.
+func (n *Nessus) Scans() map[int]Scan {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	scans := map[int]Scan{}
+	for id, scan := range n.scans {
+		scans[id] = *scan
+	}
+	return scans
+}
+
+func (n *Nessus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	path := strings.Trim(r.URL.Path, "/")
+	n.requests = append(n.requests, r.Method+" /"+path)
+	if r.Header.Get("X-ApiKeys") != fmt.Sprintf("accessKey=%s; secretKey=%s", n.AccessKey, n.SecretKey) {
+		http.Error(w, `{"error": "Invalid Credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "server/status":
+		status := "ready"
+		if n.scenario.Offline {
+			status = "loading"
+		}
+		writeJSON(w, map[string]string{"status": status})
+	case r.Method == http.MethodGet && path == "editor/scan/templates":
+		writeJSON(w, map[string]interface{}{"templates": Templates})
+	case r.Method == http.MethodGet && path == "scans":
+		var scans []map[string]interface{}
+		for id := 11; id <= n.nextID; id++ {
+			if scan := n.scans[id]; !scan.Deleted {
+				settings, _ := scan.Request["settings"].(map[string]interface{})
+				scans = append(scans, map[string]interface{}{"id": id, "name": settings["name"]})
+			}
+		}
+		writeJSON(w, map[string]interface{}{"scans": scans})
+	case r.Method == http.MethodPost && path == "scans":
+		n.createScan(w, r)
+	case len(parts) < 2 || parts[0] != "scans":
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2:
+		n.withScan(w, parts[1], n.scanDetails)
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		n.withScan(w, parts[1], func(w http.ResponseWriter, scan *Scan) {
+			scan.Deleted = true
+			writeJSON(w, map[string]string{})
+		})
+	case r.Method == http.MethodPost && len(parts) == 3:
+		n.withScan(w, parts[1], func(w http.ResponseWriter, scan *Scan) {
+			n.scanAction(w, r, scan, parts[2])
+		})
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[2] == "export":
+		n.withScan(w, parts[1], func(w http.ResponseWriter, scan *Scan) {
+			n.exportFile(w, scan, parts[3], parts[4])
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *Nessus) createScan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UUID     string `json:"uuid"`
+		Settings struct {
+			Name    string `json:"name"`
+			Targets string `json:"text_targets"`
+		} `json:"settings"`
+	}
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	data, _ := json.Marshal(raw)
+	json.Unmarshal(data, &req)
+	known := false
+	for _, t := range Templates {
+		known = known || t.UUID == req.UUID
+	}
+	if !known || req.Settings.Name == "" || req.Settings.Targets == "" {
+		http.Error(w, `{"error": "Invalid scan settings"}`, http.StatusBadRequest)
+		return
+	}
+	n.nextID++
+	n.scans[n.nextID] = &Scan{ID: n.nextID, Request: raw, Exports: []json.RawMessage{}}
+	n.hosts[n.nextID] = strings.Split(req.Settings.Targets, ",")
+	if n.LostReplies > 0 {
+		n.LostReplies--
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"scan": map[string]interface{}{"id": n.nextID, "name": req.Settings.Name}})
+}
+
+func (n *Nessus) withScan(w http.ResponseWriter, id string, fn func(http.ResponseWriter, *Scan)) {
+	i, err := strconv.Atoi(id)
+	scan, ok := n.scans[i]
+	if err != nil || !ok || scan.Deleted {
+		http.Error(w, `{"error": "The requested file was not found."}`, http.StatusNotFound)
+		return
+	}
+	fn(w, scan)
+}
+
+// scanDetails reports the first target with the scenario's counts and the
+// others, scanned alongside, with one informational finding each. The hosts
+// are listed in reverse order.
+func (n *Nessus) scanDetails(w http.ResponseWriter, scan *Scan) {
+	if !scan.Launched {
+		writeJSON(w, map[string]interface{}{"info": map[string]string{"status": "empty"}})
+		return
+	}
+	status := n.scenario.next(scan)
+	resp := map[string]interface{}{"info": map[string]string{"status": status.State}}
+	if status.State != "pending" {
+		var hosts []map[string]interface{}
+		names := n.hosts[scan.ID]
+		for i := len(names) - 1; i >= 0; i-- {
+			if i == 0 {
+				hosts = append(hosts, status.host(names[i]))
+			} else {
+				hosts = append(hosts, Status{Progress: status.Progress, Info: 1}.host(names[i]))
+			}
+		}
+		resp["hosts"] = hosts
+	}
+	writeJSON(w, resp)
+}
+
+func (n *Nessus) scanAction(w http.ResponseWriter, r *http.Request, scan *Scan, action string) {
+	switch action {
+	case "launch":
+		scan.Launched = true
+		writeJSON(w, map[string]string{"scan_uuid": fmt.Sprintf("scan-%d", scan.ID)})
+	case "resume":
+		scan.Resumed++
+		writeJSON(w, map[string]string{})
+	case "stop":
+		scan.Stopped = true
+		writeJSON(w, map[string]string{})
+	case "export":
+		var raw json.RawMessage
+		var req struct {
+			Format string `json:"format"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil || json.Unmarshal(raw, &req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		scan.Exports = append(scan.Exports, raw)
+		file := 1000 + len(n.exports)
+		n.exports[file] = nessusExport{scan: scan.ID, format: req.Format}
+		writeJSON(w, map[string]interface{}{"file": file, "token": fmt.Sprintf("token-%d", file)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *Nessus) exportFile(w http.ResponseWriter, scan *Scan, id, action string) {
+	file, _ := strconv.Atoi(id)
+	export, ok := n.exports[file]
+	if !ok || export.scan != scan.ID {
+		http.Error(w, `{"error": "The requested file was not found."}`, http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "status":
+		export.checks++
+		n.exports[file] = export
+		status := "loading"
+		if export.checks > 1 {
+			status = "ready"
+		}
+		writeJSON(w, map[string]string{"status": status})
+	case "download":
+		if export.checks < 2 {
+			http.Error(w, `{"error": "Report is still being generated"}`, http.StatusConflict)
+			return
+		}
+		if export.format == "nessus" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write(n.nessusFile(scan))
+			return
+		}
+		fmt.Fprintf(w, "%s report of scan %d\n", export.format, scan.ID)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// nessusFile is a .nessus export with one finding per count of the last
+// status reported for the first target.
+func (n *Nessus) nessusFile(scan *Scan) []byte {
+	type item struct {
+		PluginID   int    `xml:"pluginID,attr"`
+		PluginName string `xml:"pluginName,attr"`
+		Severity   int    `xml:"severity,attr"`
+		Port       int    `xml:"port,attr"`
+		Protocol   string `xml:"protocol,attr"`
+	}
+	type host struct {
+		Name  string `xml:"name,attr"`
+		Items []item `xml:"ReportItem"`
+	}
+	statuses := n.scenario.Statuses
+	last := completed
+	if len(statuses) > 0 {
+		last = statuses[len(statuses)-1]
+	}
+	h := host{Name: n.hosts[scan.ID][0]}
+	for severity, count := range []int{last.Info, last.Low, last.Medium, last.High, last.Critical} {
+		for i := 0; i < count; i++ {
+			id := 10000*(severity+1) + i
+			h.Items = append(h.Items, item{PluginID: id, PluginName: fmt.Sprintf("Plugin %d", id), Severity: severity, Port: 445, Protocol: "tcp"})
+		}
+	}
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"NessusClientData_v2"`
+		Hosts   []host   `xml:"Report>ReportHost"`
+	}{Hosts: []host{h}})
+	return data
+}
@@ -0,0 +1,305 @@
+package fakeapi
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Proxy is a stand-in for a corporate proxy. It forwards plain HTTP
+// requests and tunnels CONNECT requests, asking for Basic or NTLM
+// authentication when Username is set, and records what it passed on. It
+// also speaks SOCKS5 through ServeSOCKS5.
+type Proxy struct {
+	// Username and Password are required from clients when Username is set.
+	Username string
+	Password string
+	// NTLM asks for NTLM authentication instead of Basic. The stand-in
+	// checks the handshake and the username but cannot check the password.
+	NTLM bool
+
+	mu         sync.Mutex
+	requests   []string
+	challenged map[string]bool // connections sent an NTLM challenge
+}
+
+// Requests returns what the proxy passed on, such as
+// "GET http://127.0.0.1:8099/status" or "CONNECT 127.0.0.1:8099".
+func (p *Proxy) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *Proxy) record(request string) {
+	p.mu.Lock()
+	p.requests = append(p.requests, request)
+	p.mu.Unlock()
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(w, r) {
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.record("CONNECT " + r.Host)
+		p.tunnel(w, r)
+		return
+	}
+	p.record(r.Method + " " + r.URL.String())
+	p.forward(w, r)
+}
+
+// authorized checks the client's credentials, answering 407 when they are
+// missing or wrong.
+func (p *Proxy) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if p.Username == "" {
+		return true
+	}
+	auth := r.Header.Get("Proxy-Authorization")
+	if !p.NTLM {
+		if auth == "Basic "+base64.StdEncoding.EncodeToString([]byte(p.Username+":"+p.Password)) {
+			return true
+		}
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return false
+	}
+
+	msg, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "NTLM "))
+	p.mu.Lock()
+	challenged := p.challenged[r.RemoteAddr]
+	p.mu.Unlock()
+	switch {
+	case ntlmMessageType(msg) == 1:
+		// The challenge is only valid on this connection.
+		p.mu.Lock()
+		if p.challenged == nil {
+			p.challenged = map[string]bool{}
+		}
+		p.challenged[r.RemoteAddr] = true
+		p.mu.Unlock()
+		w.Header().Set("Proxy-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(ntlmChallengeMessage()))
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return false
+	case ntlmMessageType(msg) == 3 && challenged && ntlmUser(msg) == p.Username:
+		return true
+	}
+	w.Header().Set("Proxy-Authenticate", "NTLM")
+	http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+	return false
+}
+
+func ntlmMessageType(msg []byte) uint32 {
+	if len(msg) < 12 || string(msg[:8]) != "NTLMSSP\x00" {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(msg[8:])
+}
+
+// ntlmChallengeMessage returns a fixed challenge with empty target
+// information.
+func ntlmChallengeMessage() []byte {
+	msg := make([]byte, 52)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], 0x00808201)
+	copy(msg[24:], "\x01\x23\x45\x67\x89\xab\xcd\xef")
+	binary.LittleEndian.PutUint16(msg[40:], 4)
+	binary.LittleEndian.PutUint16(msg[42:], 4)
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	return msg
+}
+
+// ntlmUser returns the username of an authenticate message.
+func ntlmUser(msg []byte) string {
+	if len(msg) < 44 {
+		return ""
+	}
+	length := int(binary.LittleEndian.Uint16(msg[36:]))
+	offset := int(binary.LittleEndian.Uint32(msg[40:]))
+	if offset+length > len(msg) || length%2 != 0 {
+		return ""
+	}
+	units := make([]uint16, length/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(msg[offset+2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		target.Close()
+		return
+	}
+	rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+	rw.Flush()
+	splice(&readerConn{Conn: conn, r: rw.Reader}, target)
+}
+
+// forward sends a plain HTTP request on, streaming the response so event
+// streams arrive as they are sent.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Authorization")
+	out.Header.Del("Proxy-Connection")
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ServeSOCKS5 accepts SOCKS5 connections on l until it is closed. Username
+// and Password are required when Username is set.
+func (p *Proxy) ServeSOCKS5(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			client, target, err := p.socksHandshake(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			splice(client, target)
+		}()
+	}
+}
+
+// socksHandshake implements the parts of RFC 1928 and RFC 1929 that HTTP
+// clients use: the CONNECT command, with or without a password.
+func (p *Proxy) socksHandshake(conn net.Conn) (client, target net.Conn, err error) {
+	r := bufio.NewReader(conn)
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 5 {
+		return nil, nil, errors.New("not SOCKS5")
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, nil, err
+	}
+	method := byte(0)
+	if p.Username != "" {
+		method = 2
+	}
+	if !strings.ContainsRune(string(methods), rune(method)) {
+		conn.Write([]byte{5, 0xff})
+		return nil, nil, errors.New("no acceptable method")
+	}
+	conn.Write([]byte{5, method})
+	if method == 2 {
+		var user, pass string
+		version, _ := r.ReadByte()
+		for _, s := range []*string{&user, &pass} {
+			n, _ := r.ReadByte()
+			b := make([]byte, n)
+			io.ReadFull(r, b)
+			*s = string(b)
+		}
+		if version != 1 || user != p.Username || pass != p.Password {
+			conn.Write([]byte{1, 1})
+			return nil, nil, errors.New("wrong credentials")
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil || request[1] != 1 {
+		return nil, nil, errors.New("unsupported command")
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	default:
+		return nil, nil, errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return nil, nil, err
+	}
+	addr := net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port)))
+	p.record("SOCKS5 " + addr)
+	target, err = net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return &readerConn{Conn: conn, r: r}, target, nil
+}
+
+// readerConn reads through a buffered reader that may already hold data.
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// splice copies between two connections until either side closes.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Finding is one plugin result for one port of a host.
+type Finding struct {
+	PluginID   int       `json:"plugin_id"`
+	PluginName string    `json:"plugin_name"`
+	Severity   int       `json:"severity"` // 0 info to 4 critical, as in Nessus
+	Port       int       `json:"port"`
+	Protocol   string    `json:"protocol"`
+	FirstSeen  time.Time `json:"first_seen"`
+}
+
+func (f Finding) key() string {
+	return fmt.Sprintf("%d/%d/%s", f.PluginID, f.Port, f.Protocol)
+}
+
+var severityNames = []string{"Info", "Low", "Medium", "High", "Critical"}
+
+func severityName(severity int) string {
+	if severity < 0 || severity >= len(severityNames) {
+		return "Unknown"
+	}
+	return severityNames[severity]
+}
+
+// HostFindings are the findings of one completed scan for one host. Host
+// is what scans of the host are compared by: the hostname for this machine,
+// whose tunnel address changes every time the tunnel registers, and the
+// address scanned for the other devices.
+type HostFindings struct {
+	ScanID   int       `json:"scan_id"`
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Address  string    `json:"address,omitempty"`
+	Findings []Finding `json:"findings"`
+}
+
+// machineName is the name the findings of this machine are kept under.
+func machineName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// nessusReport is the part of the .nessus (v2) export format the client reads.
+type nessusReport struct {
+	Hosts []struct {
+		Name  string `xml:"name,attr"`
+		Items []struct {
+			PluginID   string `xml:"pluginID,attr"`
+			PluginName string `xml:"pluginName,attr"`
+			Severity   int    `xml:"severity,attr"`
+			Port       int    `xml:"port,attr"`
+			Protocol   string `xml:"protocol,attr"`
+		} `xml:"ReportItem"`
+	} `xml:"Report>ReportHost"`
+}
+
+// parseNessusExport reads the findings of every host in a .nessus export.
+func parseNessusExport(data []byte, scanID int, at time.Time) ([]HostFindings, error) {
+	var report nessusReport
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error parsing .nessus export: %v", err)
+	}
+	var hosts []HostFindings
+	for _, h := range report.Hosts {
+		hf := HostFindings{ScanID: scanID, Time: at, Host: h.Name}
+		for _, item := range h.Items {
+			id, _ := strconv.Atoi(item.PluginID)
+			hf.Findings = append(hf.Findings, Finding{
+				PluginID:   id,
+				PluginName: item.PluginName,
+				Severity:   item.Severity,
+				Port:       item.Port,
+				Protocol:   item.Protocol,
+				FirstSeen:  at,
+			})
+		}
+		hosts = append(hosts, hf)
+	}
+	return hosts, nil
+}
+
+// downloadFindings fetches the .nessus export of a scan.
+func downloadFindings(ctx context.Context, scanID int) ([]HostFindings, error) {
+	var data []byte
+	err := apiRequest(ctx, http.MethodGet, fmt.Sprintf("download_report/%d?format=nessus", scanID), nil, &data, nil, mutatePolicy)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading report: %v", err)
+	}
+	return parseNessusExport(data, scanID, time.Now())
+}
+
+func findingsDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "findings")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating findings directory: %v", err)
+	}
+	return dir, nil
+}
+
+// loadHostFindings returns every stored scan of host, oldest first.
+func loadHostFindings(host string) ([]HostFindings, error) {
+	dir, err := findingsDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var all []HostFindings
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading findings: %v", err)
+		}
+		var hosts []HostFindings
+		if err := json.Unmarshal(data, &hosts); err != nil {
+			debugPrint("Skipping damaged findings file %s: %v\n", file, err)
+			continue
+		}
+		for _, h := range hosts {
+			if host == "" || h.Host == host {
+				all = append(all, h)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Time.Before(all[j].Time) })
+	return all, nil
+}
+
+func saveFindings(scanID int, hosts []HostFindings) error {
+	dir, err := findingsDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling findings: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d.json", scanID))
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing findings: %v", err)
+	}
+	return nil
+}
+
+// FindingsDiff compares two scans of the same host.
+type FindingsDiff struct {
+	Host       string    `json:"host"`
+	FromScan   int       `json:"from_scan_id,omitempty"`
+	ToScan     int       `json:"to_scan_id"`
+	New        []Finding `json:"new"`
+	Resolved   []Finding `json:"resolved"`
+	Persisting []Finding `json:"persisting"`
+}
+
+// diffFindings compares current with previous and carries the first-seen
+// date of persisting findings forward into current.
+func diffFindings(previous *HostFindings, current *HostFindings) FindingsDiff {
+	diff := FindingsDiff{Host: current.Host, ToScan: current.ScanID, New: []Finding{}, Resolved: []Finding{}, Persisting: []Finding{}}
+	old := map[string]Finding{}
+	if previous != nil {
+		diff.FromScan = previous.ScanID
+		for _, f := range previous.Findings {
+			old[f.key()] = f
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, f := range current.Findings {
+		seen[f.key()] = true
+		if p, ok := old[f.key()]; ok {
+			current.Findings[i].FirstSeen = p.FirstSeen
+			diff.Persisting = append(diff.Persisting, current.Findings[i])
+		} else {
+			diff.New = append(diff.New, f)
+		}
+	}
+	if previous != nil {
+		for _, f := range previous.Findings {
+			if !seen[f.key()] {
+				diff.Resolved = append(diff.Resolved, f)
+			}
+		}
+	}
+
+	for _, list := range [][]Finding{diff.New, diff.Resolved, diff.Persisting} {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Severity != list[j].Severity {
+				return list[i].Severity > list[j].Severity
+			}
+			return list[i].PluginID < list[j].PluginID
+		})
+	}
+	return diff
+}
+
+// printDiff renders a diff in the terminal, leaving out informational
+// findings unless showInfo is set.
+func printDiff(diff FindingsDiff, showInfo bool) {
+	if diff.FromScan == 0 {
+		fmt.Printf("\nFirst recorded scan of %s: %d findings.\n", diff.Host, len(diff.New))
+		return
+	}
+	fmt.Printf("\nChanges on %s since scan %d:\n", diff.Host, diff.FromScan)
+	sections := []struct {
+		title    string
+		colour   string
+		findings []Finding
+	}{
+		{"New", "\033[31m", diff.New},
+		{"Resolved", "\033[32m", diff.Resolved},
+		{"Persisting", "\033[33m", diff.Persisting},
+	}
+	for _, s := range sections {
+		fmt.Printf("%s%s: %d\033[0m\n", s.colour, s.title, len(s.findings))
+		for _, f := range s.findings {
+			if f.Severity == 0 && !showInfo {
+				continue
+			}
+			line := fmt.Sprintf("  [%s] %s (plugin %d, %d/%s)", severityName(f.Severity), f.PluginName, f.PluginID, f.Port, f.Protocol)
+			if s.title == "Persisting" {
+				line += " since " + f.FirstSeen.Local().Format("2006-01-02")
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// recordFindings downloads the findings of a completed scan, stores them and
+// prints what changed since the previous scan of each host.
+func recordFindings(ctx context.Context, b scanBackend, scanID int) error {
+	hosts, err := b.Findings(ctx, scanID)
+	if err != nil || dryRun {
+		return err
+	}
+	self, err := tunnelAddress(ctx)
+	if err != nil {
+		debugPrint("%v\n", err)
+	}
+	for i := range hosts {
+		if hosts[i].Address == "" {
+			hosts[i].Address = hosts[i].Host
+		}
+		if name := machineName(); name != "" && self != "" && hosts[i].Address == self {
+			hosts[i].Host = name
+		}
+		history, err := loadHostFindings(hosts[i].Host)
+		if err != nil {
+			return err
+		}
+		var previous *HostFindings
+		if len(history) > 0 {
+			previous = &history[len(history)-1]
+		}
+		printDiff(diffFindings(previous, &hosts[i]), false)
+	}
+	return saveFindings(scanID, hosts)
+}
+
+// compareStored compares two stored scans of host, by default the two most
+// recent. host may be empty when only one host was scanned.
+func compareStored(host string, from, to int) (FindingsDiff, error) {
+	history, err := loadHostFindings(host)
+	if err != nil {
+		return FindingsDiff{}, err
+	}
+	hosts := map[string]bool{}
+	for _, h := range history {
+		hosts[h.Host] = true
+	}
+	if len(hosts) > 1 {
+		var names []string
+		for name := range hosts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return FindingsDiff{}, fmt.Errorf("several hosts were scanned, choose one with -host: %s", strings.Join(names, ", "))
+	}
+
+	toIndex := len(history) - 1
+	for i, h := range history {
+		if to != 0 && h.ScanID == to {
+			toIndex = i
+		}
+	}
+	if toIndex < 0 || (to != 0 && history[toIndex].ScanID != to) {
+		return FindingsDiff{}, errors.New("no stored findings for that scan")
+	}
+	current := history[toIndex]
+
+	var previous *HostFindings
+	for i := toIndex - 1; i >= 0; i-- {
+		if from == 0 || history[i].ScanID == from {
+			previous = &history[i]
+			break
+		}
+	}
+	if from != 0 && previous == nil {
+		return FindingsDiff{}, fmt.Errorf("no stored findings for scan %d before scan %d", from, current.ScanID)
+	}
+	return diffFindings(previous, &current), nil
+}
+
+// diffCommand prints the difference between two stored scans of a host,
+// by default the two most recent, and returns the exit code.
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	host := fs.String("host", "", "Host to compare, by hostname for this machine or address for other devices, required if several hosts were scanned")
+	from := fs.Int("from", 0, "Scan ID to compare from (default: the scan before -to)")
+	to := fs.Int("to", 0, "Scan ID to compare to (default: the latest scan)")
+	asJSON := fs.Bool("json", false, "Print the diff as JSON")
+	showInfo := fs.Bool("info", false, "Include informational findings")
+	if err := fs.Parse(args); err != nil {
+		return exitFailure
+	}
+	if !isPrivileged() {
+		fmt.Println("Please run as root or administrator to read stored findings.")
+		return exitPrivileges
+	}
+
+	diff, err := compareStored(*host, *from, *to)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFailure
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(data))
+		return exitOK
+	}
+	printDiff(diff, *showInfo)
+	return exitOK
+}
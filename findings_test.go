@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleExport = `<?xml version="1.0" ?>
+<NessusClientData_v2>
+<Report name="scan">
+<ReportHost name="100.64.0.7">
+<HostProperties><tag name="host-ip">100.64.0.7</tag></HostProperties>
+<ReportItem port="445" svc_name="cifs" protocol="tcp" severity="3" pluginID="57608" pluginName="SMB Signing not required"></ReportItem>
+<ReportItem port="0" svc_name="general" protocol="tcp" severity="0" pluginID="19506" pluginName="Nessus Scan Information"></ReportItem>
+</ReportHost>
+<ReportHost name="192.168.1.20">
+<ReportItem port="22" svc_name="ssh" protocol="tcp" severity="2" pluginID="90317" pluginName="SSH Weak Algorithms Supported"></ReportItem>
+</ReportHost>
+</Report>
+</NessusClientData_v2>`
+
+func TestParseNessusExport(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hosts, err := parseNessusExport([]byte(sampleExport), 42, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].Host != "100.64.0.7" || hosts[1].Host != "192.168.1.20" {
+		t.Fatalf("parseNessusExport() = %+v", hosts)
+	}
+	want := Finding{PluginID: 57608, PluginName: "SMB Signing not required", Severity: 3, Port: 445, Protocol: "tcp", FirstSeen: at}
+	if len(hosts[0].Findings) != 2 || hosts[0].Findings[0] != want || hosts[0].ScanID != 42 {
+		t.Errorf("findings of %s = %+v", hosts[0].Host, hosts[0].Findings)
+	}
+
+	if _, err := parseNessusExport([]byte("<NessusClientData_v2><Report>"), 42, at); err == nil {
+		t.Error("parseNessusExport() accepted a truncated export")
+	}
+}
+
+func TestDiffFindings(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := first.AddDate(0, 2, 0)
+	smb := Finding{PluginID: 57608, Severity: 3, Port: 445, Protocol: "tcp"}
+	ssh := Finding{PluginID: 90317, Severity: 2, Port: 22, Protocol: "tcp"}
+	tls := Finding{PluginID: 104743, Severity: 4, Port: 443, Protocol: "tcp"}
+	at := func(f Finding, when time.Time) Finding { f.FirstSeen = when; return f }
+
+	// Without a previous scan every finding is new.
+	current := &HostFindings{ScanID: 2, Host: "pc-1", Findings: []Finding{at(smb, now), at(ssh, now)}}
+	diff := diffFindings(nil, current)
+	if diff.FromScan != 0 || len(diff.New) != 2 || len(diff.Resolved) != 0 || len(diff.Persisting) != 0 {
+		t.Errorf("diffFindings(nil) = %+v", diff)
+	}
+
+	previous := &HostFindings{ScanID: 1, Host: "pc-1", Findings: []Finding{at(smb, first), at(tls, first)}}
+	current = &HostFindings{ScanID: 2, Host: "pc-1", Findings: []Finding{at(ssh, now), at(smb, now)}}
+	diff = diffFindings(previous, current)
+	if diff.FromScan != 1 || diff.ToScan != 2 {
+		t.Errorf("diffFindings() compares %d to %d", diff.FromScan, diff.ToScan)
+	}
+	if len(diff.New) != 1 || diff.New[0].PluginID != ssh.PluginID {
+		t.Errorf("new = %+v", diff.New)
+	}
+	if len(diff.Resolved) != 1 || diff.Resolved[0].PluginID != tls.PluginID {
+		t.Errorf("resolved = %+v", diff.Resolved)
+	}
+	if len(diff.Persisting) != 1 || !diff.Persisting[0].FirstSeen.Equal(first) || !current.Findings[1].FirstSeen.Equal(first) {
+		t.Errorf("persisting = %+v, current = %+v, want first seen carried forward", diff.Persisting, current.Findings)
+	}
+}
+
+// findingsBackend returns the findings given for any scan.
+type findingsBackend struct {
+	scanBackend
+	hosts []HostFindings
+}
+
+func (b findingsBackend) Findings(ctx context.Context, scanID int) ([]HostFindings, error) {
+	hosts := append([]HostFindings(nil), b.hosts...)
+	for i := range hosts {
+		hosts[i].ScanID = scanID
+		hosts[i].Time = time.Now().Add(time.Duration(scanID) * time.Second)
+	}
+	return hosts, nil
+}
+
+func TestRecordFindingsByHostname(t *testing.T) {
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+	address := "100.64.0.7"
+	defer func(old func(context.Context) (string, error)) { tunnelAddress = old }(tunnelAddress)
+	tunnelAddress = func(ctx context.Context) (string, error) { return address, nil }
+
+	smb := Finding{PluginID: 57608, Severity: 3, Port: 445, Protocol: "tcp"}
+	if err := recordFindings(context.Background(), findingsBackend{hosts: []HostFindings{{Host: address, Findings: []Finding{smb}}}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The tunnel registers again with a new address before the next scan.
+	address = "100.64.0.9"
+	if err := recordFindings(context.Background(), findingsBackend{hosts: []HostFindings{{Host: address, Findings: []Finding{smb}}}}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := compareStored("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Host != machineName() || diff.FromScan != 1 || diff.ToScan != 2 || len(diff.Persisting) != 1 || len(diff.New) != 0 {
+		t.Errorf("compareStored() = %+v, want both scans of %s", diff, machineName())
+	}
+}
+
+func TestCompareStored(t *testing.T) {
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+
+	if _, err := compareStored("", 0, 0); err == nil {
+		t.Error("compareStored() found a scan with nothing stored")
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []int{10, 11, 12} {
+		findings := []Finding{{PluginID: 57608 + i, Severity: 2, Port: 445, Protocol: "tcp", FirstSeen: base}}
+		if err := saveFindings(id, []HostFindings{{ScanID: id, Time: base.AddDate(0, 0, i), Host: "pc-1", Findings: findings}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only one scan before it: nothing to compare with.
+	diff, err := compareStored("", 0, 10)
+	if err != nil || diff.FromScan != 0 || len(diff.New) != 1 {
+		t.Errorf("compareStored(to 10) = %+v, %v", diff, err)
+	}
+	tests := []struct {
+		from, to int
+		wantFrom int
+		wantTo   int
+		wantErr  bool
+	}{
+		{0, 0, 11, 12, false},
+		{0, 11, 10, 11, false},
+		{10, 0, 10, 12, false},
+		{12, 11, 0, 0, true},
+		{0, 99, 0, 0, true},
+	}
+	for _, test := range tests {
+		diff, err := compareStored("pc-1", test.from, test.to)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("compareStored(%d, %d) = %+v, want an error", test.from, test.to, diff)
+			}
+			continue
+		}
+		if err != nil || diff.FromScan != test.wantFrom || diff.ToScan != test.wantTo {
+			t.Errorf("compareStored(%d, %d) = %d to %d, %v", test.from, test.to, diff.FromScan, diff.ToScan, err)
+		}
+	}
+
+	if err := saveFindings(13, []HostFindings{{ScanID: 13, Time: base.AddDate(0, 0, 5), Host: "192.168.1.20"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compareStored("", 0, 0); err == nil {
+		t.Error("compareStored() picked a host when several were scanned")
+	}
+	if diff, err := compareStored("192.168.1.20", 0, 0); err != nil || diff.ToScan != 13 || diff.FromScan != 0 {
+		t.Errorf("compareStored(192.168.1.20) = %+v, %v", diff, err)
+	}
+}
+
+func TestDiffCommand(t *testing.T) {
+	if !isPrivileged() {
+		t.Skip("diff needs root or administrator")
+	}
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+
+	if code := diffCommand(nil); code != exitFailure {
+		t.Errorf("diff with nothing stored = %d, want %d", code, exitFailure)
+	}
+	for _, id := range []int{1, 2} {
+		if err := saveFindings(id, []HostFindings{{ScanID: id, Time: time.Now().Add(time.Duration(id) * time.Minute), Host: "pc-1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]int{
+		"":                    exitOK,
+		"-json -from 1 -to 2": exitOK,
+		"-to 7":               exitFailure,
+		"-bogus":              exitFailure,
+	}
+	for args, want := range tests {
+		if code := diffCommand(strings.Fields(args)); code != want {
+			t.Errorf("diff %s = %d, want %d", args, code, want)
+		}
+	}
+}
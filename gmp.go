@@ -0,0 +1,810 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gmpSocket is where gvmd listens by default.
+	gmpSocket = "/run/gvmd/gvmd.sock"
+	// gmpFullAndFast is the scan config offered when the scanner does not
+	// list its own.
+	gmpFullAndFast = "daa20556-f6ea-11e0-b6b5-406186ea4fc5"
+	// gmpDefaultPortList is "All IANA assigned TCP".
+	gmpDefaultPortList = "33d0cd82-57c6-11e1-8ed1-406186ea4fc5"
+	// gmpOpenVASScanner is the built-in OpenVAS scanner.
+	gmpOpenVASScanner = "08b69003-5fc2-4037-a479-93b440211c73"
+)
+
+// gmpReportFormats are the report format IDs of the formats the client
+// can ask a Greenbone scanner for.
+var gmpReportFormats = map[string]string{
+	"pdf": "c402cc3e-b531-11e1-9163-406186ea4fc5",
+	"csv": "c1645568-627a-11e3-a660-406186ea4fc5",
+}
+
+// gmpBackend drives a Greenbone (OpenVAS) scanner through the Greenbone
+// Management Protocol, over TLS or gvmd's Unix socket. Like a Nessus
+// scanner driven directly, it has to be on the tunnel network.
+type gmpBackend struct {
+	network, address string
+	tlsConfig        *tls.Config // nil on the Unix socket
+	username         string
+	password         string
+	portList         string
+	configs          []string // scan config names offered, all when empty
+	reportDir        string
+
+	mu   sync.Mutex // one command at a time on the connection
+	conn net.Conn
+	dec  *xml.Decoder
+
+	scansMu sync.Mutex
+	nextID  int
+	scans   map[int]*gmpScan
+}
+
+// gmpScan is what the client created on the scanner for one scan.
+type gmpScan struct {
+	credential string
+	target     string
+	task       string
+	report     string
+}
+
+func newGMPBackend(cfg BackendConfig) (*gmpBackend, error) {
+	g := &gmpBackend{
+		username:  cfg.Username,
+		password:  cfg.Password,
+		portList:  cfg.PortList,
+		configs:   cfg.Templates,
+		reportDir: cfg.ReportDir,
+		// Task IDs are UUIDs, so the client numbers the scans itself. The
+		// numbers follow the clock to stay unique in the scan history.
+		nextID: int(time.Now().Unix()),
+		scans:  map[int]*gmpScan{},
+	}
+	if g.password == "" {
+		g.password = os.Getenv("GMP_PASSWORD")
+	}
+	if g.username == "" || g.password == "" {
+		return nil, errors.New("the greenbone backend needs a username and password")
+	}
+	if g.portList == "" {
+		g.portList = gmpDefaultPortList
+	}
+
+	address := cfg.URL
+	if address == "" {
+		address = "unix://" + gmpSocket
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner address %q: %v", address, err)
+	}
+	switch {
+	case u.Scheme == "unix" || (u.Scheme == "" && strings.HasPrefix(address, "/")):
+		g.network, g.address = "unix", u.Path
+	case u.Scheme == "tls":
+		g.network, g.address = "tcp", u.Host
+		if u.Port() == "" {
+			g.address = net.JoinHostPort(u.Hostname(), "9390")
+		}
+		g.tlsConfig, err = scannerTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if g.tlsConfig == nil {
+			g.tlsConfig = &tls.Config{}
+		}
+		g.tlsConfig.ServerName = u.Hostname()
+	default:
+		return nil, fmt.Errorf("invalid scanner address %q, expected tls://host:port or unix:///path/to/gvmd.sock", address)
+	}
+	return g, nil
+}
+
+// gmpResponse is the status every GMP response carries.
+type gmpResponse struct {
+	Status     string `xml:"status,attr"`
+	StatusText string `xml:"status_text,attr"`
+}
+
+func (r *gmpResponse) response() *gmpResponse { return r }
+
+type gmpReply interface {
+	response() *gmpResponse
+}
+
+// gmpCommand is a command whose arguments are all attributes.
+type gmpCommand struct {
+	XMLName      xml.Name
+	TaskID       string `xml:"task_id,attr,omitempty"`
+	TargetID     string `xml:"target_id,attr,omitempty"`
+	CredentialID string `xml:"credential_id,attr,omitempty"`
+	ReportID     string `xml:"report_id,attr,omitempty"`
+	FormatID     string `xml:"format_id,attr,omitempty"`
+	Filter       string `xml:"filter,attr,omitempty"`
+	Ultimate     string `xml:"ultimate,attr,omitempty"`
+}
+
+func gmpCmd(name string) gmpCommand {
+	return gmpCommand{XMLName: xml.Name{Local: name}}
+}
+
+type gmpRef struct {
+	ID   string `xml:"id,attr"`
+	Port int    `xml:"port,omitempty"`
+}
+
+type gmpAuthenticate struct {
+	XMLName  xml.Name `xml:"authenticate"`
+	Username string   `xml:"credentials>username"`
+	Password string   `xml:"credentials>password"`
+}
+
+type gmpCreateCredential struct {
+	XMLName  xml.Name `xml:"create_credential"`
+	Name     string   `xml:"name"`
+	Type     string   `xml:"type"`
+	Login    string   `xml:"login"`
+	Password string   `xml:"password"`
+}
+
+type gmpCreateTarget struct {
+	XMLName       xml.Name `xml:"create_target"`
+	Name          string   `xml:"name"`
+	Hosts         string   `xml:"hosts"`
+	PortList      gmpRef   `xml:"port_list"`
+	AliveTests    string   `xml:"alive_tests"`
+	SSHCredential *gmpRef  `xml:"ssh_credential,omitempty"`
+}
+
+type gmpCreateTask struct {
+	XMLName xml.Name `xml:"create_task"`
+	Name    string   `xml:"name"`
+	Config  gmpRef   `xml:"config"`
+	Target  gmpRef   `xml:"target"`
+	Scanner gmpRef   `xml:"scanner"`
+}
+
+type gmpCreated struct {
+	gmpResponse
+	ID string `xml:"id,attr"`
+}
+
+type gmpStarted struct {
+	gmpResponse
+	ReportID string `xml:"report_id"`
+}
+
+type gmpResult struct {
+	Name string `xml:"name"`
+	Host struct {
+		IP string `xml:",chardata"`
+	} `xml:"host"`
+	Port string `xml:"port"`
+	NVT  struct {
+		OID  string `xml:"oid,attr"`
+		Name string `xml:"name"`
+	} `xml:"nvt"`
+	Severity string `xml:"severity"`
+}
+
+type gmpResults struct {
+	gmpResponse
+	Results []gmpResult `xml:"result"`
+}
+
+// gmpPasswords matches the passwords in a command, which are not printed
+// or audited.
+var gmpPasswords = regexp.MustCompile(`<password>[^<]*</password>`)
+
+// request sends one command and decodes the response into out. A failed
+// connection is retried on a new one according to the policy, as are the
+// retryable statuses, which GMP shares with HTTP.
+func (g *gmpBackend) request(ctx context.Context, command interface{}, out gmpReply, policy retryPolicy) (err error) {
+	cmd, err := xml.Marshal(command)
+	if err != nil {
+		return fmt.Errorf("error marshaling GMP command: %v", err)
+	}
+	shown := gmpPasswords.ReplaceAllString(string(cmd), "<password>[redacted]</password>")
+	if dryRun {
+		planf("GMP request: %s", shown)
+		return xml.Unmarshal([]byte(gmpDryRunResponse(string(cmd))), out)
+	}
+	defer func() {
+		audit("api", "GMP "+shown, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, policy.Deadline)
+	defer cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err := g.roundTrip(ctx, cmd, out, policy.AttemptTimeout)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && !retryable(apiErr.StatusCode) {
+			return err
+		}
+		// The connection may be left in the middle of a response.
+		g.close()
+		if ctx.Err() != nil || attempt == policy.MaxAttempts {
+			break
+		}
+		delay := policy.backoff(attempt)
+		debugPrint("GMP %s failed (attempt %d/%d): %v\nRetrying in %s\n", shown, attempt, policy.MaxAttempts, err, delay.Round(time.Second))
+		if sleepCtx(ctx, delay) != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("GMP %s: %v: %v", gmpName(string(cmd)), ctx.Err(), lastErr)
+	}
+	return fmt.Errorf("GMP %s: giving up after %d attempts: %v", gmpName(string(cmd)), policy.MaxAttempts, lastErr)
+}
+
+// roundTrip sends a command on the connection, logging in first on a new
+// one, and reads the response.
+func (g *gmpBackend) roundTrip(ctx context.Context, cmd []byte, out gmpReply, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if g.conn == nil {
+		if err := g.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+	// Cancelling the context interrupts the read.
+	conn, done := g.conn, make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(cmd); err != nil {
+		return fmt.Errorf("error sending GMP command: %v", err)
+	}
+	if err := g.dec.Decode(out); err != nil {
+		return fmt.Errorf("error reading GMP response: %v", err)
+	}
+	if r := out.response(); !strings.HasPrefix(r.Status, "2") {
+		code, _ := strconv.Atoi(r.Status)
+		return &apiError{StatusCode: code, Body: r.StatusText}
+	}
+	return nil
+}
+
+func (g *gmpBackend) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, g.network, g.address)
+	if err != nil {
+		return fmt.Errorf("error connecting to the scanner: %v", err)
+	}
+	if g.tlsConfig != nil {
+		tlsConn := tls.Client(conn, g.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("error connecting to the scanner: %v", err)
+		}
+		conn = tlsConn
+	}
+	conn.SetDeadline(deadline)
+	login, _ := xml.Marshal(gmpAuthenticate{Username: g.username, Password: g.password})
+	var resp gmpResponse
+	dec := xml.NewDecoder(conn)
+	if _, err := conn.Write(login); err != nil {
+		conn.Close()
+		return fmt.Errorf("error logging in to the scanner: %v", err)
+	}
+	if err := dec.Decode(&resp); err != nil {
+		conn.Close()
+		return fmt.Errorf("error logging in to the scanner: %v", err)
+	}
+	if resp.Status != "200" {
+		conn.Close()
+		code, _ := strconv.Atoi(resp.Status)
+		return &apiError{StatusCode: code, Body: "the scanner rejected the login: " + resp.StatusText}
+	}
+	g.conn, g.dec = conn, dec
+	return nil
+}
+
+func (g *gmpBackend) close() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn, g.dec = nil, nil
+	}
+}
+
+// gmpName returns the name of the command in cmd.
+func gmpName(cmd string) string {
+	name := strings.TrimPrefix(cmd, "<")
+	if i := strings.IndexAny(name, " />"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func (g *gmpBackend) Online(ctx context.Context) bool {
+	var resp struct {
+		gmpResponse
+		Version string `xml:"version"`
+	}
+	if err := g.request(ctx, gmpCmd("get_version"), &resp, statusPolicy); err != nil {
+		debugPrint("Error fetching scanner status: %v\n", err)
+		return false
+	}
+	debugPrint("GMP version %s\n", resp.Version)
+	return true
+}
+
+// Policies offers the scanner's scan configs that the config does not
+// leave out, by UUID. Greenbone logs in to Windows with the password
+// itself, which the client never sends, so Windows scans are not
+// credentialed.
+func (g *gmpBackend) Policies(ctx context.Context) []ScanPolicy {
+	var resp struct {
+		gmpResponse
+		Configs []struct {
+			ID        string `xml:"id,attr"`
+			Name      string `xml:"name"`
+			Comment   string `xml:"comment"`
+			UsageType string `xml:"usage_type"`
+		} `xml:"config"`
+	}
+	cmd := gmpCmd("get_configs")
+	cmd.Filter = "rows=-1"
+	fallback := []ScanPolicy{{ID: gmpFullAndFast, Name: "Full and fast", Description: "Most NVTs, optimized by using previously collected information"}}
+	if err := g.request(ctx, cmd, &resp, statusPolicy); err != nil {
+		debugPrint("Using the default scan config: %v\n", err)
+		return fallback
+	}
+	var policies []ScanPolicy
+	for _, c := range resp.Configs {
+		// Compliance policies are configs too, but cannot be scanned with.
+		if (c.UsageType != "" && c.UsageType != "scan") || (len(g.configs) > 0 && !contains(g.configs, c.Name)) {
+			continue
+		}
+		policies = append(policies, ScanPolicy{ID: c.ID, Name: c.Name, Description: c.Comment, CredentialsSupported: runtime.GOOS != "windows"})
+	}
+	if len(policies) == 0 {
+		debugPrint("The scanner offers none of the configured scan configs\n")
+		return fallback
+	}
+	return policies
+}
+
+func (g *gmpBackend) scan(scanID int) (*gmpScan, error) {
+	g.scansMu.Lock()
+	defer g.scansMu.Unlock()
+	scan, ok := g.scans[scanID]
+	if !ok {
+		return nil, fmt.Errorf("scan %d was not started by this client", scanID)
+	}
+	return scan, nil
+}
+
+// Start creates a target for this machine's tunnel address and the
+// targets, with an SSH login if credentials are given, and a task scanning
+// it with the scan config named by the policy, and starts the task. The
+// scan is returned even when a step fails so that Delete removes what was
+// created.
+func (g *gmpBackend) Start(ctx context.Context, req scanRequest) (int, error) {
+	if req.Username != "" && runtime.GOOS == "windows" {
+		return 0, errors.New("the greenbone backend cannot log in to Windows")
+	}
+	host, err := tunnelAddress(ctx)
+	if err != nil {
+		return 0, err
+	}
+	scan := &gmpScan{}
+	g.scansMu.Lock()
+	g.nextID++
+	id := g.nextID
+	g.scans[id] = scan
+	g.scansMu.Unlock()
+
+	hostname, _ := os.Hostname()
+	// The name is unique to this run so that what is created can be found
+	// again.
+	name := fmt.Sprintf("Nessus Client scan of %s (%s)", hostname, newIdempotencyKey())
+	target := gmpCreateTarget{
+		Name:       name,
+		Hosts:      strings.Join(append([]string{host}, req.Targets...), ","),
+		PortList:   gmpRef{ID: g.portList},
+		AliveTests: "Consider Alive", // firewalls often drop pings through the tunnel
+	}
+	if req.Username != "" {
+		scan.credential, err = g.create(ctx, gmpCreateCredential{Name: name, Type: "up", Login: req.Username, Password: req.Password}, "credential", name)
+		if err != nil {
+			return id, fmt.Errorf("error creating credential: %v", err)
+		}
+		target.SSHCredential = &gmpRef{ID: scan.credential, Port: 22}
+	}
+	if scan.target, err = g.create(ctx, target, "target", name); err != nil {
+		return id, fmt.Errorf("error creating target: %v", err)
+	}
+	task := gmpCreateTask{Name: name, Config: gmpRef{ID: req.Policy}, Target: gmpRef{ID: scan.target}, Scanner: gmpRef{ID: gmpOpenVASScanner}}
+	if scan.task, err = g.create(ctx, task, "task", name); err != nil {
+		return id, fmt.Errorf("error creating scan: %v", err)
+	}
+
+	cmd := gmpCmd("start_task")
+	cmd.TaskID = scan.task
+	var started gmpStarted
+	if err := g.request(ctx, cmd, &started, mutatePolicy); err != nil {
+		return id, fmt.Errorf("error starting scan: %v", err)
+	}
+	scan.report = started.ReportID
+	return id, nil
+}
+
+// create sends a create command for an object of kind named name and
+// returns its ID. gvmd has no idempotency key, so the command is sent once:
+// if the reply is lost, the object is looked up by its name, unique to the
+// run, rather than created a second time.
+func (g *gmpBackend) create(ctx context.Context, command interface{}, kind, name string) (string, error) {
+	var created gmpCreated
+	err := g.request(ctx, command, &created, createPolicy)
+	var apiErr *apiError
+	if err == nil || (errors.As(err, &apiErr) && !retryable(apiErr.StatusCode)) {
+		return created.ID, err
+	}
+	var found struct {
+		gmpResponse
+		Objects []struct {
+			XMLName xml.Name
+			ID      string `xml:"id,attr"`
+			Name    string `xml:"name"`
+		} `xml:",any"`
+	}
+	cmd := gmpCmd("get_" + kind + "s")
+	cmd.Filter = fmt.Sprintf("name=%q rows=-1", name)
+	if findErr := g.request(ctx, cmd, &found, pollPolicy); findErr != nil {
+		return "", err
+	}
+	for _, o := range found.Objects {
+		if o.XMLName.Local == kind && o.Name == name && o.ID != "" {
+			debugPrint("Creating the %s failed, but %s was created: %v\n", kind, o.ID, err)
+			return o.ID, nil
+		}
+	}
+	return "", err
+}
+
+// gmpState maps a task status onto the Nessus states the client follows.
+// Greenbone has no pause: a task is only stopped by someone on the scanner,
+// so a stopped task ends the scan like a canceled Nessus scan.
+func gmpState(status string) scanState {
+	switch status {
+	case "New", "Requested", "Queued":
+		return statePending
+	case "Running":
+		return stateRunning
+	case "Processing":
+		return stateProcessing
+	case "Stop Requested", "Delete Requested", "Ultimate Delete Requested":
+		return stateStopping
+	case "Stopped":
+		return stateCanceled
+	case "Interrupted":
+		return stateAborted
+	case "Done":
+		return stateCompleted
+	}
+	return scanState(strings.ToLower(status))
+}
+
+// gmpSeverity maps a CVSS score onto the Nessus severities, 0 for Info to
+// 4 for Critical, using the CVSS v3 ratings.
+func gmpSeverity(score float64) int {
+	switch {
+	case score >= 9:
+		return 4
+	case score >= 7:
+		return 3
+	case score >= 4:
+		return 2
+	case score > 0:
+		return 1
+	}
+	return 0
+}
+
+// results returns the results of the scan's report for every host. False
+// positives and errors, with negative scores, are left out.
+func (g *gmpBackend) results(ctx context.Context, scan *gmpScan) ([]gmpResult, error) {
+	cmd := gmpCmd("get_results")
+	cmd.Filter = fmt.Sprintf("report_id=%s apply_overrides=0 min_qod=70 rows=-1", scan.report)
+	var resp gmpResults
+	if err := g.request(ctx, cmd, &resp, pollPolicy); err != nil {
+		return nil, err
+	}
+	var results []gmpResult
+	for _, r := range resp.Results {
+		if score, err := strconv.ParseFloat(strings.TrimSpace(r.Severity), 64); err == nil && score >= 0 {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// Status reads the task's status and progress, and counts the results
+// found so far on every target by severity, as the other backends do.
+func (g *gmpBackend) Status(ctx context.Context, scanID int) (scanProgress, error) {
+	scan, err := g.scan(scanID)
+	if err != nil {
+		return scanProgress{}, err
+	}
+	var resp struct {
+		gmpResponse
+		Tasks []struct {
+			Status   string `xml:"status"`
+			Progress int    `xml:"progress"`
+		} `xml:"task"`
+	}
+	cmd := gmpCmd("get_tasks")
+	cmd.TaskID = scan.task
+	if err := g.request(ctx, cmd, &resp, pollPolicy); err != nil {
+		return scanProgress{}, err
+	}
+	if len(resp.Tasks) == 0 {
+		return scanProgress{}, fmt.Errorf("task %s not found", scan.task)
+	}
+	task := resp.Tasks[0]
+	p := scanProgress{State: gmpState(task.Status), Current: task.Progress}
+	// The progress is -1 when the task is not running.
+	switch {
+	case p.State == stateCompleted:
+		p.Current = 100
+	case p.Current < 0:
+		p.Current = 0
+	}
+	p.Percentage = fmt.Sprintf("%d%%", p.Current)
+	if scan.report == "" || p.State == statePending {
+		return p, nil
+	}
+	results, err := g.results(ctx, scan)
+	if err != nil {
+		return scanProgress{}, err
+	}
+	for _, r := range results {
+		score, _ := strconv.ParseFloat(strings.TrimSpace(r.Severity), 64)
+		switch gmpSeverity(score) {
+		case 4:
+			p.Critical++
+		case 3:
+			p.High++
+		case 2:
+			p.Medium++
+		case 1:
+			p.Low++
+		default:
+			p.Info++
+		}
+	}
+	return p, nil
+}
+
+// Resume resumes a stopped task, which continues the same report.
+func (g *gmpBackend) Resume(ctx context.Context, scanID int) error {
+	scan, err := g.scan(scanID)
+	if err != nil {
+		return err
+	}
+	cmd := gmpCmd("resume_task")
+	cmd.TaskID = scan.task
+	var resumed gmpStarted
+	if err := g.request(ctx, cmd, &resumed, mutatePolicy); err != nil {
+		return err
+	}
+	if resumed.ReportID != "" {
+		scan.report = resumed.ReportID
+	}
+	return nil
+}
+
+// Updates returns no updates: GMP does not push them.
+func (g *gmpBackend) Updates(ctx context.Context, scanID int) <-chan scanProgress {
+	return noUpdates()
+}
+
+// Delete stops the task if it is running and deletes it with its target
+// and credential, which would otherwise stay on the scanner.
+func (g *gmpBackend) Delete(ctx context.Context, scanID int) error {
+	fmt.Println(tr("Deleting scan..."))
+	scan, err := g.scan(scanID)
+	if err != nil {
+		return err
+	}
+	if scan.task != "" {
+		progress, err := g.Status(ctx, scanID)
+		if err != nil {
+			return fmt.Errorf("Error getting scan status: %v", err)
+		}
+		// A stopped task has nothing left to stop.
+		if progress.State.active() {
+			cmd := gmpCmd("stop_task")
+			cmd.TaskID = scan.task
+			if err := g.request(ctx, cmd, &gmpResponse{}, mutatePolicy); err != nil {
+				return fmt.Errorf("Error stopping the scan: %v", err)
+			}
+			if err := sleepCtx(ctx, scanStopDelay); err != nil {
+				return err
+			}
+		}
+	}
+	deletes := []struct {
+		name string
+		set  func(*gmpCommand)
+		id   string
+	}{
+		{"delete_task", func(c *gmpCommand) { c.TaskID = scan.task }, scan.task},
+		{"delete_target", func(c *gmpCommand) { c.TargetID = scan.target }, scan.target},
+		{"delete_credential", func(c *gmpCommand) { c.CredentialID = scan.credential }, scan.credential},
+	}
+	for _, d := range deletes {
+		if d.id == "" {
+			continue
+		}
+		cmd := gmpCmd(d.name)
+		d.set(&cmd)
+		cmd.Ultimate = "1"
+		if err := g.request(ctx, cmd, &gmpResponse{}, mutatePolicy); err != nil {
+			return fmt.Errorf("Error deleting the scan: %v", err)
+		}
+	}
+	g.scansMu.Lock()
+	delete(g.scans, scanID)
+	g.scansMu.Unlock()
+	return nil
+}
+
+// checkExport rejects the formats a Greenbone scanner cannot produce
+// before the scan is started.
+func (g *gmpBackend) checkExport(options ExportOptions) error {
+	req, err := options.exportRequest(0, "")
+	if err != nil {
+		return err
+	}
+	for _, format := range req.Formats {
+		if _, ok := gmpReportFormats[format]; !ok {
+			return fmt.Errorf("the greenbone backend cannot export %s reports, only pdf and csv", format)
+		}
+	}
+	return nil
+}
+
+// gmpSeverityFilter is the lowest score of each severity the report can be
+// limited to.
+var gmpSeverityFilter = map[int]string{1: "0.0", 2: "3.9", 3: "6.9", 4: "8.9"}
+
+// Export saves the report in every format asked for to the report
+// directory. Report chapters only apply to Nessus reports and the
+// recipients are not used.
+func (g *gmpBackend) Export(ctx context.Context, scanID int, email string, options ExportOptions) error {
+	if err := g.checkExport(options); err != nil {
+		return err
+	}
+	req, _ := options.exportRequest(scanID, email)
+	scan, err := g.scan(scanID)
+	if err != nil {
+		return err
+	}
+	for _, format := range req.Formats {
+		cmd := gmpCmd("get_reports")
+		cmd.ReportID = scan.report
+		cmd.FormatID = gmpReportFormats[format]
+		cmd.Filter = "apply_overrides=0 min_qod=70 rows=-1 first=1"
+		if req.MinSeverity > 0 {
+			cmd.Filter += " severity>" + gmpSeverityFilter[req.MinSeverity]
+		}
+		var resp struct {
+			gmpResponse
+			Report struct {
+				Content string `xml:",chardata"`
+			} `xml:"report"`
+		}
+		if err := g.request(ctx, cmd, &resp, mutatePolicy); err != nil {
+			return fmt.Errorf("error downloading report: %v", err)
+		}
+		// Reports other than XML are sent base64 encoded.
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(resp.Report.Content))
+		if err != nil {
+			return fmt.Errorf("error decoding report: %v", err)
+		}
+		if err := saveReport(g.reportDir, scanID, format, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Findings converts the results of the scan's report. The plugin ID is the
+// last number of the NVT's OID.
+func (g *gmpBackend) Findings(ctx context.Context, scanID int) ([]HostFindings, error) {
+	scan, err := g.scan(scanID)
+	if err != nil {
+		return nil, err
+	}
+	results, err := g.results(ctx, scan)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading results: %v", err)
+	}
+	now := time.Now()
+	var hosts []HostFindings
+	index := map[string]int{}
+	for _, r := range results {
+		host := strings.TrimSpace(r.Host.IP)
+		i, ok := index[host]
+		if !ok {
+			i = len(hosts)
+			index[host] = i
+			hosts = append(hosts, HostFindings{ScanID: scanID, Time: now, Host: host})
+		}
+		oid := strings.Split(r.NVT.OID, ".")
+		id, _ := strconv.Atoi(oid[len(oid)-1])
+		name := r.NVT.Name
+		if name == "" {
+			name = r.Name
+		}
+		// Ports are given as "445/tcp", or "general/tcp" for the host.
+		portName, protocol, _ := strings.Cut(r.Port, "/")
+		port, _ := strconv.Atoi(portName)
+		score, _ := strconv.ParseFloat(strings.TrimSpace(r.Severity), 64)
+		hosts[i].Findings = append(hosts[i].Findings, Finding{
+			PluginID:   id,
+			PluginName: name,
+			Severity:   gmpSeverity(score),
+			Port:       port,
+			Protocol:   protocol,
+			FirstSeen:  now,
+		})
+	}
+	return hosts, nil
+}
+
+// gmpDryRunResponse answers like a scanner where the scan completes at
+// once with no results.
+func gmpDryRunResponse(cmd string) string {
+	name := gmpName(cmd)
+	switch name {
+	case "get_version":
+		return `<get_version_response status="200" status_text="OK"><version>22.4</version></get_version_response>`
+	case "get_configs":
+		return `<get_configs_response status="200" status_text="OK"><config id="` + gmpFullAndFast + `"><name>Full and fast</name><usage_type>scan</usage_type></config></get_configs_response>`
+	case "create_credential", "create_target", "create_task":
+		return `<` + name + `_response status="201" status_text="OK, resource created" id="dry-run"/>`
+	case "start_task", "resume_task":
+		return `<` + name + `_response status="202" status_text="OK, request submitted"><report_id>dry-run</report_id></` + name + `_response>`
+	case "get_tasks":
+		return `<get_tasks_response status="200" status_text="OK"><task id="dry-run"><status>Done</status><progress>-1</progress></task></get_tasks_response>`
+	}
+	return `<` + name + `_response status="200" status_text="OK"/>`
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/altfreq07/Nessus_Client/fakeapi"
+)
+
+// useFakeGMP serves a fake Greenbone manager on a Unix socket, or over TLS
+// with a backend that trusts its certificate.
+func useFakeGMP(t *testing.T, scenario fakeapi.Scenario, overTLS bool) (*fakeapi.GMP, *gmpBackend) {
+	t.Helper()
+	fake := fakeapi.NewGMP(scenario, "admin", "secret")
+	cfg := BackendConfig{Type: "greenbone", Username: "admin", Password: "secret", ReportDir: t.TempDir()}
+	var l net.Listener
+	var err error
+	if overTLS {
+		// Borrow the certificate of a test HTTPS server.
+		srv := httptest.NewTLSServer(nil)
+		srv.Close()
+		l, err = tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+		cfg.URL, cfg.CAFile = "tls://"+l.Addr().String(), writeCA(t, srv.Certificate())
+	} else {
+		socket := filepath.Join(t.TempDir(), "gvmd.sock")
+		l, err = net.Listen("unix", socket)
+		cfg.URL = "unix://" + socket
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go fake.ServeGMP(l)
+
+	b, err := newGMPBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.close)
+	fastScanner(t)
+	return fake, b
+}
+
+func TestGMPBackendLogin(t *testing.T) {
+	fake, b := useFakeGMP(t, fakeapi.Scenarios["complete"], false)
+	b.password = "wrong"
+	if b.Online(context.Background()) {
+		t.Error("Online() with the wrong password = true")
+	}
+	b.password = "secret"
+	if !b.Online(context.Background()) {
+		t.Error("Online() = false")
+	}
+
+	policies := b.Policies(context.Background())
+	// The compliance policy cannot be scanned with.
+	if len(policies) != 2 || policies[0].ID != gmpFullAndFast || policies[1].Name != "Discovery" || !policies[0].CredentialsSupported {
+		t.Errorf("policies = %+v", policies)
+	}
+	b.configs = []string{"Discovery"}
+	if got := b.Policies(context.Background()); len(got) != 1 || got[0].Name != "Discovery" {
+		t.Errorf("policies limited to Discovery = %+v", got)
+	}
+	// Every command after the failed login went over one connection.
+	if got := fake.Requests(); len(got) != 2 {
+		t.Errorf("commands = %q", got)
+	}
+
+	if _, err := newGMPBackend(BackendConfig{Type: "greenbone", URL: "https://gvm", Username: "admin", Password: "secret"}); err == nil {
+		t.Error("newGMPBackend() accepted an https URL")
+	}
+}
+
+func TestGMPBackendScan(t *testing.T) {
+	fake, b := useFakeGMP(t, fakeapi.Scenarios["complete"], true)
+	ctx := context.Background()
+	id, err := b.Start(ctx, scanRequest{Email: "it@example.com", Policy: gmpFullAndFast, Username: "admin", Password: "hunter2", Targets: []string{"192.168.1.20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskID := b.scans[id].task
+	tasks := fake.Tasks()
+	task := tasks[taskID]
+	if len(tasks) != 1 || !task.Launched || strings.Join(task.Hosts, ",") != "100.64.0.7,192.168.1.20" || task.Login != "admin" {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+
+	// The status counts both targets: the LAN device adds one finding.
+	var p scanProgress
+	for p.State != stateCompleted {
+		if p, err = b.Status(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.High != 2 || p.Medium != 5 || p.Low != 1 || p.Info != 15 || p.Current != 100 {
+		t.Errorf("final status = %+v", p)
+	}
+
+	if err := b.Export(ctx, id, "it@example.com", ExportOptions{Formats: []string{"pdf"}, MinSeverity: "high"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.reportDir, fmt.Sprintf("scan-%d.pdf", id)))
+	if err != nil || !strings.Contains(string(data), "severity>6.9") {
+		t.Errorf("report = %q, %v", data, err)
+	}
+	if err := b.checkExport(ExportOptions{Formats: []string{"html"}}); err == nil {
+		t.Error("checkExport() accepted html")
+	}
+
+	// The false positive is left out.
+	hosts, err := b.Findings(ctx, id)
+	if err != nil || len(hosts) != 2 || hosts[0].Host != "100.64.0.7" || len(hosts[0].Findings) != 22 || len(hosts[1].Findings) != 1 {
+		t.Fatalf("Findings() = %+v, %v", hosts, err)
+	}
+	if f := hosts[0].Findings[21]; f.Severity != 3 || f.PluginID != 40001 || f.Port != 25 || f.Protocol != "tcp" {
+		t.Errorf("last finding = %+v", f)
+	}
+
+	if err := b.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if task := fake.Tasks()[taskID]; !task.Deleted || !task.TargetDeleted || !task.CredentialDeleted {
+		t.Errorf("after Delete() task = %+v", task)
+	}
+}
+
+func TestGMPState(t *testing.T) {
+	for status, want := range map[string]scanState{"Queued": statePending, "Running": stateRunning, "Stopped": stateCanceled, "Interrupted": stateAborted, "Done": stateCompleted} {
+		if got := gmpState(status); got != want {
+			t.Errorf("gmpState(%q) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestGMPSeverity(t *testing.T) {
+	for score, want := range map[float64]int{0: 0, 0.1: 1, 3.9: 1, 4: 2, 6.9: 2, 7: 3, 8.9: 3, 9: 4, 10: 4} {
+		if got := gmpSeverity(score); got != want {
+			t.Errorf("gmpSeverity(%v) = %d, want %d", score, got, want)
+		}
+	}
+}
+
+func TestGMPBackendCreatesOnce(t *testing.T) {
+	fake, b := useFakeGMP(t, fakeapi.Scenarios["complete"], false)
+	// The replies to the credential, target and task are all lost.
+	fake.LostReplies = 3
+	ctx := context.Background()
+	id, err := b.Start(ctx, scanRequest{Email: "it@example.com", Policy: gmpFullAndFast, Username: "admin", Password: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creates := 0
+	for _, name := range fake.Requests() {
+		if strings.HasPrefix(name, "create_") {
+			creates++
+		}
+	}
+	taskID := b.scans[id].task
+	if tasks := fake.Tasks(); len(tasks) != 1 || creates != 3 || !tasks[taskID].Launched || tasks[taskID].Login != "admin" {
+		t.Fatalf("tasks = %+v after %d create commands", tasks, creates)
+	}
+	if err := b.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if task := fake.Tasks()[taskID]; !task.Deleted || !task.TargetDeleted || !task.CredentialDeleted {
+		t.Errorf("after Delete() task = %+v", task)
+	}
+}
@@ -3,22 +3,28 @@ module github.com/altfreq07/Nessus_Client
 go 1.20
 
 require (
-	github.com/QMUL/ntlmgen v0.0.0-20160211164635-c5fd3399f820 // indirect
-	github.com/alexbrainman/sspi v0.0.0-20210105120005-909beea2cc74 // indirect
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358
+	github.com/QMUL/ntlmgen v0.0.0-20160211164635-c5fd3399f820
+	github.com/dop251/goja v0.0.0-20260106131823-651366fbe6e3
+	github.com/schollz/progressbar/v3 v3.13.1
+	github.com/tc-hib/go-winres v0.3.1
+	golang.org/x/crypto v0.8.0
+	golang.org/x/sys v0.7.0
+)
+
+require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
-	github.com/gosuri/uilive v0.0.4 // indirect
-	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/dlclark/regexp2 v1.11.4 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/schollz/progressbar/v3 v3.13.1 // indirect
-	github.com/tc-hib/go-winres v0.3.1 // indirect
 	github.com/tc-hib/winres v0.1.6 // indirect
 	github.com/urfave/cli/v2 v2.3.0 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
 	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb // indirect
-	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/term v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
 )
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryRecord is one scan in the local history journal.
+type HistoryRecord struct {
+	ScanID       int       `json:"scan_id"`
+	Time         time.Time `json:"time"`
+	Policy       string    `json:"policy"`
+	Credentialed bool      `json:"credentialed"`
+	Critical     int       `json:"critical"`
+	High         int       `json:"high"`
+	Medium       int       `json:"medium"`
+	Low          int       `json:"low"`
+	Info         int       `json:"info"`
+	Duration     float64   `json:"duration_seconds"`
+	// ScanDuration is how long the scan itself took, which estimates how
+	// long the next scan with the policy will take.
+	ScanDuration float64 `json:"scan_duration_seconds,omitempty"`
+	ExitCode     int     `json:"exit_code"`
+}
+
+// completed reports whether the scan of the run finished, even if findings
+// above the -fail-on severity remain.
+func (r HistoryRecord) completed() bool {
+	return r.ExitCode == exitOK || r.ExitCode == exitFindings
+}
+
+// risk weighs the findings so that scans can be compared with one number.
+func (r HistoryRecord) risk() int {
+	return r.Critical*10 + r.High*5 + r.Medium*2 + r.Low
+}
+
+func historyFile() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// recordHistory appends the result of a run to the journal. Runs that never
+// created a scan are not recorded.
+func recordHistory(result scanResult) error {
+	if result.ScanID == 0 || dryRun {
+		return nil
+	}
+	record := HistoryRecord{
+		ScanID:       result.ScanID,
+		Time:         result.Started,
+		Policy:       result.Policy,
+		Credentialed: result.Credentialed,
+		Critical:     result.Final.Critical,
+		High:         result.Final.High,
+		Medium:       result.Final.Medium,
+		Low:          result.Final.Low,
+		Info:         result.Final.Info,
+		Duration:     result.Duration.Seconds(),
+		ScanDuration: result.ScanDuration.Seconds(),
+		ExitCode:     result.ExitCode,
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshaling history record: %v", err)
+	}
+
+	path, err := historyFile()
+	if err != nil {
+		return err
+	}
+	// One JSON object per line, appended, so an interrupted write can only
+	// damage the last record.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening history: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing history: %v", err)
+	}
+	return nil
+}
+
+// loadHistory returns every record in the journal, oldest first. Damaged
+// lines are skipped.
+func loadHistory() ([]HistoryRecord, error) {
+	path, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening history: %v", err)
+	}
+	defer f.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			debugPrint("Skipping damaged history line: %v\n", err)
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history: %v", err)
+	}
+	return records, nil
+}
+
+// previousRun returns the last completed run before records[i] with the same
+// policy, the only one its findings can be compared with.
+func previousRun(records []HistoryRecord, i int) (HistoryRecord, bool) {
+	for j := i - 1; j >= 0; j-- {
+		if records[j].Policy == records[i].Policy && records[j].completed() {
+			return records[j], true
+		}
+	}
+	return HistoryRecord{}, false
+}
+
+// trendArrow compares a count with the previous scan's.
+func trendArrow(current, previous int, first bool) string {
+	switch {
+	case first:
+		return fmt.Sprintf("%d", current)
+	case current > previous:
+		return fmt.Sprintf("%d (+%d)", current, current-previous)
+	case current < previous:
+		return fmt.Sprintf("%d (-%d)", current, previous-current)
+	}
+	return fmt.Sprintf("%d (=)", current)
+}
+
+// historyCommand prints the scan history and returns the exit code.
+func historyCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	limit := fs.Int("limit", 12, "Number of most recent scans to show, 0 for all")
+	asJSON := fs.Bool("json", false, "Print the records as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitFailure
+	}
+	if !isPrivileged() {
+		fmt.Println("Please run as root or administrator to read the scan history.")
+		return exitPrivileges
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFailure
+	}
+	start := 0
+	if *limit > 0 && len(records) > *limit {
+		start = len(records) - *limit
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(records[start:], "", "  ")
+		fmt.Println(string(data))
+		return exitOK
+	}
+	if len(records) == 0 {
+		fmt.Println("No scans recorded yet.")
+		return exitOK
+	}
+	printHistory(os.Stdout, records, start)
+	return exitOK
+}
+
+// printHistory prints records[start:] as a table, each count with its trend
+// since the previous completed run of the same policy, and sums up the trend
+// of the policy of the latest completed run.
+func printHistory(out io.Writer, records []HistoryRecord, start int) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tSCAN\tPOLICY\tCREDENTIALED\tCRITICAL\tHIGH\tMEDIUM\tLOW\tINFO\tDURATION\tEXIT")
+	for i := start; i < len(records); i++ {
+		r := records[i]
+		prev, ok := previousRun(records, i)
+		first := !ok
+		fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			r.Time.Local().Format("2006-01-02 15:04"), r.ScanID, r.Policy, r.Credentialed,
+			trendArrow(r.Critical, prev.Critical, first), trendArrow(r.High, prev.High, first),
+			trendArrow(r.Medium, prev.Medium, first), trendArrow(r.Low, prev.Low, first),
+			trendArrow(r.Info, prev.Info, first),
+			time.Duration(r.Duration*float64(time.Second)).Round(time.Second).String(), r.ExitCode)
+	}
+	w.Flush()
+
+	// Summarise the trend over the completed scans shown with the policy
+	// of the latest one, as other policies find different things.
+	var completed []HistoryRecord
+	for i := len(records) - 1; i >= start; i-- {
+		r := records[i]
+		if r.completed() && (len(completed) == 0 || r.Policy == completed[0].Policy) {
+			completed = append(completed, r)
+		}
+	}
+	if len(completed) >= 2 {
+		first, last := completed[len(completed)-1], completed[0]
+		fmt.Fprintln(out)
+		switch {
+		case last.risk() < first.risk():
+			fmt.Fprintf(out, "Your security posture is improving: weighted risk went from %d to %d since %s.\n", first.risk(), last.risk(), first.Time.Local().Format("2006-01-02"))
+		case last.risk() > first.risk():
+			fmt.Fprintf(out, "Your security posture is getting worse: weighted risk went from %d to %d since %s.\n", first.risk(), last.risk(), first.Time.Local().Format("2006-01-02"))
+		default:
+			fmt.Fprintf(out, "Your security posture is unchanged since %s.\n", first.Time.Local().Format("2006-01-02"))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryJournal(t *testing.T) {
+	defer func(old string) { dataDirOverride = old }(dataDirOverride)
+	dataDirOverride = t.TempDir()
+
+	if records, err := loadHistory(); err != nil || len(records) != 0 {
+		t.Fatalf("loadHistory() with no journal = %+v, %v", records, err)
+	}
+
+	started := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	results := []scanResult{
+		{ScanID: 7, Policy: "basic", Final: scanProgress{High: 2, Info: 9}, Started: started, Duration: 40 * time.Minute, ScanDuration: 35 * time.Minute},
+		// A run that never created a scan is not recorded.
+		{ExitCode: exitAPIOffline, Err: errors.New("API is offline"), Started: started.Add(time.Hour)},
+		{ScanID: 8, Policy: "advanced", Credentialed: true, ExitCode: exitScanAborted, Started: started.Add(2 * time.Hour), Duration: 5 * time.Minute},
+	}
+	for _, r := range results {
+		if err := recordHistory(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A damaged line, as left by an interrupted write, is skipped.
+	path, _ := historyFile()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"scan_id": 9, "pol`)
+	f.Close()
+
+	records, err := loadHistory()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("loadHistory() = %+v, %v", records, err)
+	}
+	want := HistoryRecord{ScanID: 7, Time: started, Policy: "basic", High: 2, Info: 9, Duration: 2400, ScanDuration: 2100}
+	if records[0] != want {
+		t.Errorf("first record = %+v, want %+v", records[0], want)
+	}
+	if r := records[1]; r.ScanID != 8 || !r.Credentialed || r.ExitCode != exitScanAborted || r.ScanDuration != 0 {
+		t.Errorf("second record = %+v", r)
+	}
+}
+
+func TestPreviousRun(t *testing.T) {
+	records := []HistoryRecord{
+		{ScanID: 1, Policy: "basic", ExitCode: exitOK},
+		{ScanID: 2, Policy: "basic", ExitCode: exitFindings},
+		{ScanID: 3, Policy: "advanced", ExitCode: exitOK},
+		{ScanID: 4, Policy: "basic", ExitCode: exitScanAborted},
+		{ScanID: 5, Policy: "basic", ExitCode: exitOK},
+		{ScanID: 6, Policy: "webapp", ExitCode: exitOK},
+	}
+	tests := map[int]int{0: 0, 1: 1, 2: 0, 3: 2, 4: 2, 5: 0}
+	for i, want := range tests {
+		prev, ok := previousRun(records, i)
+		if ok != (want != 0) || prev.ScanID != want {
+			t.Errorf("previousRun(scan %d) = scan %d, %v, want scan %d", records[i].ScanID, prev.ScanID, ok, want)
+		}
+	}
+}
+
+func TestTrendArrow(t *testing.T) {
+	tests := []struct {
+		current, previous int
+		first             bool
+		want              string
+	}{
+		{3, 0, true, "3"},
+		{5, 3, false, "5 (+2)"},
+		{1, 3, false, "1 (-2)"},
+		{3, 3, false, "3 (=)"},
+	}
+	for _, tt := range tests {
+		if got := trendArrow(tt.current, tt.previous, tt.first); got != tt.want {
+			t.Errorf("trendArrow(%d, %d, %v) = %q, want %q", tt.current, tt.previous, tt.first, got, tt.want)
+		}
+	}
+}
+
+func TestPrintHistory(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 3, n, 10, 0, 0, 0, time.Local) }
+	records := []HistoryRecord{
+		{ScanID: 1, Time: day(1), Policy: "basic", High: 4, Medium: 2},
+		{ScanID: 2, Time: day(2), Policy: "compliance", Credentialed: true, High: 9, Medium: 30},
+		{ScanID: 3, Time: day(3), Policy: "basic", High: 1, ExitCode: exitScanAborted},
+		{ScanID: 4, Time: day(4), Policy: "basic", High: 3, Medium: 2},
+	}
+	f, err := ioutil.TempFile(t.TempDir(), "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	printHistory(f, records, 0)
+	f.Close()
+	data, _ := ioutil.ReadFile(f.Name())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("printHistory() printed:\n%s", data)
+	}
+
+	// Scan 4 is compared with scan 1: scan 2 has another policy and scan 3
+	// was aborted.
+	row := strings.Fields(lines[4])
+	if row[3] != "basic" || strings.Join(row[5:11], " ") != "0 (=) 3 (-1) 2 (=)" {
+		t.Errorf("row of scan 4 = %q", lines[4])
+	}
+	if row := strings.Fields(lines[2]); strings.Join(row[5:9], " ") != "0 9 30 0" {
+		t.Errorf("row of scan 2 = %q, want no trend for the first compliance scan", lines[2])
+	}
+	if !strings.Contains(lines[6], "improving: weighted risk went from 24 to 19 since 2024-03-01") {
+		t.Errorf("summary = %q", lines[6])
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// language is the two-letter code of the language prompts and messages are
+// shown in. It is detected from the environment unless -lang is given.
+var language = "en"
+
+// translations holds the catalogue for every language other than English,
+// keyed by the English text. Messages missing from a catalogue are shown in
+// English.
+var translations = map[string]map[string]string{
+	"es": {
+		"Installing Tunnel...":         "Instalando el túnel...",
+		"Uninstalling Tunnel":          "Desinstalando el túnel",
+		"Attempting to connect to API": "Conectando con la API",
+		"Please enter your email address to receive the scan results: ": "Introduzca su correo electrónico para recibir los resultados del análisis: ",
+		"Invalid email address. Please enter a valid email address.":    "Correo electrónico no válido. Introduzca una dirección válida.",
+		"Scan results will be sent to: %s\n":                            "Los resultados del análisis se enviarán a: %s\n",
+		"Report saved to %s\n":                                          "Informe guardado en %s\n",
+		"Using scan policy: %s\n":                                       "Política de análisis: %s\n",
+		"This policy needs an account on this machine.":                 "Esta política necesita una cuenta en este equipo.",
+		"Do you want to run a credentialed/full scan? Y/n: ":            "¿Desea realizar un análisis completo con credenciales? S/n: ",
+		"Invalid input. Please enter Y (yes) or N (no).":                "Respuesta no válida. Escriba S (sí) o N (no).",
+		"Enter your username with administrative privileges: ":          "Introduzca un usuario con privilegios de administrador: ",
+		"Enter the password for the account: ":                          "Introduzca la contraseña de la cuenta: ",
+		"Running a non-credentialed scan...":                            "Realizando un análisis sin credenciales...",
+		"Running a credentialed/full scan...":                           "Realizando un análisis completo con credenciales...",
+		"Scan started successfully with Scan ID: %d\n":                  "Análisis iniciado correctamente con el ID %d\n",
+		"Scanning...":                               "Analizando...",
+		"Scan is paused, resuming...":               "El análisis está en pausa, reanudando...",
+		"Scan completed.":                           "Análisis completado.",
+		"Exporting full report...":                  "Exportando el informe completo...",
+		"Deleting scan...":                          "Eliminando el análisis...",
+		"Scan interrupted.":                         "Análisis interrumpido.",
+		"Error:":                                    "Error:",
+		"Available scan policies:":                  "Políticas de análisis disponibles:",
+		"Choose a scan policy [1-%d] (default 1): ": "Elija una política de análisis [1-%d] (por defecto 1): ",
+		"Invalid choice. Please enter one of the numbers above.":      "Opción no válida. Escriba uno de los números anteriores.",
+		"No local networks found, only this machine will be scanned.": "No se han encontrado redes locales, solo se analizará este equipo.",
+		"Other devices on your network that can be scanned:":          "Otros dispositivos de su red que se pueden analizar:",
+		"every host on %s (%s)":                                       "todos los equipos de %s (%s)",
+		"Routing %s through the tunnel...\n":                          "Enrutando %s a través del túnel...\n",
+		"Removing network routes...":                                  "Eliminando las rutas de red...",
+		"Requesting administrator privileges...":                      "Solicitando privilegios de administrador...",
+		"Please run as root or with sudo.":                            "Ejecute el programa como root o con sudo.",
+		"Findings of %s severity or higher remain.\n":                 "Quedan hallazgos de gravedad %s o superior.\n",
+		"Host discovery":    "Descubrimiento de equipos",
+		"Port scan":         "Escaneo de puertos",
+		"Plugin checks":     "Comprobaciones",
+		"Report generation": "Generación del informe",
+		"Copy the NetBird tunnel client to a temporary directory":                                                 "Copiar el cliente del túnel NetBird a un directorio temporal",
+		"Install NetBird as a system service and connect this machine to the scanner's private network":           "Instalar NetBird como servicio del sistema y conectar este equipo a la red privada del escáner",
+		"Let the scanner log in to this machine over the network as %s":                                           "Permitir que el escáner inicie sesión en este equipo a través de la red como %s",
+		"Let the scanner log in to this machine over SSH as %s":                                                   "Permitir que el escáner inicie sesión en este equipo por SSH como %s",
+		"Route the scanner's traffic to %s through this machine":                                                  "Enrutar el tráfico del escáner hacia %s a través de este equipo",
+		"Start the Windows Management Instrumentation service":                                                    "Iniciar el servicio Instrumental de administración de Windows",
+		"Set the Remote Registry service to start automatically":                                                  "Configurar el servicio Registro remoto para que se inicie automáticamente",
+		"Start the Remote Registry service":                                                                       "Iniciar el servicio Registro remoto",
+		"Enable the File and Printer Sharing firewall rules":                                                      "Habilitar las reglas de firewall de Compartir archivos e impresoras",
+		"Enable the Windows Management Instrumentation (WMI) firewall rules":                                      "Habilitar las reglas de firewall de Instrumental de administración de Windows (WMI)",
+		"Set LocalAccountTokenFilterPolicy to 1, which lets local administrator accounts log in over the network": "Establecer LocalAccountTokenFilterPolicy en 1, lo que permite a las cuentas de administrador locales iniciar sesión a través de la red",
+		"The following changes will be made to this machine and undone when the scan ends:":                       "Se realizarán los siguientes cambios en este equipo, que se desharán al terminar el análisis:",
+		"Do you accept these changes? y/n: ":                                                                      "¿Acepta estos cambios? s/n: ",
+		"Please answer y or n.":                                                                                   "Responda s o n.",
+		"not connected":                                                                                           "no conectado",
+		"connected":                                                                                               "conectado",
+		"Tunnel: %s":                                                                                              "Túnel: %s",
+		"Waiting for the scan to start...":                                                                        "Esperando a que empiece el análisis...",
+		"Scan %d":                                                                                                 "Análisis %d",
+		"Yes":                                                                                                     "Sí",
+		"No":                                                                                                      "No",
+		"↑/↓ choose · Enter confirm · Ctrl+C quit":                                                                "↑/↓ elegir · Intro confirmar · Ctrl+C salir",
+		"Changes to this machine":                                                                                 "Cambios en este equipo",
+		"Do you accept these changes?":                                                                            "¿Acepta estos cambios?",
+		"The scan results will be sent to this address.":                                                          "Los resultados del análisis se enviarán a esta dirección.",
+		"Email":                       "Correo electrónico",
+		"Email address":               "Dirección de correo electrónico",
+		"Enter confirm · Ctrl+C quit": "Intro confirmar · Ctrl+C salir",
+		"Scan type":                   "Tipo de análisis",
+		"A credentialed scan logs in to this machine and finds more issues,": "Un análisis con credenciales inicia sesión en este equipo y encuentra más problemas,",
+		"such as missing updates. It needs an account on this machine.":      "como actualizaciones que faltan. Necesita una cuenta en este equipo.",
+		"Do you want to run a credentialed/full scan?":                       "¿Desea realizar un análisis completo con credenciales?",
+		"Show password": "Mostrar contraseña",
+		"Enter an account on this machine for the scanner to log in with.": "Introduzca una cuenta de este equipo con la que el escáner iniciará sesión.",
+		"Username":    "Usuario",
+		"Password":    "Contraseña",
+		"Credentials": "Credenciales",
+		"Tab next field · Space show/hide · Enter confirm · Ctrl+C quit": "Tab campo siguiente · Espacio mostrar/ocultar · Intro confirmar · Ctrl+C salir",
+		"Devices to scan": "Dispositivos que analizar",
+		"↑/↓ move · Space select · a all devices · Enter confirm · Ctrl+C quit": "↑/↓ mover · Espacio seleccionar · a todos los dispositivos · Intro confirmar · Ctrl+C salir",
+		"non-credentialed":    "sin credenciales",
+		"credentialed, as %s": "con credenciales, como %s",
+		"this machine":        "este equipo",
+		"Policy":              "Política",
+		"Targets":             "Objetivos",
+		"Summary":             "Resumen",
+		"Start the scan?":     "¿Iniciar el análisis?",
+		"Scan":                "Análisis",
+		"Ctrl+C stop the scan and undo every change": "Ctrl+C detener el análisis y deshacer todos los cambios",
+		"Scan failed":                         "El análisis ha fallado",
+		"Scan ID":                             "ID del análisis",
+		"Duration":                            "Duración",
+		"Exit code":                           "Código de salida",
+		"The full report will be sent to %s.": "El informe completo se enviará a %s.",
+		"Some changes could not be undone, see the messages below.": "Algunos cambios no se han podido deshacer, consulte los mensajes siguientes.",
+		"Every change made to this machine has been undone.":        "Se han deshecho todos los cambios realizados en este equipo.",
+		"Enter exit":                             "Intro salir",
+		"Opening the scanner in your browser...": "Abriendo el escáner en su navegador...",
+		"If it does not open, go to %s\n":        "Si no se abre, vaya a %s\n",
+		"This page can only be opened from the link the scanner opened.": "Esta página solo se puede abrir desde el enlace que abrió el escáner.",
+		"Stopping the scan and undoing every change...":                  "Deteniendo el análisis y deshaciendo todos los cambios...",
+		"This link has already been used.":                               "Este enlace ya se ha utilizado.",
+		"Enter both a username and a password.":                          "Introduzca un usuario y una contraseña.",
+		"ETA unknown":                                                    "Tiempo restante desconocido",
+		"ETA <1m":                                                        "Quedan <1m",
+		"ETA %dm":                                                        "Quedan %dm",
+		"ETA %dh%02dm":                                                   "Quedan %dh%02dm",
+		"Enter the numbers or addresses to scan separated by commas, \"all\" for every device listed, or leave blank for none: ": "Escriba los números o direcciones que desea analizar separados por comas, \"all\" para todos los dispositivos de la lista, o déjelo en blanco para ninguno: ",
+	},
+	"fr": {
+		"Installing Tunnel...":         "Installation du tunnel...",
+		"Uninstalling Tunnel":          "Désinstallation du tunnel",
+		"Attempting to connect to API": "Connexion à l'API",
+		"Please enter your email address to receive the scan results: ": "Saisissez votre adresse e-mail pour recevoir les résultats de l'analyse : ",
+		"Invalid email address. Please enter a valid email address.":    "Adresse e-mail non valide. Saisissez une adresse valide.",
+		"Scan results will be sent to: %s\n":                            "Les résultats de l'analyse seront envoyés à : %s\n",
+		"Report saved to %s\n":                                          "Rapport enregistré dans %s\n",
+		"Using scan policy: %s\n":                                       "Politique d'analyse : %s\n",
+		"This policy needs an account on this machine.":                 "Cette politique nécessite un compte sur cette machine.",
+		"Do you want to run a credentialed/full scan? Y/n: ":            "Voulez-vous lancer une analyse complète avec identifiants ? O/n : ",
+		"Invalid input. Please enter Y (yes) or N (no).":                "Réponse non valide. Saisissez O (oui) ou N (non).",
+		"Enter your username with administrative privileges: ":          "Saisissez un nom d'utilisateur disposant des droits d'administrateur : ",
+		"Enter the password for the account: ":                          "Saisissez le mot de passe du compte : ",
+		"Running a non-credentialed scan...":                            "Analyse sans identifiants en cours...",
+		"Running a credentialed/full scan...":                           "Analyse complète avec identifiants en cours...",
+		"Scan started successfully with Scan ID: %d\n":                  "Analyse démarrée avec l'identifiant %d\n",
+		"Scanning...":                               "Analyse en cours...",
+		"Scan is paused, resuming...":               "L'analyse est en pause, reprise...",
+		"Scan completed.":                           "Analyse terminée.",
+		"Exporting full report...":                  "Export du rapport complet...",
+		"Deleting scan...":                          "Suppression de l'analyse...",
+		"Scan interrupted.":                         "Analyse interrompue.",
+		"Error:":                                    "Erreur :",
+		"Available scan policies:":                  "Politiques d'analyse disponibles :",
+		"Choose a scan policy [1-%d] (default 1): ": "Choisissez une politique d'analyse [1-%d] (1 par défaut) : ",
+		"Invalid choice. Please enter one of the numbers above.":      "Choix non valide. Saisissez l'un des numéros ci-dessus.",
+		"No local networks found, only this machine will be scanned.": "Aucun réseau local trouvé, seule cette machine sera analysée.",
+		"Other devices on your network that can be scanned:":          "Autres appareils de votre réseau pouvant être analysés :",
+		"every host on %s (%s)":                                       "tous les hôtes de %s (%s)",
+		"Routing %s through the tunnel...\n":                          "Routage de %s par le tunnel...\n",
+		"Removing network routes...":                                  "Suppression des routes réseau...",
+		"Requesting administrator privileges...":                      "Demande des droits d'administrateur...",
+		"Please run as root or with sudo.":                            "Lancez le programme en tant que root ou avec sudo.",
+		"Findings of %s severity or higher remain.\n":                 "Des vulnérabilités de gravité %s ou supérieure subsistent.\n",
+		"Host discovery":    "Découverte des hôtes",
+		"Port scan":         "Analyse des ports",
+		"Plugin checks":     "Vérifications",
+		"Report generation": "Génération du rapport",
+		"Copy the NetBird tunnel client to a temporary directory":                                                 "Copier le client du tunnel NetBird dans un répertoire temporaire",
+		"Install NetBird as a system service and connect this machine to the scanner's private network":           "Installer NetBird comme service système et connecter cette machine au réseau privé du scanner",
+		"Let the scanner log in to this machine over the network as %s":                                           "Autoriser le scanner à se connecter à cette machine par le réseau en tant que %s",
+		"Let the scanner log in to this machine over SSH as %s":                                                   "Autoriser le scanner à se connecter à cette machine par SSH en tant que %s",
+		"Route the scanner's traffic to %s through this machine":                                                  "Acheminer le trafic du scanner vers %s par cette machine",
+		"Start the Windows Management Instrumentation service":                                                    "Démarrer le service Infrastructure de gestion Windows",
+		"Set the Remote Registry service to start automatically":                                                  "Configurer le démarrage automatique du service Registre à distance",
+		"Start the Remote Registry service":                                                                       "Démarrer le service Registre à distance",
+		"Enable the File and Printer Sharing firewall rules":                                                      "Activer les règles de pare-feu Partage de fichiers et d'imprimantes",
+		"Enable the Windows Management Instrumentation (WMI) firewall rules":                                      "Activer les règles de pare-feu Infrastructure de gestion Windows (WMI)",
+		"Set LocalAccountTokenFilterPolicy to 1, which lets local administrator accounts log in over the network": "Définir LocalAccountTokenFilterPolicy à 1, ce qui permet aux comptes administrateur locaux de se connecter par le réseau",
+		"The following changes will be made to this machine and undone when the scan ends:":                       "Les modifications suivantes seront apportées à cette machine et annulées à la fin de l'analyse :",
+		"Do you accept these changes? y/n: ":                                                                      "Acceptez-vous ces modifications ? o/n : ",
+		"Please answer y or n.":                                                                                   "Répondez o ou n.",
+		"not connected":                                                                                           "non connecté",
+		"connected":                                                                                               "connecté",
+		"Tunnel: %s":                                                                                              "Tunnel : %s",
+		"Waiting for the scan to start...":                                                                        "En attente du démarrage de l'analyse...",
+		"Scan %d":                                                                                                 "Analyse %d",
+		"Yes":                                                                                                     "Oui",
+		"No":                                                                                                      "Non",
+		"↑/↓ choose · Enter confirm · Ctrl+C quit":                                                                "↑/↓ choisir · Entrée valider · Ctrl+C quitter",
+		"Changes to this machine":                                                                                 "Modifications de cette machine",
+		"Do you accept these changes?":                                                                            "Acceptez-vous ces modifications ?",
+		"The scan results will be sent to this address.":                                                          "Les résultats de l'analyse seront envoyés à cette adresse.",
+		"Email":                       "E-mail",
+		"Email address":               "Adresse e-mail",
+		"Enter confirm · Ctrl+C quit": "Entrée valider · Ctrl+C quitter",
+		"Scan type":                   "Type d'analyse",
+		"A credentialed scan logs in to this machine and finds more issues,": "Une analyse avec identifiants se connecte à cette machine et trouve plus de problèmes,",
+		"such as missing updates. It needs an account on this machine.":      "comme les mises à jour manquantes. Elle nécessite un compte sur cette machine.",
+		"Do you want to run a credentialed/full scan?":                       "Voulez-vous lancer une analyse complète avec identifiants ?",
+		"Show password": "Afficher le mot de passe",
+		"Enter an account on this machine for the scanner to log in with.": "Saisissez un compte de cette machine avec lequel le scanner se connectera.",
+		"Username":    "Nom d'utilisateur",
+		"Password":    "Mot de passe",
+		"Credentials": "Identifiants",
+		"Tab next field · Space show/hide · Enter confirm · Ctrl+C quit": "Tab champ suivant · Espace afficher/masquer · Entrée valider · Ctrl+C quitter",
+		"Devices to scan": "Appareils à analyser",
+		"↑/↓ move · Space select · a all devices · Enter confirm · Ctrl+C quit": "↑/↓ déplacer · Espace sélectionner · a tous les appareils · Entrée valider · Ctrl+C quitter",
+		"non-credentialed":    "sans identifiants",
+		"credentialed, as %s": "avec identifiants, en tant que %s",
+		"this machine":        "cette machine",
+		"Policy":              "Stratégie",
+		"Targets":             "Cibles",
+		"Summary":             "Résumé",
+		"Start the scan?":     "Lancer l'analyse ?",
+		"Scan":                "Analyse",
+		"Ctrl+C stop the scan and undo every change": "Ctrl+C arrêter l'analyse et annuler toutes les modifications",
+		"Scan failed":                         "L'analyse a échoué",
+		"Scan ID":                             "ID de l'analyse",
+		"Duration":                            "Durée",
+		"Exit code":                           "Code de sortie",
+		"The full report will be sent to %s.": "Le rapport complet sera envoyé à %s.",
+		"Some changes could not be undone, see the messages below.": "Certaines modifications n'ont pas pu être annulées, voir les messages ci-dessous.",
+		"Every change made to this machine has been undone.":        "Toutes les modifications apportées à cette machine ont été annulées.",
+		"Enter exit":                             "Entrée quitter",
+		"Opening the scanner in your browser...": "Ouverture du scanner dans votre navigateur...",
+		"If it does not open, go to %s\n":        "S'il ne s'ouvre pas, allez sur %s\n",
+		"This page can only be opened from the link the scanner opened.": "Cette page ne peut être ouverte que depuis le lien ouvert par le scanner.",
+		"Stopping the scan and undoing every change...":                  "Arrêt de l'analyse et annulation de toutes les modifications...",
+		"This link has already been used.":                               "Ce lien a déjà été utilisé.",
+		"Enter both a username and a password.":                          "Saisissez un nom d'utilisateur et un mot de passe.",
+		"ETA unknown":                                                    "Temps restant inconnu",
+		"ETA <1m":                                                        "Reste <1m",
+		"ETA %dm":                                                        "Reste %dm",
+		"ETA %dh%02dm":                                                   "Reste %dh%02dm",
+		"Enter the numbers or addresses to scan separated by commas, \"all\" for every device listed, or leave blank for none: ": "Saisissez les numéros ou adresses à analyser séparés par des virgules, \"all\" pour tous les appareils listés, ou laissez vide pour aucun : ",
+	},
+	"de": {
+		"Installing Tunnel...":         "Tunnel wird installiert...",
+		"Uninstalling Tunnel":          "Tunnel wird deinstalliert",
+		"Attempting to connect to API": "Verbindung zur API wird hergestellt",
+		"Please enter your email address to receive the scan results: ": "Bitte geben Sie Ihre E-Mail-Adresse für die Scan-Ergebnisse ein: ",
+		"Invalid email address. Please enter a valid email address.":    "Ungültige E-Mail-Adresse. Bitte geben Sie eine gültige Adresse ein.",
+		"Scan results will be sent to: %s\n":                            "Die Scan-Ergebnisse werden gesendet an: %s\n",
+		"Report saved to %s\n":                                          "Bericht gespeichert unter %s\n",
+		"Using scan policy: %s\n":                                       "Scan-Richtlinie: %s\n",
+		"This policy needs an account on this machine.":                 "Diese Richtlinie benötigt ein Konto auf diesem Rechner.",
+		"Do you want to run a credentialed/full scan? Y/n: ":            "Möchten Sie einen vollständigen Scan mit Anmeldedaten durchführen? J/n: ",
+		"Invalid input. Please enter Y (yes) or N (no).":                "Ungültige Eingabe. Bitte J (ja) oder N (nein) eingeben.",
+		"Enter your username with administrative privileges: ":          "Benutzername mit Administratorrechten: ",
+		"Enter the password for the account: ":                          "Passwort für das Konto: ",
+		"Running a non-credentialed scan...":                            "Scan ohne Anmeldedaten läuft...",
+		"Running a credentialed/full scan...":                           "Vollständiger Scan mit Anmeldedaten läuft...",
+		"Scan started successfully with Scan ID: %d\n":                  "Scan erfolgreich gestartet, Scan-ID: %d\n",
+		"Scanning...":                               "Scan läuft...",
+		"Scan is paused, resuming...":               "Der Scan ist pausiert und wird fortgesetzt...",
+		"Scan completed.":                           "Scan abgeschlossen.",
+		"Exporting full report...":                  "Vollständiger Bericht wird exportiert...",
+		"Deleting scan...":                          "Scan wird gelöscht...",
+		"Scan interrupted.":                         "Scan abgebrochen.",
+		"Error:":                                    "Fehler:",
+		"Available scan policies:":                  "Verfügbare Scan-Richtlinien:",
+		"Choose a scan policy [1-%d] (default 1): ": "Scan-Richtlinie wählen [1-%d] (Standard 1): ",
+		"Invalid choice. Please enter one of the numbers above.":      "Ungültige Auswahl. Bitte eine der obigen Nummern eingeben.",
+		"No local networks found, only this machine will be scanned.": "Keine lokalen Netzwerke gefunden, nur dieser Rechner wird gescannt.",
+		"Other devices on your network that can be scanned:":          "Weitere Geräte in Ihrem Netzwerk, die gescannt werden können:",
+		"every host on %s (%s)":                                       "alle Hosts in %s (%s)",
+		"Routing %s through the tunnel...\n":                          "%s wird durch den Tunnel geleitet...\n",
+		"Removing network routes...":                                  "Netzwerkrouten werden entfernt...",
+		"Requesting administrator privileges...":                      "Administratorrechte werden angefordert...",
+		"Please run as root or with sudo.":                            "Bitte als root oder mit sudo ausführen.",
+		"Findings of %s severity or higher remain.\n":                 "Es bestehen weiterhin Befunde mit Schweregrad %s oder höher.\n",
+		"Host discovery":    "Host-Erkennung",
+		"Port scan":         "Port-Scan",
+		"Plugin checks":     "Plugin-Prüfungen",
+		"Report generation": "Berichterstellung",
+		"Copy the NetBird tunnel client to a temporary directory":                                                 "Den NetBird-Tunnelclient in ein temporäres Verzeichnis kopieren",
+		"Install NetBird as a system service and connect this machine to the scanner's private network":           "NetBird als Systemdienst installieren und diesen Rechner mit dem privaten Netzwerk des Scanners verbinden",
+		"Let the scanner log in to this machine over the network as %s":                                           "Dem Scanner erlauben, sich über das Netzwerk als %s an diesem Rechner anzumelden",
+		"Let the scanner log in to this machine over SSH as %s":                                                   "Dem Scanner erlauben, sich per SSH als %s an diesem Rechner anzumelden",
+		"Route the scanner's traffic to %s through this machine":                                                  "Den Verkehr des Scanners zu %s über diesen Rechner leiten",
+		"Start the Windows Management Instrumentation service":                                                    "Den Dienst Windows-Verwaltungsinstrumentation starten",
+		"Set the Remote Registry service to start automatically":                                                  "Den Dienst Remoteregistrierung auf automatischen Start setzen",
+		"Start the Remote Registry service":                                                                       "Den Dienst Remoteregistrierung starten",
+		"Enable the File and Printer Sharing firewall rules":                                                      "Die Firewallregeln für Datei- und Druckerfreigabe aktivieren",
+		"Enable the Windows Management Instrumentation (WMI) firewall rules":                                      "Die Firewallregeln für Windows-Verwaltungsinstrumentation (WMI) aktivieren",
+		"Set LocalAccountTokenFilterPolicy to 1, which lets local administrator accounts log in over the network": "LocalAccountTokenFilterPolicy auf 1 setzen, damit sich lokale Administratorkonten über das Netzwerk anmelden können",
+		"The following changes will be made to this machine and undone when the scan ends:":                       "Folgende Änderungen werden an diesem Rechner vorgenommen und nach dem Scan rückgängig gemacht:",
+		"Do you accept these changes? y/n: ":                                                                      "Akzeptieren Sie diese Änderungen? j/n: ",
+		"Please answer y or n.":                                                                                   "Bitte mit j oder n antworten.",
+		"not connected":                                                                                           "nicht verbunden",
+		"connected":                                                                                               "verbunden",
+		"Tunnel: %s":                                                                                              "Tunnel: %s",
+		"Waiting for the scan to start...":                                                                        "Warten auf den Start des Scans...",
+		"Scan %d":                                                                                                 "Scan %d",
+		"Yes":                                                                                                     "Ja",
+		"No":                                                                                                      "Nein",
+		"↑/↓ choose · Enter confirm · Ctrl+C quit":                                                                "↑/↓ wählen · Enter bestätigen · Strg+C beenden",
+		"Changes to this machine":                                                                                 "Änderungen an diesem Rechner",
+		"Do you accept these changes?":                                                                            "Akzeptieren Sie diese Änderungen?",
+		"The scan results will be sent to this address.":                                                          "Die Scan-Ergebnisse werden an diese Adresse gesendet.",
+		"Email":                       "E-Mail",
+		"Email address":               "E-Mail-Adresse",
+		"Enter confirm · Ctrl+C quit": "Enter bestätigen · Strg+C beenden",
+		"Scan type":                   "Scan-Typ",
+		"A credentialed scan logs in to this machine and finds more issues,": "Ein Scan mit Anmeldedaten meldet sich an diesem Rechner an und findet mehr Probleme,",
+		"such as missing updates. It needs an account on this machine.":      "etwa fehlende Updates. Er benötigt ein Konto auf diesem Rechner.",
+		"Do you want to run a credentialed/full scan?":                       "Möchten Sie einen vollständigen Scan mit Anmeldedaten durchführen?",
+		"Show password": "Passwort anzeigen",
+		"Enter an account on this machine for the scanner to log in with.": "Geben Sie ein Konto auf diesem Rechner an, mit dem sich der Scanner anmeldet.",
+		"Username":    "Benutzername",
+		"Password":    "Passwort",
+		"Credentials": "Anmeldedaten",
+		"Tab next field · Space show/hide · Enter confirm · Ctrl+C quit": "Tab nächstes Feld · Leertaste ein-/ausblenden · Enter bestätigen · Strg+C beenden",
+		"Devices to scan": "Zu scannende Geräte",
+		"↑/↓ move · Space select · a all devices · Enter confirm · Ctrl+C quit": "↑/↓ bewegen · Leertaste auswählen · a alle Geräte · Enter bestätigen · Strg+C beenden",
+		"non-credentialed":    "ohne Anmeldedaten",
+		"credentialed, as %s": "mit Anmeldedaten, als %s",
+		"this machine":        "dieser Rechner",
+		"Policy":              "Richtlinie",
+		"Targets":             "Ziele",
+		"Summary":             "Zusammenfassung",
+		"Start the scan?":     "Scan starten?",
+		"Scan":                "Scan",
+		"Ctrl+C stop the scan and undo every change": "Strg+C Scan abbrechen und alle Änderungen rückgängig machen",
+		"Scan failed":                         "Scan fehlgeschlagen",
+		"Scan ID":                             "Scan-ID",
+		"Duration":                            "Dauer",
+		"Exit code":                           "Exit-Code",
+		"The full report will be sent to %s.": "Der vollständige Bericht wird an %s gesendet.",
+		"Some changes could not be undone, see the messages below.": "Einige Änderungen konnten nicht rückgängig gemacht werden, siehe die Meldungen unten.",
+		"Every change made to this machine has been undone.":        "Alle Änderungen an diesem Rechner wurden rückgängig gemacht.",
+		"Enter exit":                             "Enter beenden",
+		"Opening the scanner in your browser...": "Der Scanner wird in Ihrem Browser geöffnet...",
+		"If it does not open, go to %s\n":        "Falls er sich nicht öffnet, rufen Sie %s auf\n",
+		"This page can only be opened from the link the scanner opened.": "Diese Seite kann nur über den vom Scanner geöffneten Link aufgerufen werden.",
+		"Stopping the scan and undoing every change...":                  "Der Scan wird abgebrochen und alle Änderungen werden rückgängig gemacht...",
+		"This link has already been used.":                               "Dieser Link wurde bereits verwendet.",
+		"Enter both a username and a password.":                          "Geben Sie Benutzername und Passwort ein.",
+		"ETA unknown":                                                    "Restzeit unbekannt",
+		"ETA <1m":                                                        "Noch <1m",
+		"ETA %dm":                                                        "Noch %dm",
+		"ETA %dh%02dm":                                                   "Noch %dh%02dm",
+		"Enter the numbers or addresses to scan separated by commas, \"all\" for every device listed, or leave blank for none: ": "Nummern oder Adressen durch Kommas getrennt eingeben, \"all\" für alle aufgeführten Geräte, oder leer lassen für keine: ",
+	},
+}
+
+// yesNo are the answers accepted to a yes/no question in each language, on
+// top of the English ones.
+var yesNo = map[string]struct{ yes, no []string }{
+	"en": {[]string{"y", "yes"}, []string{"n", "no"}},
+	"es": {[]string{"s", "si", "sí"}, []string{"n", "no"}},
+	"fr": {[]string{"o", "oui"}, []string{"n", "non"}},
+	"de": {[]string{"j", "ja"}, []string{"n", "nein"}},
+}
+
+// tr returns the message in the current language, formatted with a when
+// arguments are given.
+func tr(message string, a ...interface{}) string {
+	if translated, ok := translations[language][message]; ok {
+		message = translated
+	}
+	if len(a) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, a...)
+}
+
+// parseYesNo reads an answer to a yes/no question. An empty answer is yes,
+// matching the Y/n prompts.
+func parseYesNo(input string) (answer bool, ok bool) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input == "" {
+		return true, true
+	}
+	for _, lang := range []string{language, "en"} {
+		for _, word := range yesNo[lang].yes {
+			if input == word {
+				return true, true
+			}
+		}
+		for _, word := range yesNo[lang].no {
+			if input == word {
+				return false, true
+			}
+		}
+	}
+	return false, false
+}
+
+// supportedLanguage returns the two-letter code of a locale such as
+// "de_DE.UTF-8" or "fr-CA" if there is a catalogue for it.
+func supportedLanguage(locale string) (string, bool) {
+	code := strings.ToLower(locale)
+	if i := strings.IndexAny(code, "_-.@"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := yesNo[code]; ok {
+		return code, true
+	}
+	return "", false
+}
+
+// detectLanguage picks the language from the POSIX locale variables, then
+// from the operating system's UI language, and falls back to English.
+func detectLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			if code, ok := supportedLanguage(value); ok {
+				return code
+			}
+			// A set variable that names an unsupported language wins over
+			// the variables after it.
+			return "en"
+		}
+	}
+	for _, locale := range systemLanguages() {
+		if code, ok := supportedLanguage(locale); ok {
+			return code
+		}
+	}
+	return "en"
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		lcAll, lang string
+		want        string
+	}{
+		{"", "de_DE.UTF-8", "de"},
+		{"", "fr_CA", "fr"},
+		{"es_MX.UTF-8", "de_DE.UTF-8", "es"},
+		{"", "C.UTF-8", "en"},
+		{"", "ja_JP.UTF-8", "en"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LC_ALL", tt.lcAll)
+		t.Setenv("LC_MESSAGES", "")
+		t.Setenv("LANG", tt.lang)
+		if got := detectLanguage(); got != tt.want {
+			t.Errorf("detectLanguage() with LC_ALL=%q LANG=%q = %q, want %q", tt.lcAll, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestParseYesNo(t *testing.T) {
+	defer func(old string) { language = old }(language)
+	tests := []struct {
+		lang, input string
+		answer, ok  bool
+	}{
+		{"en", "", true, true},
+		{"en", " Yes\n", true, true},
+		{"en", "n", false, true},
+		{"en", "oui", false, false},
+		{"es", "sí", true, true},
+		{"es", "S", true, true},
+		{"fr", "oui", true, true},
+		{"fr", "non", false, true},
+		{"fr", "yes", true, true},
+		{"de", "J", true, true},
+		{"de", "nein", false, true},
+		{"de", "si", false, false},
+	}
+	for _, tt := range tests {
+		language = tt.lang
+		answer, ok := parseYesNo(tt.input)
+		if answer != tt.answer || ok != tt.ok {
+			t.Errorf("parseYesNo(%q) in %s = %v, %v, want %v, %v", tt.input, tt.lang, answer, ok, tt.answer, tt.ok)
+		}
+	}
+}
+
+// TestTranslationsMatchFormat checks that every translation takes the same
+// arguments as the English message.
+func TestTranslationsMatchFormat(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+	for lang, catalogue := range translations {
+		for english, translated := range catalogue {
+			want, got := verbs.FindAllString(english, -1), verbs.FindAllString(translated, -1)
+			if len(want) != len(got) {
+				t.Errorf("%s: %q has verbs %v, want %v", lang, translated, got, want)
+				continue
+			}
+			for i := range want {
+				if want[i] != got[i] {
+					t.Errorf("%s: %q has verbs %v, want %v", lang, translated, got, want)
+					break
+				}
+			}
+		}
+	}
+
+	defer func(old string) { language = old }(language)
+	language = "de"
+	if got := tr("Scan started successfully with Scan ID: %d\n", 7); got != "Scan erfolgreich gestartet, Scan-ID: 7\n" {
+		t.Errorf("tr() = %q", got)
+	}
+	if got := tr("Not in the catalogue"); got != "Not in the catalogue" {
+		t.Errorf("tr() of an unknown message = %q", got)
+	}
+}
+
+// TestCataloguesComplete checks that every message passed to tr() as a
+// literal, and every Windows setup description, is in every catalogue.
+func TestCataloguesComplete(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string]string{}
+	for _, d := range windowsSetupDescriptions {
+		messages[d] = "windowsSetupDescriptions"
+	}
+	// The phases are translated where they are displayed.
+	for _, phase := range []string{phaseDiscovery, phasePortScan, phasePlugins, phaseReport} {
+		messages[phase] = "scan phases"
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.Ident); !ok || fn.Name != "tr" {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				message, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				messages[message] = fset.Position(lit.Pos()).String()
+			}
+			return true
+		})
+	}
+	if len(messages) < 50 {
+		t.Fatalf("found only %d messages", len(messages))
+	}
+	for lang, catalogue := range translations {
+		for message, pos := range messages {
+			if _, ok := catalogue[message]; !ok {
+				t.Errorf("%s: %q (%s) is not translated", lang, message, pos)
+			}
+		}
+	}
+}
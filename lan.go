@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localSubnet is an IPv4 network attached to one of this machine's interfaces.
+type localSubnet struct {
+	Interface string
+	Address   net.IP
+	Network   *net.IPNet
+}
+
+// lanNeighbour is a host seen in the ARP/neighbour table.
+type lanNeighbour struct {
+	IP     net.IP
+	Subnet *localSubnet
+}
+
+// RoutesRequest asks the backend to route the given networks through this
+// machine's tunnel peer so the scanner can reach them.
+type RoutesRequest struct {
+	Hostname string   `json:"hostname"`
+	Networks []string `json:"networks"`
+}
+
+type RoutesResponse struct {
+	RouteID string `json:"route_id"`
+}
+
+// advertisedRoute is the ID of the routes added for this run, withdrawn by
+// withdrawRoutes.
+var advertisedRoute string
+
+// discoverSubnets lists the private IPv4 networks of every interface that is
+// up, ignoring loopback and the tunnel itself.
+func discoverSubnets() ([]localSubnet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("error listing network interfaces: %v", err)
+	}
+
+	var subnets []localSubnet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || strings.HasPrefix(iface.Name, "wt") {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			debugPrint("Error reading addresses of %s: %v\n", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || !ipNet.IP.IsPrivate() {
+				continue
+			}
+			network := &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+			subnets = append(subnets, localSubnet{Interface: iface.Name, Address: ipNet.IP.To4(), Network: network})
+		}
+	}
+	return subnets, nil
+}
+
+var ipv4Regex = regexp.MustCompile(`\b(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})\b`)
+
+// readNeighbourTable returns the IPv4 addresses in the ARP/neighbour table.
+func readNeighbourTable() ([]net.IP, error) {
+	var output []byte
+	var err error
+	if runtime.GOOS == "linux" {
+		output, err = ioutil.ReadFile("/proc/net/arp")
+	} else {
+		output, err = runner.Run(context.Background(), "arp", "-a")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading neighbour table: %v", err)
+	}
+	return parseNeighbourTable(output), nil
+}
+
+// parseNeighbourTable extracts the first IPv4 address of every line of
+// /proc/net/arp or `arp -a` output, skipping incomplete entries.
+func parseNeighbourTable(output []byte) []net.IP {
+	var ips []net.IP
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lower := strings.ToLower(line)
+		if strings.Contains(lower, "incomplete") || strings.Contains(line, " 0x0 ") {
+			continue
+		}
+		match := ipv4Regex.FindString(line)
+		if match == "" || strings.HasPrefix(strings.TrimSpace(lower), "interface") {
+			continue
+		}
+		if ip := net.ParseIP(match).To4(); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// discoverNeighbours returns the hosts of the neighbour table that are on one
+// of the local subnets, excluding this machine and broadcast addresses.
+func discoverNeighbours(subnets []localSubnet, table []net.IP) []lanNeighbour {
+	seen := map[string]bool{}
+	var neighbours []lanNeighbour
+	for _, ip := range table {
+		if ip.IsMulticast() || seen[ip.String()] {
+			continue
+		}
+		for i := range subnets {
+			s := &subnets[i]
+			if !s.Network.Contains(ip) || ip.Equal(s.Address) || ip.Equal(broadcastAddress(s.Network)) {
+				continue
+			}
+			seen[ip.String()] = true
+			neighbours = append(neighbours, lanNeighbour{IP: ip, Subnet: s})
+			break
+		}
+	}
+	sort.Slice(neighbours, func(i, j int) bool {
+		return bytes.Compare(neighbours[i].IP, neighbours[j].IP) < 0
+	})
+	return neighbours
+}
+
+func broadcastAddress(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^n.Mask[i]
+	}
+	return broadcast
+}
+
+// chooseLANTargets shows the discovered hosts and subnets and asks which of
+// them to scan. Targets outside the local subnets are refused.
+func chooseLANTargets(ctx context.Context, subnets []localSubnet, neighbours []lanNeighbour) ([]string, error) {
+	if len(subnets) == 0 {
+		fmt.Println(tr("No local networks found, only this machine will be scanned."))
+		return nil, nil
+	}
+
+	fmt.Println(tr("Other devices on your network that can be scanned:"))
+	var choices []string
+	for i, c := range lanChoices(subnets, neighbours) {
+		choices = append(choices, c.Target)
+		fmt.Printf("  %d) %s\n", i+1, c.Label)
+	}
+
+	for {
+		fmt.Print(tr("Enter the numbers or addresses to scan separated by commas, \"all\" for every device listed, or leave blank for none: "))
+		input, err := readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := parseLANTargets(strings.TrimSpace(input), choices, neighbours, subnets)
+		if err == nil {
+			return targets, nil
+		}
+		fmt.Println(err)
+	}
+}
+
+// lanChoice is one entry of the list of devices that can be scanned.
+type lanChoice struct {
+	Target string `json:"target"`
+	Label  string `json:"label"`
+}
+
+// lanChoices lists the neighbours first, then every local network.
+func lanChoices(subnets []localSubnet, neighbours []lanNeighbour) []lanChoice {
+	var choices []lanChoice
+	for _, n := range neighbours {
+		choices = append(choices, lanChoice{n.IP.String(), fmt.Sprintf("%s (%s)", n.IP, n.Subnet.Interface)})
+	}
+	for _, s := range subnets {
+		choices = append(choices, lanChoice{s.Network.String(), tr("every host on %s (%s)", s.Network, s.Interface)})
+	}
+	return choices
+}
+
+func parseLANTargets(input string, choices []string, neighbours []lanNeighbour, subnets []localSubnet) ([]string, error) {
+	if input == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(input, "all") {
+		var targets []string
+		for _, n := range neighbours {
+			targets = append(targets, n.IP.String())
+		}
+		return targets, nil
+	}
+
+	var targets []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(input, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		target := field
+		if n, err := strconv.Atoi(field); err == nil {
+			if n < 1 || n > len(choices) {
+				return nil, fmt.Errorf("invalid choice %d", n)
+			}
+			target = choices[n-1]
+		} else if !onLocalSubnet(field, subnets) {
+			return nil, fmt.Errorf("%s is not on one of your local networks", field)
+		}
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// onLocalSubnet reports whether target, an IP address or CIDR network, lies
+// entirely within one of the local subnets.
+func onLocalSubnet(target string, subnets []localSubnet) bool {
+	ip := net.ParseIP(target)
+	var network *net.IPNet
+	if ip == nil {
+		var err error
+		ip, network, err = net.ParseCIDR(target)
+		if err != nil {
+			return false
+		}
+	}
+	for _, s := range subnets {
+		if !s.Network.Contains(ip) {
+			continue
+		}
+		if network == nil {
+			return true
+		}
+		inner, _ := network.Mask.Size()
+		outer, _ := s.Network.Mask.Size()
+		if inner >= outer {
+			return true
+		}
+	}
+	return false
+}
+
+// routeNetworks returns the local subnets that contain the targets, which
+// are the routes the tunnel has to carry.
+func routeNetworks(targets []string, subnets []localSubnet) []string {
+	seen := map[string]bool{}
+	var networks []string
+	for _, target := range targets {
+		ip := net.ParseIP(target)
+		if ip == nil {
+			ip, _, _ = net.ParseCIDR(target)
+		}
+		for _, s := range subnets {
+			if ip != nil && s.Network.Contains(ip) && !seen[s.Network.String()] {
+				seen[s.Network.String()] = true
+				networks = append(networks, s.Network.String())
+			}
+		}
+	}
+	return networks
+}
+
+// advertiseRoutes asks the backend to route the networks through this peer.
+func advertiseRoutes(ctx context.Context, networks []string) error {
+	if len(networks) == 0 {
+		return nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting hostname: %v", err)
+	}
+
+	fmt.Print(tr("Routing %s through the tunnel...\n", strings.Join(networks, ", ")))
+	reqBody := RoutesRequest{Hostname: hostname, Networks: networks}
+	// The same key is sent with every retry so the routes are added once.
+	headers := map[string]string{"Idempotency-Key": newIdempotencyKey()}
+	var resp RoutesResponse
+	err = apiRequest(ctx, http.MethodPost, "add_routes", reqBody, &resp, headers, mutatePolicy)
+	if err != nil {
+		return fmt.Errorf("error adding routes: %v", err)
+	}
+	advertisedRoute = resp.RouteID
+	return nil
+}
+
+// withdrawRoutes removes the routes added by advertiseRoutes, if any.
+func withdrawRoutes(ctx context.Context) error {
+	if advertisedRoute == "" {
+		return nil
+	}
+	fmt.Println(tr("Removing network routes..."))
+	err := apiRequest(ctx, http.MethodDelete, "delete_routes/"+advertisedRoute, nil, nil, nil, mutatePolicy)
+	if err != nil {
+		return fmt.Errorf("error removing routes: %v", err)
+	}
+	advertisedRoute = ""
+	return nil
+}
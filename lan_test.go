@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testSubnets(t *testing.T) []localSubnet {
+	t.Helper()
+	var subnets []localSubnet
+	for _, s := range []struct{ iface, cidr string }{{"eth0", "192.168.1.10/24"}, {"eth1", "10.0.8.5/22"}} {
+		ip, network, err := net.ParseCIDR(s.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subnets = append(subnets, localSubnet{Interface: s.iface, Address: ip.To4(), Network: network})
+	}
+	return subnets
+}
+
+func TestParseNeighbourTable(t *testing.T) {
+	tests := map[string]struct {
+		output string
+		want   string
+	}{
+		"linux": {
+			output: `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         a0:b1:c2:d3:e4:f5     *        eth0
+192.168.1.23     0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.40     0x1         0x2         10:20:30:40:50:60     *        eth0
+`,
+			want: "192.168.1.1 192.168.1.40",
+		},
+		"macos": {
+			output: `? (192.168.1.1) at a0:b1:c2:d3:e4:f5 on en0 ifscope [ethernet]
+? (192.168.1.23) at (incomplete) on en0 ifscope [ethernet]
+? (192.168.1.255) at ff:ff:ff:ff:ff:ff on en0 ifscope [ethernet]
+`,
+			want: "192.168.1.1 192.168.1.255",
+		},
+		"windows": {
+			output: `
+Interface: 192.168.1.10 --- 0x4
+  Internet Address      Physical Address      Type
+  192.168.1.1           a0-b1-c2-d3-e4-f5     dynamic
+  224.0.0.22            01-00-5e-00-00-16     static
+`,
+			want: "192.168.1.1 224.0.0.22",
+		},
+	}
+	for name, tt := range tests {
+		var got []string
+		for _, ip := range parseNeighbourTable([]byte(tt.output)) {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%s: parseNeighbourTable() = %v, want %s", name, got, tt.want)
+		}
+	}
+}
+
+func TestOnLocalSubnet(t *testing.T) {
+	subnets := testSubnets(t)
+	tests := map[string]bool{
+		"192.168.1.20":    true,
+		"10.0.11.200":     true,
+		"192.168.2.20":    false,
+		"192.168.1.0/24":  true,
+		"192.168.1.64/26": true,
+		"192.168.0.0/16":  false,
+		"10.0.8.0/21":     false,
+		"printer":         false,
+	}
+	for target, want := range tests {
+		if got := onLocalSubnet(target, subnets); got != want {
+			t.Errorf("onLocalSubnet(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestParseLANTargets(t *testing.T) {
+	subnets := testSubnets(t)
+	neighbours := discoverNeighbours(subnets, []net.IP{net.ParseIP("192.168.1.30").To4(), net.ParseIP("10.0.9.1").To4(), net.ParseIP("192.168.1.10").To4()})
+	var choices []string
+	for _, c := range lanChoices(subnets, neighbours) {
+		choices = append(choices, c.Target)
+	}
+	if strings.Join(choices, " ") != "10.0.9.1 192.168.1.30 192.168.1.0/24 10.0.8.0/22" {
+		t.Fatalf("choices = %v", choices)
+	}
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"", "", ""},
+		{"all", "10.0.9.1 192.168.1.30", ""},
+		{"2, 3", "192.168.1.30 192.168.1.0/24", ""},
+		{"1,10.0.9.1,192.168.1.77", "10.0.9.1 192.168.1.77", ""},
+		{"5", "", "invalid choice 5"},
+		{"8.8.8.8", "", "8.8.8.8 is not on one of your local networks"},
+	}
+	for _, tt := range tests {
+		targets, err := parseLANTargets(tt.input, choices, neighbours, subnets)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseLANTargets(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(targets, " ") != tt.want {
+			t.Errorf("parseLANTargets(%q) = %v, %v, want %s", tt.input, targets, err, tt.want)
+		}
+	}
+}
+
+func TestRouteNetworks(t *testing.T) {
+	subnets := testSubnets(t)
+	got := routeNetworks([]string{"192.168.1.30", "192.168.1.0/24", "10.0.9.1", "172.16.0.1"}, subnets)
+	if strings.Join(got, " ") != "192.168.1.0/24 10.0.8.0/22" {
+		t.Errorf("routeNetworks() = %v", got)
+	}
+	if got := routeNetworks(nil, subnets); len(got) != 0 {
+		t.Errorf("routeNetworks(nil) = %v", got)
+	}
+}
+
+func TestWithdrawRoutes(t *testing.T) {
+	defer func() { advertisedRoute = "" }()
+	var requests []string
+	fail := false
+	useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPost && r.Header.Get("Idempotency-Key") == "" {
+			t.Error("add_routes was sent without an Idempotency-Key")
+		}
+		if fail {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"route_id": "route-3"}`))
+	}))
+
+	if err := advertiseRoutes(context.Background(), []string{"192.168.1.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	// A failed withdrawal is returned so the run reports the routes left.
+	fail = true
+	if err := withdrawRoutes(context.Background()); err == nil {
+		t.Error("withdrawRoutes() hid the failure")
+	}
+	fail = false
+	if err := withdrawRoutes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := withdrawRoutes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "POST /add_routes,DELETE /delete_routes/route-3,DELETE /delete_routes/route-3"
+	if strings.Join(requests, ",") != want {
+		t.Errorf("requests = %v, want %s", requests, want)
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	if err := checkRoutes(smbdefenceBackend{}); err != nil {
+		t.Errorf("checkRoutes(smbdefence) = %v", err)
+	}
+	// A scanner driven directly is on its own tunnel network, whose routes
+	// the client does not manage.
+	for _, b := range []scanBackend{&nessusBackend{}, &gmpBackend{}} {
+		if err := checkRoutes(b); err == nil {
+			t.Errorf("checkRoutes(%T) accepted LAN targets", b)
+		}
+	}
+}
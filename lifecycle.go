@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// step is one stage of a run. Steps are executed in order under the root
+// context and stop at the first error or cancellation.
+type step struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// undoAction reverts a change made by a step. It runs at most once, either
+// when the run unwinds or earlier when the flow calls lifecycle.undoNow.
+type undoAction struct {
+	name string
+	fn   func(ctx context.Context) error
+	once sync.Once
+	err  error
+}
+
+func (u *undoAction) do(ctx context.Context) error {
+	u.once.Do(func() {
+		debugPrint("Undo: %s\n", u.name)
+		u.err = u.fn(ctx)
+		audit("undo", u.name, u.err)
+	})
+	return u.err
+}
+
+// lifecycle tracks the undo actions registered by the steps of a run.
+type lifecycle struct {
+	mu    sync.Mutex
+	undos []*undoAction
+}
+
+// onUndo registers fn to revert a change. Register it before making the
+// change so a step interrupted half way is still reverted.
+func (l *lifecycle) onUndo(name string, fn func(ctx context.Context) error) *undoAction {
+	u := &undoAction{name: name, fn: fn}
+	l.mu.Lock()
+	l.undos = append(l.undos, u)
+	l.mu.Unlock()
+	return u
+}
+
+// undoNow runs a single undo action ahead of the unwind, for changes that
+// should be reverted as soon as they are no longer needed. A failure leaves
+// the machine changed, so it ends the run with exitRestore.
+func (l *lifecycle) undoNow(u *undoAction) error {
+	return withExitCode(exitRestore, u.do(context.Background()))
+}
+
+// run executes the steps in order and returns the first error. A cancelled
+// context is reported as such, whichever step noticed it.
+func (l *lifecycle) run(ctx context.Context, steps []step) error {
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		debugPrint("Step: %s\n", s.name)
+		if err := s.run(ctx); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// unwind runs every undo action not yet run, most recent first. Undo actions
+// get a fresh context so they are not cut short by the cancelled run. It
+// returns the errors of the undo actions that failed.
+func (l *lifecycle) unwind() []error {
+	l.mu.Lock()
+	undos := l.undos
+	l.undos = nil
+	l.mu.Unlock()
+
+	var failed []error
+	for i := len(undos) - 1; i >= 0; i-- {
+		if err := undos[i].do(context.Background()); err != nil {
+			fmt.Printf("Error during %s: %v\n", undos[i].name, err)
+			failed = append(failed, fmt.Errorf("%s: %w", undos[i].name, err))
+		}
+	}
+	return failed
+}
+
+// Test seams for the signals handleInterrupts waits for and the exit it
+// forces.
+var (
+	notifyInterrupts = func(c chan<- os.Signal) { signal.Notify(c, os.Interrupt, syscall.SIGTERM) }
+	exitProcess      = os.Exit
+)
+
+// handleInterrupts cancels the run on the first Ctrl+C (SIGINT) or SIGTERM so
+// the lifecycle can unwind, and forces the process to exit on the second.
+func handleInterrupts(cancel context.CancelFunc) {
+	signalChan := make(chan os.Signal, 2)
+	notifyInterrupts(signalChan)
+	go func() {
+		<-signalChan
+		fmt.Println("\nReceived an interrupt, restoring settings and exiting...")
+		fmt.Println("Press Ctrl+C again to exit immediately without restoring.")
+		cancel()
+		<-signalChan
+		fmt.Println("\nExiting without restoring settings.")
+		exitProcess(1)
+	}()
+}
+
+// sleepCtx waits for d or until ctx is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
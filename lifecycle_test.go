@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnwindOrder(t *testing.T) {
+	var l lifecycle
+	var ran []string
+	for _, name := range []string{"restore settings", "uninstall tunnel", "delete scan"} {
+		name := name
+		l.onUndo(name, func(ctx context.Context) error {
+			ran = append(ran, name)
+			if name == "uninstall tunnel" {
+				return errors.New("still running")
+			}
+			return nil
+		})
+	}
+
+	// A failure does not stop the undo actions registered before it.
+	failed := l.unwind()
+	if want := []string{"delete scan", "uninstall tunnel", "restore settings"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("undo order = %q, want %q", ran, want)
+	}
+	if len(failed) != 1 || exitCode(nil, len(failed)) != exitRestore {
+		t.Errorf("unwind() = %v, want the tunnel failure", failed)
+	}
+	if failed := l.unwind(); len(failed) != 0 || len(ran) != 3 {
+		t.Errorf("second unwind() = %v, ran %q", failed, ran)
+	}
+}
+
+func TestUndoNowRunsOnce(t *testing.T) {
+	var l lifecycle
+	runs := map[string]int{}
+	undo := func(name string, err error) *undoAction {
+		return l.onUndo(name, func(ctx context.Context) error {
+			runs[name]++
+			return err
+		})
+	}
+	undo("restore settings", nil)
+	tunnel := undo("uninstall tunnel", nil)
+	routes := undo("withdraw routes", errors.New("timeout"))
+
+	if err := l.undoNow(tunnel); err != nil {
+		t.Fatal(err)
+	}
+	// A failed early undo ends the run as a failed restore.
+	if err := l.undoNow(routes); exitCode(err, 0) != exitRestore {
+		t.Errorf("undoNow() = %v, want exit code %d", err, exitRestore)
+	}
+	failed := l.unwind()
+	if want := map[string]int{"restore settings": 1, "uninstall tunnel": 1, "withdraw routes": 1}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("runs = %v, want %v", runs, want)
+	}
+	// The failure of an action already run is reported again, not retried.
+	if len(failed) != 1 {
+		t.Errorf("unwind() = %v", failed)
+	}
+}
+
+func TestSecondInterruptExits(t *testing.T) {
+	oldNotify, oldExit := notifyInterrupts, exitProcess
+	t.Cleanup(func() { notifyInterrupts, exitProcess = oldNotify, oldExit })
+	signals := make(chan chan<- os.Signal, 1)
+	notifyInterrupts = func(c chan<- os.Signal) { signals <- c }
+	exited := make(chan int, 1)
+	exitProcess = func(code int) { exited <- code }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
+	c := <-signals
+
+	c <- os.Interrupt
+	<-ctx.Done()
+	select {
+	case code := <-exited:
+		t.Fatalf("exited with %d on the first interrupt", code)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The second interrupt arrives while the run is still cleaning up.
+	c <- os.Interrupt
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the second interrupt did not exit")
+	}
+}
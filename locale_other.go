@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// systemLanguages has nothing to add to the locale variables outside
+// Windows.
+func systemLanguages() []string {
+	return nil
+}
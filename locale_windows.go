@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// systemLanguages returns the user's preferred UI languages, such as "de-DE".
+func systemLanguages() []string {
+	languages, err := windows.GetUserPreferredUILanguages(windows.MUI_LANGUAGE_NAME)
+	if err != nil {
+		debugPrint("Error reading UI languages: %v\n", err)
+		return nil
+	}
+	return languages
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nessusBackend drives a Nessus scanner directly through its REST API. The
+// scanner has to be on the same tunnel network as this machine, which it
+// scans at its tunnel address.
+type nessusBackend struct {
+	api       apiServer
+	templates []string // template names offered, all when empty
+	reportDir string
+
+	mu    sync.Mutex
+	uuids map[string]string // template UUIDs by name
+}
+
+func newNessusBackend(cfg BackendConfig) (*nessusBackend, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("the nessus backend needs the scanner's url")
+	}
+	accessKey, secretKey := cfg.AccessKey, cfg.SecretKey
+	if accessKey == "" {
+		accessKey = os.Getenv("NESSUS_ACCESS_KEY")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("NESSUS_SECRET_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("the nessus backend needs an API access key and secret key")
+	}
+	client, err := scannerClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &nessusBackend{
+		api: apiServer{
+			base:           strings.TrimSuffix(cfg.URL, "/") + "/",
+			client:         client,
+			headers:        map[string]string{"X-ApiKeys": fmt.Sprintf("accessKey=%s; secretKey=%s", accessKey, secretKey)},
+			dryRunResponse: nessusDryRunResponse,
+		},
+		templates: cfg.Templates,
+		reportDir: cfg.ReportDir,
+		uuids:     map[string]string{},
+	}, nil
+}
+
+func (n *nessusBackend) Online(ctx context.Context) bool {
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := n.api.request(ctx, http.MethodGet, "server/status", nil, &resp, nil, statusPolicy); err != nil {
+		debugPrint("Error fetching scanner status: %v\n", err)
+		return false
+	}
+	return resp.Status == "ready"
+}
+
+type nessusTemplate struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"desc"`
+	Agent       bool   `json:"is_agent"`
+	Unsupported bool   `json:"unsupported"`
+}
+
+// Policies offers the scanner's scan templates that the licence allows and
+// the config does not leave out. Agent scans cannot reach this machine
+// through the tunnel and are skipped.
+func (n *nessusBackend) Policies(ctx context.Context) []ScanPolicy {
+	var resp struct {
+		Templates []nessusTemplate `json:"templates"`
+	}
+	err := n.api.request(ctx, http.MethodGet, "editor/scan/templates", nil, &resp, nil, statusPolicy)
+	if err != nil {
+		debugPrint("Using default scan policies: %v\n", err)
+		return defaultPolicies
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var policies []ScanPolicy
+	for _, t := range resp.Templates {
+		if t.Agent || t.Unsupported || (len(n.templates) > 0 && !contains(n.templates, t.Name)) {
+			continue
+		}
+		n.uuids[t.Name] = t.UUID
+		// The defaults know which templates need credentials.
+		policy, ok := findPolicy(defaultPolicies, t.Name)
+		if !ok {
+			policy = ScanPolicy{CredentialsSupported: true}
+		}
+		policy.ID, policy.Name, policy.Description = t.Name, t.Title, t.Description
+		policies = append(policies, policy)
+	}
+	if len(policies) == 0 {
+		debugPrint("The scanner offers none of the configured templates\n")
+		return defaultPolicies
+	}
+	return policies
+}
+
+func (n *nessusBackend) templateUUID(ctx context.Context, name string) (string, error) {
+	n.mu.Lock()
+	uuid, ok := n.uuids[name]
+	n.mu.Unlock()
+	if !ok {
+		n.Policies(ctx)
+		n.mu.Lock()
+		uuid, ok = n.uuids[name]
+		n.mu.Unlock()
+	}
+	if !ok {
+		return "", fmt.Errorf("the scanner has no %q scan template", name)
+	}
+	return uuid, nil
+}
+
+// nessusCredentials logs in to this machine: with the NTLM hash on Windows
+// and over SSH elsewhere.
+func nessusCredentials(goos, username, password string) map[string]interface{} {
+	var host map[string]interface{}
+	if goos == "windows" {
+		host = map[string]interface{}{"Windows": []map[string]string{{"auth_method": "NTLM Hash", "username": username, "hash": password}}}
+	} else {
+		host = map[string]interface{}{"SSH": []map[string]string{{"auth_method": "password", "username": username, "password": password, "elevate_privileges_with": "Nothing"}}}
+	}
+	return map[string]interface{}{"add": map[string]interface{}{"Host": host}}
+}
+
+// Start creates a scan of this machine's tunnel address and the targets
+// from the template named by the policy, and launches it. The scanner
+// emails its own notification to the address given.
+func (n *nessusBackend) Start(ctx context.Context, req scanRequest) (int, error) {
+	uuid, err := n.templateUUID(ctx, req.Policy)
+	if err != nil {
+		return 0, err
+	}
+	host, err := tunnelAddress(ctx)
+	if err != nil {
+		return 0, err
+	}
+	hostname, _ := os.Hostname()
+	// The name is unique to this run so that the scan can be found again.
+	name := fmt.Sprintf("Nessus Client scan of %s (%s)", hostname, newIdempotencyKey())
+	body := map[string]interface{}{
+		"uuid": uuid,
+		"settings": map[string]interface{}{
+			"name":         name,
+			"text_targets": strings.Join(append([]string{host}, req.Targets...), ","),
+			"emails":       req.Email,
+		},
+	}
+	if req.Username != "" {
+		body["credentials"] = nessusCredentials(runtime.GOOS, req.Username, req.Password)
+	}
+
+	var resp struct {
+		Scan struct {
+			ID int `json:"id"`
+		} `json:"scan"`
+	}
+	// Nessus ignores Idempotency-Key, so the scan is created once and, if
+	// the reply is lost, looked up by its name rather than created again.
+	if err := n.api.request(ctx, http.MethodPost, "scans", body, &resp, nil, createPolicy); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && !retryable(apiErr.StatusCode) {
+			return 0, fmt.Errorf("error creating scan: %v", err)
+		}
+		id, findErr := n.findScan(ctx, name)
+		if findErr != nil || id == 0 {
+			return 0, fmt.Errorf("error creating scan: %v", err)
+		}
+		debugPrint("Creating the scan failed, but scan %d was created: %v\n", id, err)
+		resp.Scan.ID = id
+	}
+	if err := n.api.request(ctx, http.MethodPost, fmt.Sprintf("scans/%d/launch", resp.Scan.ID), nil, nil, nil, mutatePolicy); err != nil {
+		return resp.Scan.ID, fmt.Errorf("error launching scan: %v", err)
+	}
+	return resp.Scan.ID, nil
+}
+
+// findScan returns the ID of the scan named name, or 0 if there is none.
+func (n *nessusBackend) findScan(ctx context.Context, name string) (int, error) {
+	var resp struct {
+		Scans []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"scans"`
+	}
+	if err := n.api.request(ctx, http.MethodGet, "scans", nil, &resp, nil, pollPolicy); err != nil {
+		return 0, err
+	}
+	for _, scan := range resp.Scans {
+		if scan.Name == name {
+			return scan.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// Status reads the scan details. Like the other backends it sums every
+// host the scan targets, LAN devices included.
+func (n *nessusBackend) Status(ctx context.Context, scanID int) (scanProgress, error) {
+	var status ScanStatusResponse
+	if err := n.api.request(ctx, http.MethodGet, fmt.Sprintf("scans/%d", scanID), nil, &status, nil, pollPolicy); err != nil {
+		return scanProgress{}, err
+	}
+	return status.progress(), nil
+}
+
+func (n *nessusBackend) Resume(ctx context.Context, scanID int) error {
+	return n.api.request(ctx, http.MethodPost, fmt.Sprintf("scans/%d/resume", scanID), nil, nil, nil, mutatePolicy)
+}
+
+// Updates returns no updates: Nessus does not push them.
+func (n *nessusBackend) Updates(ctx context.Context, scanID int) <-chan scanProgress {
+	return noUpdates()
+}
+
+func (n *nessusBackend) Delete(ctx context.Context, scanID int) error {
+	fmt.Println(tr("Deleting scan..."))
+	progress, err := n.Status(ctx, scanID)
+	if err != nil {
+		return fmt.Errorf("Error getting scan status: %v", err)
+	}
+	if progress.State.active() {
+		err = n.api.request(ctx, http.MethodPost, fmt.Sprintf("scans/%d/stop", scanID), nil, nil, nil, mutatePolicy)
+		if err != nil {
+			return fmt.Errorf("Error stopping the scan: %v", err)
+		}
+		if err := sleepCtx(ctx, scanStopDelay); err != nil {
+			return err
+		}
+	}
+	err = n.api.request(ctx, http.MethodDelete, fmt.Sprintf("scans/%d", scanID), nil, nil, nil, mutatePolicy)
+	if err != nil {
+		return fmt.Errorf("Error deleting the scan: %v", err)
+	}
+	return nil
+}
+
+// nessusExportRequest is the body of POST /scans/{id}/export.
+func nessusExportRequest(format string, req ExportRequest) map[string]interface{} {
+	body := map[string]interface{}{"format": format}
+	if format == "pdf" || format == "html" {
+		chapters := req.Chapters
+		if len(chapters) == 0 {
+			chapters = []string{"vuln_hosts_summary", "vuln_by_host", "vuln_by_plugin", "remediations"}
+		}
+		body["chapters"] = strings.Join(chapters, ";")
+	}
+	if req.MinSeverity > 0 {
+		body["filter.0.filter"] = "severity"
+		body["filter.0.quality"] = "gt"
+		body["filter.0.value"] = req.MinSeverity - 1
+		body["filter.search_type"] = "and"
+	}
+	return body
+}
+
+// download exports the scan in one format, waits for the scanner to
+// prepare the file and downloads it.
+func (n *nessusBackend) download(ctx context.Context, scanID int, body map[string]interface{}) ([]byte, error) {
+	var file struct {
+		File int `json:"file"`
+	}
+	if err := n.api.request(ctx, http.MethodPost, fmt.Sprintf("scans/%d/export", scanID), body, &file, nil, mutatePolicy); err != nil {
+		return nil, fmt.Errorf("error exporting report: %v", err)
+	}
+	deadline := time.Now().Add(exportReadyTimeout)
+	for {
+		var status struct {
+			Status string `json:"status"`
+		}
+		err := n.api.request(ctx, http.MethodGet, fmt.Sprintf("scans/%d/export/%d/status", scanID, file.File), nil, &status, nil, pollPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("error getting export status: %v", err)
+		}
+		if status.Status == "ready" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("report was not ready for export after %s", exportReadyTimeout)
+		}
+		if err := sleepCtx(ctx, scanPollFast); err != nil {
+			return nil, err
+		}
+	}
+	var data []byte
+	if err := n.api.request(ctx, http.MethodGet, fmt.Sprintf("scans/%d/export/%d/download", scanID, file.File), nil, &data, nil, mutatePolicy); err != nil {
+		return nil, fmt.Errorf("error downloading report: %v", err)
+	}
+	return data, nil
+}
+
+// Export saves the report in every format asked for to the report
+// directory. The scanner emails its own notification when it has a mail
+// server set up, so the recipients are not used.
+func (n *nessusBackend) Export(ctx context.Context, scanID int, email string, options ExportOptions) error {
+	req, err := options.exportRequest(scanID, email)
+	if err != nil {
+		return err
+	}
+	for _, format := range req.Formats {
+		data, err := n.download(ctx, scanID, nessusExportRequest(format, req))
+		if err != nil {
+			return err
+		}
+		if err := saveReport(n.reportDir, scanID, format, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *nessusBackend) Findings(ctx context.Context, scanID int) ([]HostFindings, error) {
+	data, err := n.download(ctx, scanID, map[string]interface{}{"format": "nessus"})
+	if err != nil {
+		return nil, err
+	}
+	return parseNessusExport(data, scanID, time.Now())
+}
+
+// nessusDryRunResponse answers like a scanner where the scan completes at
+// once and every export is ready.
+func nessusDryRunResponse(method, path string) (string, bool) {
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "server/status":
+		return `{"status": "ready"}`, true
+	case path == "editor/scan/templates":
+		var templates []nessusTemplate
+		for _, p := range defaultPolicies {
+			templates = append(templates, nessusTemplate{UUID: "template-" + p.ID, Name: p.ID, Title: p.Name, Description: p.Description})
+		}
+		data, _ := json.Marshal(map[string]interface{}{"templates": templates})
+		return string(data), true
+	case method == http.MethodPost && path == "scans":
+		return `{"scan": {"id": 1}}`, true
+	case method == http.MethodGet && len(parts) == 2:
+		return `{"info": {"status": "completed"}}`, true
+	case strings.HasSuffix(path, "/export"):
+		return `{"file": 1}`, true
+	case strings.HasSuffix(path, "/status"):
+		return `{"status": "ready"}`, true
+	case strings.HasSuffix(path, "/download"):
+		return `<NessusClientData_v2></NessusClientData_v2>`, true
+	}
+	return "", false
+}
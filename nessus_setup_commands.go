@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const tokenFilterPolicyKey = `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Policies\system`
+
+// tokenFilterPolicyAbsent is saved when LocalAccountTokenFilterPolicy is not
+// set, as on a fresh Windows, so the restore deletes the value again.
+const tokenFilterPolicyAbsent = "absent"
+
+// windowsSetupCommands enable the services and firewall rules a credentialed
+// Nessus scan of Windows needs.
+func windowsSetupCommands() [][]string {
+	return [][]string{
+		{"net", "start", "Winmgmt"},
+		{"sc", "config", "RemoteRegistry", "start=", "auto"},
+		{"net", "start", "RemoteRegistry"},
+		{"netsh", "advfirewall", "firewall", "set", "rule", "group=File and Printer Sharing", "new", "enable=yes"},
+		{"netsh", "advfirewall", "firewall", "set", "rule", "group=Windows Management Instrumentation (WMI)", "new", "enable=yes"},
+		{"reg", "add", tokenFilterPolicyKey, "/v", "LocalAccountTokenFilterPolicy", "/t", "REG_DWORD", "/d", "1", "/f"},
+	}
+}
+
+// windowsRestoreCommands put back the settings saved before the scan.
+func windowsRestoreCommands(settings CurrentSettings) [][]string {
+	return [][]string{
+		{"net", settings.WmiStatus, "Winmgmt"},
+		{"sc", "config", "RemoteRegistry", "start=", settings.RemoteRegistryStartupType},
+		{"net", settings.RemoteRegistryStatus, "RemoteRegistry"},
+		{"netsh", "advfirewall", "firewall", "set", "rule", "group=File and Printer Sharing", "new", "enable=" + settings.FileSharingStatus},
+		restoreTokenFilterPolicy(settings.LocalAccountTokenFilterPolicy),
+	}
+}
+
+func restoreTokenFilterPolicy(value string) []string {
+	if value == tokenFilterPolicyAbsent {
+		return []string{"reg", "delete", tokenFilterPolicyKey, "/v", "LocalAccountTokenFilterPolicy", "/f"}
+	}
+	return []string{"reg", "add", tokenFilterPolicyKey, "/v", "LocalAccountTokenFilterPolicy", "/t", "REG_DWORD", "/d", value, "/f"}
+}
+
+// getServiceStatus returns the net command that puts the service back as it
+// is now: start if it is running, stop otherwise.
+func getServiceStatus(serviceName string) (string, error) {
+	output, err := runner.Run(context.Background(), "sc", "query", serviceName)
+	if err != nil {
+		return "", fmt.Errorf("failed to query service status: %v", err)
+	}
+
+	if strings.Contains(string(output), "RUNNING") {
+		return "start", nil
+	}
+	return "stop", nil
+}
+
+// getFileSharingStatus returns the netsh enable= value that puts the File
+// and Printer Sharing rules back as they are now.
+func getFileSharingStatus() (string, error) {
+	output, err := runner.Run(context.Background(), "powershell", "-Command", "Get-NetFirewallRule -Group 'File and Printer Sharing' | Select -ExpandProperty Enabled")
+	if err != nil {
+		return "", fmt.Errorf("failed to query File and Printer Sharing status: %v", err)
+	}
+
+	if strings.Contains(string(output), "True") {
+		return "yes", nil
+	}
+	return "no", nil
+}
+
+// getRemoteRegistryStartupType returns the sc config start= value of the
+// RemoteRegistry service.
+func getRemoteRegistryStartupType() (string, error) {
+	output, err := runner.Run(context.Background(), "sc", "qc", "RemoteRegistry")
+	if err != nil {
+		return "", fmt.Errorf("failed to query RemoteRegistry startup type: %v", err)
+	}
+
+	switch {
+	case strings.Contains(string(output), "DEMAND_START"):
+		return "demand", nil
+	case strings.Contains(string(output), "DISABLED"):
+		return "disabled", nil
+	}
+	return "auto", nil
+}
+
+// getLocalAccountTokenFilterPolicy returns the value of
+// LocalAccountTokenFilterPolicy, or tokenFilterPolicyAbsent when it is not
+// set: reg query then runs but fails.
+func getLocalAccountTokenFilterPolicy() (string, error) {
+	output, err := runner.Run(context.Background(), "reg", "query", tokenFilterPolicyKey, "/v", "LocalAccountTokenFilterPolicy")
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		return tokenFilterPolicyAbsent, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query LocalAccountTokenFilterPolicy: %v", err)
+	}
+	if strings.Contains(string(output), "0x1") {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+// executeCommands runs every command, so that one failing does not stop the
+// others, and returns the first error. While setting up, a command that
+// runs but fails, such as starting a service that is already running, is
+// not an error. While restoring, strict is set and it is, so that a setting
+// left changed is reported, unless the service already was as asked.
+func executeCommands(commands [][]string, strict bool) error {
+	var first error
+	for _, c := range commands {
+		output, err := runner.Run(context.Background(), c[0], c[1:]...)
+		var cmdErr *commandError
+		if err == nil || first != nil || serviceAlreadyInState(c, output) {
+			continue
+		}
+		if errors.As(err, &cmdErr) && !strict {
+			continue
+		}
+		first = fmt.Errorf("failed to run command: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return first
+}
+
+// serviceAlreadyInState reports whether the output of net start or net stop
+// says that the service already was started or stopped. net gives the
+// message numbers in every language.
+func serviceAlreadyInState(command []string, output []byte) bool {
+	if command[0] != "net" {
+		return false
+	}
+	return strings.Contains(string(output), "HELPMSG 2182") || strings.Contains(string(output), "HELPMSG 3521")
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+// The Windows preparation steps only apply to Windows hosts. These stubs keep
+// the shared code in client.go building on the other platforms, where the
+// callers are guarded by runtime.GOOS checks.
+
+func storeCurrentSettings() (CurrentSettings, error) {
+	return CurrentSettings{}, nil
+}
+
+func saveSettingsToFile(settings CurrentSettings, filename string) error { return nil }
+
+func loadSettingsFromFile(filename string) (CurrentSettings, error) {
+	return CurrentSettings{}, nil
+}
+
+func setupWindowsNessus(settings CurrentSettings) error { return nil }
+
+func restoreOriginalSettings(settings CurrentSettings) error { return nil }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/altfreq07/Nessus_Client/fakeapi"
+)
+
+// useFakeNessus serves a fake Nessus over TLS and returns a backend for it
+// that trusts its certificate.
+func useFakeNessus(t *testing.T, scenario fakeapi.Scenario) (*fakeapi.Nessus, *nessusBackend) {
+	t.Helper()
+	fake := fakeapi.NewNessus(scenario, "access", "secret")
+	srv := httptest.NewTLSServer(fake)
+	t.Cleanup(srv.Close)
+	caFile := writeCA(t, srv.Certificate())
+	b, err := newNessusBackend(BackendConfig{Type: "nessus", URL: srv.URL, AccessKey: "access", SecretKey: "secret", CAFile: caFile, ReportDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fastScanner(t)
+	return fake, b
+}
+
+// writeCA saves the certificate of a test server for a CAFile.
+func writeCA(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+// fastScanner gives this machine a fixed tunnel address and shortens the
+// delays of a scanner driven directly.
+func fastScanner(t *testing.T) {
+	oldAddress, oldFast, oldStop, oldPolicies := tunnelAddress, scanPollFast, scanStopDelay, []retryPolicy{statusPolicy, pollPolicy, mutatePolicy}
+	t.Cleanup(func() {
+		tunnelAddress, scanPollFast, scanStopDelay = oldAddress, oldFast, oldStop
+		statusPolicy, pollPolicy, mutatePolicy = oldPolicies[0], oldPolicies[1], oldPolicies[2]
+	})
+	tunnelAddress = func(ctx context.Context) (string, error) { return "100.64.0.7", nil }
+	scanPollFast, scanStopDelay = time.Millisecond, 0
+	for _, p := range []*retryPolicy{&statusPolicy, &pollPolicy, &mutatePolicy} {
+		p.BaseDelay, p.MaxDelay = time.Millisecond, 5*time.Millisecond
+	}
+}
+
+func TestNessusBackendKeys(t *testing.T) {
+	fake, b := useFakeNessus(t, fakeapi.Scenarios["complete"])
+	if !b.Online(context.Background()) {
+		t.Error("Online() = false")
+	}
+	b.api.headers["X-ApiKeys"] = "accessKey=access; secretKey=wrong"
+	if b.Online(context.Background()) {
+		t.Error("Online() with the wrong keys = true")
+	}
+	if got := fake.Requests(); len(got) != 2 {
+		t.Errorf("requests = %q, want one for each check", got)
+	}
+
+	t.Setenv("NESSUS_ACCESS_KEY", "")
+	if _, err := newNessusBackend(BackendConfig{Type: "nessus", URL: "https://nessus:8834/", AccessKey: "access"}); err == nil {
+		t.Error("newNessusBackend() accepted a config without a secret key")
+	}
+}
+
+func TestNessusBackendPolicies(t *testing.T) {
+	_, b := useFakeNessus(t, fakeapi.Scenarios["complete"])
+	policies := b.Policies(context.Background())
+	var ids []string
+	for _, p := range policies {
+		ids = append(ids, p.ID)
+	}
+	// The agent template cannot scan through the tunnel.
+	if len(ids) != 3 || ids[0] != "basic" || ids[2] != "compliance" {
+		t.Fatalf("policies = %q", ids)
+	}
+	if policies[0].Name != "Basic Network Scan" || !policies[0].CredentialsSupported || !policies[2].CredentialsRequired {
+		t.Errorf("policies = %+v", policies)
+	}
+
+	b.templates = []string{"advanced"}
+	if got := b.Policies(context.Background()); len(got) != 1 || got[0].ID != "advanced" {
+		t.Errorf("policies limited to advanced = %+v", got)
+	}
+}
+
+func TestNessusBackendScan(t *testing.T) {
+	fake, b := useFakeNessus(t, fakeapi.Scenarios["complete"])
+	ctx := context.Background()
+	id, err := b.Start(ctx, scanRequest{Email: "it@example.com", Policy: "basic", Username: "admin", Password: "hunter2", Targets: []string{"192.168.1.20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := fake.Scans()[id]
+	settings, _ := scan.Request["settings"].(map[string]interface{})
+	if !scan.Launched || settings["text_targets"] != "100.64.0.7,192.168.1.20" || scan.Request["credentials"] == nil {
+		t.Errorf("scan = %+v", scan)
+	}
+
+	// The status sums both targets: the LAN device adds one finding.
+	var p scanProgress
+	for p.State != stateCompleted {
+		if p, err = b.Status(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.High != 2 || p.Medium != 5 || p.Info != 15 || p.Current != 100 {
+		t.Errorf("final status = %+v", p)
+	}
+
+	if err := b.Export(ctx, id, "it@example.com", ExportOptions{Formats: []string{"pdf", "csv"}, Chapters: []string{"summary"}, MinSeverity: "high"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"scan-11.pdf", "scan-11.csv"} {
+		if _, err := ioutil.ReadFile(filepath.Join(b.reportDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if exports := fake.Scans()[id].Exports; len(exports) != 2 || string(exports[0]) != `{"chapters":"vuln_hosts_summary","filter.0.filter":"severity","filter.0.quality":"gt","filter.0.value":2,"filter.search_type":"and","format":"pdf"}` {
+		t.Errorf("exports = %s", exports)
+	}
+
+	hosts, err := b.Findings(ctx, id)
+	if err != nil || len(hosts) != 1 || hosts[0].Host != "100.64.0.7" || len(hosts[0].Findings) != 22 {
+		t.Errorf("Findings() = %+v, %v", hosts, err)
+	}
+
+	if err := b.Delete(ctx, id); err != nil || !fake.Scans()[id].Deleted {
+		t.Errorf("Delete() = %v", err)
+	}
+}
+
+func TestNessusBackendCreatesScanOnce(t *testing.T) {
+	fake, b := useFakeNessus(t, fakeapi.Scenarios["complete"])
+	fake.LostReplies = 1
+	id, err := b.Start(context.Background(), scanRequest{Email: "it@example.com", Policy: "basic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The scan whose reply was lost is found by its name, not created again.
+	creates := 0
+	for _, r := range fake.Requests() {
+		if r == "POST /scans" {
+			creates++
+		}
+	}
+	if scans := fake.Scans(); len(scans) != 1 || !scans[id].Launched || creates != 1 {
+		t.Errorf("scans = %+v after %d creations", scans, creates)
+	}
+}
+
+func TestScannerClientSkipsProxy(t *testing.T) {
+	old := apiClient
+	t.Cleanup(func() { apiClient = old })
+	// A proxy cannot reach the scanner on the tunnel network.
+	apiClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})}}
+	_, b := useFakeNessus(t, fakeapi.Scenarios["complete"])
+	if !b.Online(context.Background()) {
+		t.Error("Online() = false, the scanner was reached through the proxy")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// NotificationTarget is an outbound webhook from the config file.
+type NotificationTarget struct {
+	// Type is "webhook" for the generic JSON payload, "slack" or "teams".
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Secret signs generic webhook payloads with HMAC-SHA256 in the
+	// X-Signature-256 header as "sha256=<hex>".
+	Secret string `json:"secret,omitempty"`
+}
+
+const (
+	eventScanStarted   = "scan_started"
+	eventScanCompleted = "scan_completed"
+	eventScanFailed    = "scan_failed"
+	eventCleanupFailed = "cleanup_failed"
+)
+
+// notificationEvent is the generic webhook payload.
+type notificationEvent struct {
+	Event    string          `json:"event"`
+	Time     time.Time       `json:"time"`
+	Hostname string          `json:"hostname"`
+	ScanID   int             `json:"scan_id,omitempty"`
+	Policy   string          `json:"policy,omitempty"`
+	Counts   *severityCounts `json:"counts,omitempty"`
+	ExitCode int             `json:"exit_code"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type severityCounts struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Info     int `json:"info"`
+}
+
+// notificationTimeout bounds each delivery so a dead receiver cannot hold
+// up the scan or its cleanup.
+var notificationTimeout = 10 * time.Second
+
+// summary is the human readable form used for Slack and Teams.
+func (e notificationEvent) summary() (title, text string) {
+	host := e.Hostname
+	switch e.Event {
+	case eventScanStarted:
+		return "Scan started", fmt.Sprintf("Scan %d started on %s with policy %s.", e.ScanID, host, e.Policy)
+	case eventScanCompleted:
+		text = fmt.Sprintf("Scan %d on %s completed.", e.ScanID, host)
+		if e.Counts != nil {
+			text += fmt.Sprintf(" Critical: %d, High: %d, Medium: %d, Low: %d, Info: %d.", e.Counts.Critical, e.Counts.High, e.Counts.Medium, e.Counts.Low, e.Counts.Info)
+		}
+		return "Scan completed", text
+	case eventScanFailed:
+		return "Scan failed", fmt.Sprintf("Scan %d on %s failed with exit code %d: %s", e.ScanID, host, e.ExitCode, e.Error)
+	case eventCleanupFailed:
+		return "Cleanup failed", fmt.Sprintf("Restoring %s after scan %d failed: %s. Check the machine.", host, e.ScanID, e.Error)
+	}
+	return e.Event, ""
+}
+
+func (e notificationEvent) colour() string {
+	switch e.Event {
+	case eventScanCompleted:
+		if e.Counts != nil && (e.Counts.Critical > 0 || e.Counts.High > 0) {
+			return "D13212"
+		}
+		return "2EB67D"
+	case eventScanFailed, eventCleanupFailed:
+		return "D13212"
+	}
+	return "1264A3"
+}
+
+// payload renders the event in the format the target expects.
+func (t NotificationTarget) payload(e notificationEvent) ([]byte, error) {
+	title, text := e.summary()
+	switch strings.ToLower(t.Type) {
+	case "", "webhook":
+		return json.Marshal(e)
+	case "slack":
+		return json.Marshal(map[string]interface{}{
+			"text": fmt.Sprintf("*%s*\n%s", title, text),
+			"attachments": []map[string]string{
+				{"color": "#" + e.colour(), "text": text, "fallback": text},
+			},
+		})
+	case "teams":
+		return json.Marshal(map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"themeColor": e.colour(),
+			"title":      title,
+			"text":       text,
+		})
+	}
+	return nil, fmt.Errorf("unknown notification type %q", t.Type)
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send delivers one event to the target.
+func (t NotificationTarget) send(e notificationEvent) error {
+	body, err := t.payload(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event", e.Event)
+	if t.Secret != "" {
+		req.Header.Set("X-Signature-256", signPayload(t.Secret, body))
+	}
+
+	client := &http.Client{Timeout: notificationTimeout, Transport: apiClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending notification: %v", err)
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification receiver returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notify sends the event to every configured target. Delivery failures are
+// reported but never fail the run.
+func notify(e notificationEvent) {
+	if len(config.Notifications) == 0 {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if e.Hostname == "" {
+		e.Hostname, _ = os.Hostname()
+	}
+	for _, t := range config.Notifications {
+		if dryRun {
+			planf("send a %s %s notification to %s", e.Event, t.Type, t.URL)
+			continue
+		}
+		if err := t.send(e); err != nil {
+			fmt.Printf("Error sending %s notification to %s: %v\n", e.Event, t.Type, err)
+		}
+	}
+}
+
+// notifyResult sends the events for the end of a run: completion or failure,
+// and a separate event if any change could not be undone.
+func notifyResult(result scanResult, cleanupErrors []error) {
+	e := notificationEvent{ScanID: result.ScanID, Policy: result.Policy, ExitCode: result.ExitCode}
+	if result.Err == nil {
+		e.Event = eventScanCompleted
+		e.Counts = &severityCounts{result.Final.Critical, result.Final.High, result.Final.Medium, result.Final.Low, result.Final.Info}
+	} else {
+		e.Event = eventScanFailed
+		e.Error = result.Err.Error()
+	}
+	notify(e)
+
+	if len(cleanupErrors) > 0 {
+		var messages []string
+		for _, err := range cleanupErrors {
+			messages = append(messages, err.Error())
+		}
+		notify(notificationEvent{Event: eventCleanupFailed, ScanID: result.ScanID, Policy: result.Policy, ExitCode: result.ExitCode, Error: strings.Join(messages, "; ")})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type receivedNotification struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+// notificationReceiver records every delivery it gets.
+type notificationReceiver struct {
+	mu       sync.Mutex
+	received []receivedNotification
+}
+
+func (n *notificationReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.received = append(n.received, receivedNotification{r.Header.Get("X-Event"), r.Header.Get("X-Signature-256"), body})
+}
+
+func useNotifications(t *testing.T, targets ...NotificationTarget) {
+	t.Helper()
+	old := config.Notifications
+	config.Notifications = targets
+	t.Cleanup(func() { config.Notifications = old })
+}
+
+func TestNotifyWebhookSigned(t *testing.T) {
+	receiver := &notificationReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	useNotifications(t, NotificationTarget{Type: "webhook", URL: srv.URL, Secret: "s3cret"})
+
+	notifyResult(scanResult{ScanID: 12, Policy: "basic", Final: scanProgress{Critical: 1, High: 2}}, []error{errors.New("restore settings: access denied")})
+
+	if len(receiver.received) != 2 {
+		t.Fatalf("received %d notifications, want 2", len(receiver.received))
+	}
+	for _, got := range receiver.received {
+		if want := signPayload("s3cret", got.body); got.signature != want {
+			t.Errorf("%s signature = %q, want %q", got.event, got.signature, want)
+		}
+	}
+
+	var completed notificationEvent
+	if err := json.Unmarshal(receiver.received[0].body, &completed); err != nil {
+		t.Fatal(err)
+	}
+	if completed.Event != eventScanCompleted || completed.ScanID != 12 || completed.Counts == nil || completed.Counts.High != 2 {
+		t.Errorf("completed event = %+v", completed)
+	}
+	var cleanup notificationEvent
+	json.Unmarshal(receiver.received[1].body, &cleanup)
+	if cleanup.Event != eventCleanupFailed || !strings.Contains(cleanup.Error, "access denied") {
+		t.Errorf("cleanup event = %+v", cleanup)
+	}
+}
+
+func TestNotifyChatPayloads(t *testing.T) {
+	receiver := &notificationReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	useNotifications(t, NotificationTarget{Type: "slack", URL: srv.URL}, NotificationTarget{Type: "teams", URL: srv.URL})
+
+	notifyResult(scanResult{ScanID: 5, ExitCode: exitScanAborted, Err: &scanEndedError{State: stateAborted}}, nil)
+
+	if len(receiver.received) != 2 {
+		t.Fatalf("received %d notifications, want 2", len(receiver.received))
+	}
+	var slack struct{ Text string }
+	json.Unmarshal(receiver.received[0].body, &slack)
+	if !strings.Contains(slack.Text, "Scan failed") || !strings.Contains(slack.Text, "scan aborted") {
+		t.Errorf("slack text = %q", slack.Text)
+	}
+	var teams map[string]string
+	json.Unmarshal(receiver.received[1].body, &teams)
+	if teams["@type"] != "MessageCard" || teams["title"] != "Scan failed" || receiver.received[1].signature != "" {
+		t.Errorf("teams payload = %v", teams)
+	}
+}
+
+func TestNotifyReceiverDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := NotificationTarget{URL: srv.URL}.send(notificationEvent{Event: eventScanStarted})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("send() error = %v, want status 502", err)
+	}
+	if _, err := (NotificationTarget{Type: "pager"}).payload(notificationEvent{}); err == nil {
+		t.Error("payload() for an unknown type succeeded")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// A PAC (proxy auto-config) file is a JavaScript function that returns the
+// proxy for a URL. It runs in goja, an embedded JavaScript engine, with the
+// standard PAC helpers written in Go. The script gets nothing else: it cannot
+// reach files or the network except through the DNS helpers.
+
+// pacScript is a loaded PAC file.
+type pacScript struct {
+	mu        sync.Mutex // a goja runtime runs one call at a time
+	vm        *goja.Runtime
+	findProxy goja.Callable
+}
+
+// Test seams for the helpers that use the network or the clock.
+var (
+	pacLookupHost  = net.LookupHost
+	pacMyIPAddress = myIPAddress
+	pacNow         = time.Now
+)
+
+// pacTimeout stops a PAC file that never returns from hanging every
+// request.
+var pacTimeout = 10 * time.Second
+
+// myIPAddress returns the address this machine uses to reach the internet.
+// No packet is sent: connecting a UDP socket only picks the route.
+func myIPAddress() string {
+	conn, err := net.Dial("udp", "192.0.2.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// parsePAC loads a PAC file and runs its top-level statements.
+func parsePAC(src string) (*pacScript, error) {
+	s := &pacScript{vm: goja.New()}
+	for name, fn := range pacBuiltins {
+		if err := s.vm.Set(name, fn); err != nil {
+			return nil, fmt.Errorf("error loading PAC file: %v", err)
+		}
+	}
+	if err := s.run(func() error {
+		_, err := s.vm.RunString(src)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("error running PAC file: %v", err)
+	}
+	find, ok := goja.AssertFunction(s.vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("PAC file has no FindProxyForURL function")
+	}
+	s.findProxy = find
+	return s, nil
+}
+
+// run runs fn, interrupting the script if it takes longer than pacTimeout.
+func (s *pacScript) run(fn func() error) error {
+	timer := time.AfterFunc(pacTimeout, func() {
+		s.vm.Interrupt(fmt.Sprintf("still running after %v", pacTimeout))
+	})
+	defer func() {
+		timer.Stop()
+		s.vm.ClearInterrupt()
+	}()
+	return fn()
+}
+
+// find runs FindProxyForURL and returns its answer, such as
+// "PROXY proxy.example.com:8080; DIRECT".
+func (s *pacScript) find(rawURL, host string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result goja.Value
+	err := s.run(func() error {
+		var err error
+		result, err = s.findProxy(goja.Undefined(), s.vm.ToValue(rawURL), s.vm.ToValue(host))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running PAC file: %v", err)
+	}
+	return result.String(), nil
+}
+
+// parsePACResult returns the first proxy of a FindProxyForURL answer, or nil
+// for DIRECT. Only the first usable entry is tried; there is no failover to
+// the next one.
+func parsePACResult(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		}
+		if scheme != "" && len(fields) > 1 {
+			return &url.URL{Scheme: scheme, Host: fields[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("PAC file returned no usable proxy: %q", result)
+}
+
+// pacResolve returns the first IPv4 address of host.
+func pacResolve(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return host
+	}
+	addrs, err := pacLookupHost(host)
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+			return a
+		}
+	}
+	return ""
+}
+
+// shExpMatch matches shell patterns, where * is any text and ? any
+// character.
+func shExpMatch(s, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(s)
+}
+
+var (
+	pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	pacMonths   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+// pacName returns the index of the weekday or month name v in names, or -1.
+func pacName(names []string, v goja.Value) int {
+	for i, name := range names {
+		if strings.EqualFold(v.String(), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// pacClock returns the time the date and time helpers compare with, and
+// their arguments without the last "GMT" that asks for UTC.
+func pacClock(args []goja.Value) (time.Time, []goja.Value) {
+	now := pacNow()
+	if n := len(args); n > 0 && args[n-1].String() == "GMT" {
+		return now.UTC(), args[:n-1]
+	}
+	return now.Local(), args
+}
+
+// pacInRange reports whether v is between lo and hi included. A range whose
+// lo is after hi wraps around, like from FRI to MON or from 22 to 6 hours.
+func pacInRange(v, lo, hi int) bool {
+	if lo <= hi {
+		return lo <= v && v <= hi
+	}
+	return v >= lo || v <= hi
+}
+
+// The fields of a date given to dateRange.
+const (
+	pacDay = 1 << iota
+	pacMonth
+	pacYear
+)
+
+// pacDate reads the day, month and year arguments of one end of a
+// dateRange into a number that sorts like the dates, and returns which
+// fields were given, or 0 if an argument is neither.
+func pacDate(args []goja.Value) (key, fields int) {
+	for _, arg := range args {
+		var field, n, scale int
+		if m := pacName(pacMonths, arg); m >= 0 {
+			field, n, scale = pacMonth, m+1, 100
+		} else if n = int(arg.ToInteger()); n >= 1 && n <= 31 {
+			field, scale = pacDay, 1
+		} else if n > 31 {
+			field, scale = pacYear, 10000
+		} else {
+			return 0, 0
+		}
+		if fields&field != 0 {
+			return 0, 0
+		}
+		fields |= field
+		key += n * scale
+	}
+	return key, fields
+}
+
+// pacBuiltins are the standard PAC helper functions.
+var pacBuiltins = map[string]interface{}{
+	"isPlainHostName": func(host string) bool {
+		return !strings.Contains(host, ".")
+	},
+	"dnsDomainIs": func(host, domain string) bool {
+		return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+	},
+	"localHostOrDomainIs": func(host, hostdom string) bool {
+		host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+		return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")
+	},
+	"shExpMatch": shExpMatch,
+	"isResolvable": func(host string) bool {
+		return pacResolve(host) != ""
+	},
+	"dnsResolve": func(host string) interface{} {
+		if ip := pacResolve(host); ip != "" {
+			return ip
+		}
+		return nil
+	},
+	"isInNet": func(host, pattern, mask string) bool {
+		ip := net.ParseIP(pacResolve(host)).To4()
+		p := net.ParseIP(pattern).To4()
+		m := net.ParseIP(mask).To4()
+		if ip == nil || p == nil || m == nil {
+			return false
+		}
+		return ip.Mask(net.IPMask(m)).Equal(p.Mask(net.IPMask(m)))
+	},
+	"myIpAddress": func() string {
+		return pacMyIPAddress()
+	},
+	"dnsDomainLevels": func(host string) int {
+		return strings.Count(host, ".")
+	},
+	// weekdayRange(wd1[, wd2][, "GMT"]), with days from "SUN" to "SAT".
+	"weekdayRange": func(a ...goja.Value) bool {
+		now, a := pacClock(a)
+		if len(a) == 0 || len(a) > 2 {
+			return false
+		}
+		lo, hi := pacName(pacWeekdays, a[0]), pacName(pacWeekdays, a[len(a)-1])
+		return lo >= 0 && hi >= 0 && pacInRange(int(now.Weekday()), lo, hi)
+	},
+	// dateRange takes a day, a month, a year or a pair of them, or two
+	// ends of day and month, month and year or day, month and year.
+	"dateRange": func(a ...goja.Value) bool {
+		now, a := pacClock(a)
+		if len(a) == 1 {
+			a = []goja.Value{a[0], a[0]}
+		}
+		if len(a) == 0 || len(a) > 6 || len(a)%2 != 0 {
+			return false
+		}
+		lo, fields := pacDate(a[:len(a)/2])
+		hi, hiFields := pacDate(a[len(a)/2:])
+		if fields == 0 || fields != hiFields || fields&pacYear != 0 && lo > hi {
+			return false
+		}
+		v := 0
+		if fields&pacDay != 0 {
+			v += now.Day()
+		}
+		if fields&pacMonth != 0 {
+			v += int(now.Month()) * 100
+		}
+		if fields&pacYear != 0 {
+			v += now.Year() * 10000
+		}
+		return pacInRange(v, lo, hi)
+	},
+	// timeRange takes an hour, two hours, two hours and minutes or two
+	// hours, minutes and seconds. Two hours go from the start of the first
+	// to the start of the second, so timeRange(9, 17) is office hours.
+	"timeRange": func(a ...goja.Value) bool {
+		now, a := pacClock(a)
+		n := make([]int, len(a))
+		for i, v := range a {
+			n[i] = int(v.ToInteger())
+		}
+		secs := now.Hour()*3600 + now.Minute()*60 + now.Second()
+		switch len(n) {
+		case 1:
+			return now.Hour() == n[0]
+		case 2:
+			return pacInRange(secs, n[0]*3600, n[1]*3600-1)
+		case 4:
+			return pacInRange(secs, n[0]*3600+n[1]*60, n[2]*3600+n[3]*60+59)
+		case 6:
+			return pacInRange(secs, n[0]*3600+n[1]*60+n[2], n[3]*3600+n[4]*60+n[5])
+		}
+		return false
+	},
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ScanPolicy is a scan template offered by the backend.
+type ScanPolicy struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// CredentialsRequired is set for policies that cannot run without an
+	// account on the host, such as compliance audits.
+	CredentialsRequired bool `json:"credentials_required"`
+	// CredentialsSupported is set for policies that can optionally log in
+	// to the host for a deeper scan.
+	CredentialsSupported bool `json:"credentials_supported"`
+}
+
+type PoliciesResponse struct {
+	Policies []ScanPolicy `json:"policies"`
+}
+
+// defaultPolicies are the policies a Nessus scanner is assumed to offer when
+// its templates cannot be listed, and those a dry run assumes the API offers.
+var defaultPolicies = []ScanPolicy{
+	{ID: "basic", Name: "Basic Network Scan", Description: "Full system scan suitable for any host", CredentialsSupported: true},
+	{ID: "advanced", Name: "Advanced Scan", Description: "Basic scan with every plugin family enabled", CredentialsSupported: true},
+	{ID: "malware", Name: "Malware Scan", Description: "Scan for malware on the host", CredentialsRequired: true, CredentialsSupported: true},
+	{ID: "compliance", Name: "Policy Compliance Auditing", Description: "Audit the host against CIS benchmarks", CredentialsRequired: true, CredentialsSupported: true},
+	{ID: "webapp", Name: "Web Application Tests", Description: "Scan web applications running on the host"},
+}
+
+// serverDefaultPolicy stands for the scan the API runs when it is sent no
+// policy, as it was before policies could be chosen.
+var serverDefaultPolicy = ScanPolicy{Name: "Default", Description: "The scan the server runs by default", CredentialsSupported: true}
+
+// fetchPolicies asks the API which scan policies it offers. When it does not
+// say, the scan is created without a policy and the API picks its default:
+// the list holds only serverDefaultPolicy.
+func fetchPolicies(ctx context.Context) []ScanPolicy {
+	var resp PoliciesResponse
+	err := apiRequest(ctx, http.MethodGet, "policies", nil, &resp, nil, statusPolicy)
+	if err != nil || len(resp.Policies) == 0 {
+		debugPrint("The API offers no scan policies, sending none: %v\n", err)
+		return []ScanPolicy{serverDefaultPolicy}
+	}
+	return resp.Policies
+}
+
+func findPolicy(policies []ScanPolicy, id string) (ScanPolicy, bool) {
+	for _, p := range policies {
+		if strings.EqualFold(p.ID, id) {
+			return p, true
+		}
+	}
+	return ScanPolicy{}, false
+}
+
+// choosePolicy returns the policy with the preset ID, or asks the user to
+// pick one when preset is empty. Pressing enter picks the first policy.
+func choosePolicy(ctx context.Context, policies []ScanPolicy, preset string) (ScanPolicy, error) {
+	if preset != "" {
+		p, ok := findPolicy(policies, preset)
+		if !ok && len(policies) == 1 && policies[0].ID == "" {
+			return ScanPolicy{}, fmt.Errorf("scan policy %q cannot be used: the server offers no scan policies", preset)
+		}
+		if !ok {
+			return ScanPolicy{}, fmt.Errorf("unknown scan policy %q", preset)
+		}
+		return p, nil
+	}
+	if len(policies) == 1 {
+		return policies[0], nil
+	}
+
+	fmt.Println(tr("Available scan policies:"))
+	for i, p := range policies {
+		fmt.Printf("  %d) %s - %s\n", i+1, p.Name, p.Description)
+	}
+	for {
+		fmt.Print(tr("Choose a scan policy [1-%d] (default 1): ", len(policies)))
+		input, err := readLine(ctx)
+		if err != nil {
+			return ScanPolicy{}, err
+		}
+
+		input = strings.TrimSpace(input)
+		if input == "" {
+			return policies[0], nil
+		}
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(policies) {
+			return policies[n-1], nil
+		}
+		if p, ok := findPolicy(policies, input); ok {
+			return p, nil
+		}
+		fmt.Println(tr("Invalid choice. Please enter one of the numbers above."))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFetchPolicies(t *testing.T) {
+	offered := []ScanPolicy{{ID: "basic", Name: "Basic Network Scan"}, {ID: "compliance", Name: "Policy Compliance Auditing", CredentialsRequired: true}}
+	tests := map[string]struct {
+		status int
+		body   string
+		want   []ScanPolicy
+	}{
+		"offered":     {http.StatusOK, `{"policies": [{"id": "basic", "name": "Basic Network Scan"}, {"id": "compliance", "name": "Policy Compliance Auditing", "credentials_required": true}]}`, offered},
+		"empty list":  {http.StatusOK, `{"policies": []}`, []ScanPolicy{serverDefaultPolicy}},
+		"no endpoint": {http.StatusNotFound, `not found`, []ScanPolicy{serverDefaultPolicy}},
+	}
+	for name, tt := range tests {
+		useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/policies" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		got := fetchPolicies(context.Background())
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: fetchPolicies() = %+v, want %+v", name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: policy %d = %+v, want %+v", name, i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	// Without a list the scan request carries no policy, as before.
+	data, _ := json.Marshal(ScanRequest{Policy: serverDefaultPolicy.ID})
+	if strings.Contains(string(data), `"policy"`) {
+		t.Errorf("scan request with the server's default policy = %s", data)
+	}
+}
+
+func TestChoosePolicy(t *testing.T) {
+	defer func(old *bufio.Reader) { stdin = old }(stdin)
+	policies := []ScanPolicy{{ID: "basic", Name: "Basic Network Scan"}, {ID: "advanced", Name: "Advanced Scan"}, {ID: "webapp", Name: "Web Application Tests"}}
+
+	tests := []struct {
+		name   string
+		preset string
+		input  string
+		want   string
+	}{
+		{"preset", "Advanced", "", "advanced"},
+		{"default", "", "\n", "basic"},
+		{"number", "", "3\n", "webapp"},
+		{"ID", "", "advanced\n", "advanced"},
+		{"invalid then number", "", "7\nnone\n2\n", "advanced"},
+	}
+	for _, tt := range tests {
+		stdin = bufio.NewReader(strings.NewReader(tt.input))
+		p, err := choosePolicy(context.Background(), policies, tt.preset)
+		if err != nil || p.ID != tt.want {
+			t.Errorf("%s: choosePolicy() = %q, %v, want %q", tt.name, p.ID, err, tt.want)
+		}
+	}
+
+	if _, err := choosePolicy(context.Background(), policies, "malware"); err == nil || !strings.Contains(err.Error(), "unknown scan policy") {
+		t.Errorf("choosePolicy(malware) = %v, want an unknown policy error", err)
+	}
+	// The server's default is used without asking, and a preset policy is
+	// refused clearly when the server offers none.
+	stdin = bufio.NewReader(strings.NewReader(""))
+	if p, err := choosePolicy(context.Background(), []ScanPolicy{serverDefaultPolicy}, ""); err != nil || p != serverDefaultPolicy {
+		t.Errorf("choosePolicy() = %+v, %v, want the server's default", p, err)
+	}
+	if _, err := choosePolicy(context.Background(), []ScanPolicy{serverDefaultPolicy}, "basic"); err == nil || !strings.Contains(err.Error(), "offers no scan policies") {
+		t.Errorf("choosePolicy(basic) without a list = %v", err)
+	}
+}
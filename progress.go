@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Scan phases shown next to the progress bar.
+const (
+	phaseDiscovery = "Host discovery"
+	phasePortScan  = "Port scan"
+	phasePlugins   = "Plugin checks"
+	phaseReport    = "Report generation"
+)
+
+// serverPhases maps the phase names the server may report in info.phase.
+var serverPhases = map[string]string{
+	"discovery":  phaseDiscovery,
+	"port_scan":  phasePortScan,
+	"plugins":    phasePlugins,
+	"processing": phaseReport,
+	"report":     phaseReport,
+}
+
+// scanPhase returns the phase of the scan. The server's own phase is used
+// when it reports one; otherwise it is guessed from the state and progress,
+// which follows the order Nessus works in.
+func scanPhase(p scanProgress) string {
+	if phase, ok := serverPhases[p.Phase]; ok {
+		return phase
+	}
+	switch {
+	case p.State == stateProcessing || p.State == stateCompleted:
+		return phaseReport
+	case p.State != stateRunning:
+		return ""
+	case p.Current < 5:
+		return phaseDiscovery
+	case p.Current < 20:
+		return phasePortScan
+	}
+	return phasePlugins
+}
+
+// etaEstimator predicts the remaining time of a scan from the rate at which
+// it progresses and from how long earlier scans with the same policy took.
+type etaEstimator struct {
+	start      time.Time     // when the scan was started
+	typical    time.Duration // median duration of earlier scans, 0 if unknown
+	firstTime  time.Time     // first progress sample above zero
+	firstValue int
+}
+
+func newETAEstimator(start time.Time, policy string, history []HistoryRecord) *etaEstimator {
+	var durations []float64
+	for _, r := range history {
+		// Duration covers the whole run, tunnel and questions included, so
+		// only the scan's own duration is used.
+		if r.Policy == policy && r.completed() && r.ScanDuration > 0 {
+			durations = append(durations, r.ScanDuration)
+		}
+	}
+	e := &etaEstimator{start: start}
+	if len(durations) > 0 {
+		e.typical = time.Duration(median(durations) * float64(time.Second))
+	}
+	return e
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// remaining returns the estimated time left at now, or false when there is
+// nothing to base an estimate on yet.
+func (e *etaEstimator) remaining(p scanProgress, now time.Time) (time.Duration, bool) {
+	if p.Current >= 100 {
+		return 0, true
+	}
+	if p.Current > 0 && e.firstTime.IsZero() {
+		e.firstTime, e.firstValue = now, p.Current
+	}
+
+	var fromRate, fromHistory time.Duration
+	haveRate := !e.firstTime.IsZero() && p.Current > e.firstValue
+	if haveRate {
+		perPoint := now.Sub(e.firstTime) / time.Duration(p.Current-e.firstValue)
+		fromRate = perPoint * time.Duration(100-p.Current)
+	}
+	haveHistory := e.typical > 0
+	if haveHistory {
+		fromHistory = e.typical - now.Sub(e.start)
+		if fromHistory < 0 {
+			fromHistory = 0
+		}
+	}
+
+	switch {
+	case haveRate && haveHistory:
+		// Early progress is uneven, so trust history at first and the
+		// measured rate more as the scan goes on.
+		weight := float64(p.Current) / 100
+		return time.Duration(weight*float64(fromRate) + (1-weight)*float64(fromHistory)), true
+	case haveRate:
+		return fromRate, true
+	case haveHistory:
+		return fromHistory, true
+	}
+	return 0, false
+}
+
+// formatETA renders a remaining time for the progress line.
+func formatETA(d time.Duration, ok bool) string {
+	switch {
+	case !ok:
+		return tr("ETA unknown")
+	case d < time.Minute:
+		return tr("ETA <1m")
+	case d < time.Hour:
+		return tr("ETA %dm", int(d.Round(time.Minute).Minutes()))
+	}
+	d = d.Round(time.Minute)
+	return tr("ETA %dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
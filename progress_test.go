@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanPhase(t *testing.T) {
+	tests := []struct {
+		progress scanProgress
+		want     string
+	}{
+		{scanProgress{State: statePending}, ""},
+		{scanProgress{State: stateRunning, Current: 2}, phaseDiscovery},
+		{scanProgress{State: stateRunning, Current: 12}, phasePortScan},
+		{scanProgress{State: stateRunning, Current: 60}, phasePlugins},
+		{scanProgress{State: stateProcessing, Current: 100}, phaseReport},
+		{scanProgress{State: stateRunning, Current: 60, Phase: "port_scan"}, phasePortScan},
+	}
+	for _, tt := range tests {
+		if got := scanPhase(tt.progress); got != tt.want {
+			t.Errorf("scanPhase(%+v) = %q, want %q", tt.progress, got, tt.want)
+		}
+	}
+}
+
+func TestETAEstimator(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	running := func(current int) scanProgress { return scanProgress{State: stateRunning, Current: current} }
+
+	// Without history there is no estimate until progress is measured.
+	e := newETAEstimator(start, "basic", nil)
+	if _, ok := e.remaining(running(0), at(1)); ok {
+		t.Error("estimate with no data")
+	}
+	e.remaining(running(10), at(2))
+	if got, _ := e.remaining(running(20), at(12)); got != 80*time.Minute {
+		t.Errorf("rate estimate = %s, want 1h20m", got)
+	}
+
+	// Earlier scans of the same policy give an estimate straight away from
+	// how long the scans themselves took; other policies, failed scans and
+	// records without a scan duration are ignored.
+	history := []HistoryRecord{
+		{Policy: "basic", Duration: 4000, ScanDuration: 3600},
+		{Policy: "basic", Duration: 2400, ScanDuration: 1800},
+		{Policy: "basic", Duration: 2900, ScanDuration: 2400},
+		{Policy: "basic", Duration: 900},
+		{Policy: "basic", Duration: 60, ScanDuration: 30, ExitCode: exitScanAborted},
+		{Policy: "advanced", Duration: 36000, ScanDuration: 35000},
+	}
+	e = newETAEstimator(start, "basic", history)
+	if got, ok := e.remaining(running(0), at(10)); !ok || got != 30*time.Minute {
+		t.Errorf("history estimate = %s, %v, want 30m", got, ok)
+	}
+	if got, _ := e.remaining(scanProgress{State: stateCompleted, Current: 100}, at(50)); got != 0 {
+		t.Errorf("estimate when done = %s", got)
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second:                "ETA <1m",
+		14*time.Minute + 40*time.Second: "ETA 15m",
+		2*time.Hour + 5*time.Minute:     "ETA 2h05m",
+	}
+	for d, want := range tests {
+		if got := formatETA(d, true); got != want {
+			t.Errorf("formatETA(%s) = %q, want %q", d, got, want)
+		}
+	}
+	if got := formatETA(0, false); got != "ETA unknown" {
+		t.Errorf("formatETA without estimate = %q", got)
+	}
+
+	defer func(old string) { language = old }(language)
+	language = "de"
+	if got := formatETA(2*time.Hour+5*time.Minute, true); got != "Noch 2h05m" {
+		t.Errorf("formatETA in German = %q", got)
+	}
+}
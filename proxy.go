@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// ProxyConfig is how the client reaches the API, and the tunnel the
+// scanner, on networks that only allow outbound traffic through a proxy.
+type ProxyConfig struct {
+	// URL is the proxy, such as http://proxy.example.com:8080,
+	// https://proxy.example.com or socks5://proxy.example.com:1080. "system"
+	// (the default) uses this machine's proxy settings; "direct" uses none.
+	URL string `json:"url,omitempty"`
+	// PAC is the URL or path of a proxy auto-config file, used instead of URL.
+	PAC string `json:"pac,omitempty"`
+	// Auth is how to log in to the proxy: basic (the default) or ntlm.
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Password is read from the PROXY_PASSWORD environment variable when it
+	// is not set here.
+	Password string `json:"password,omitempty"`
+	// Domain is the Windows domain of the user, for NTLM.
+	Domain string `json:"domain,omitempty"`
+	// NoProxy lists comma separated hosts to reach directly: names, domain
+	// suffixes such as .corp.example, patterns such as 10.*, networks such
+	// as 10.0.0.0/8 and <local> for names without a dot.
+	NoProxy string `json:"no_proxy,omitempty"`
+}
+
+// netbirdManagementURL is where the tunnel client connects first. A PAC
+// file is asked about it to find the tunnel's proxy.
+const netbirdManagementURL = "https://api.netbird.io:443/"
+
+// apiClient sends the API requests, the scan events and the notifications,
+// through the proxy once configureProxy has run.
+var apiClient = http.DefaultClient
+
+// tunnelProxyEnv is passed to the tunnel service so it uses the proxy too.
+var tunnelProxyEnv []string
+
+// proxyPassword is hidden wherever commands are printed or recorded.
+var proxyPassword string
+
+// proxySettings are the resolved proxy options.
+type proxySettings struct {
+	source  string   // where the settings came from
+	system  bool     // read from the system settings
+	fixed   *url.URL // the proxy when there is no PAC file; nil for none
+	pac     *pacScript
+	noProxy []string
+
+	auth     string // basic or ntlm
+	username string
+	password string
+	domain   string
+}
+
+// systemProxy reads this machine's proxy settings. The environment
+// variables, which servers often use, are checked before the settings of
+// the operating system. Linux has no system-wide setting besides them.
+func systemProxy(ctx context.Context, goos string) (ProxyConfig, string) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			noProxy := os.Getenv("NO_PROXY")
+			if noProxy == "" {
+				noProxy = os.Getenv("no_proxy")
+			}
+			return ProxyConfig{URL: value, NoProxy: noProxy}, name
+		}
+	}
+	switch goos {
+	case "windows":
+		output, err := runner.Run(ctx, "reg", "query", `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`)
+		if err != nil {
+			debugPrint("Error reading the Internet Settings: %v\n", err)
+			return ProxyConfig{}, ""
+		}
+		return parseWindowsProxy(string(output)), "Internet Settings"
+	case "darwin":
+		output, err := runner.Run(ctx, "scutil", "--proxy")
+		if err != nil {
+			debugPrint("Error reading the network proxy settings: %v\n", err)
+			return ProxyConfig{}, ""
+		}
+		return parseScutilProxy(string(output)), "network settings"
+	}
+	return ProxyConfig{}, ""
+}
+
+// parseWindowsProxy reads the output of reg query for the Internet
+// Settings key. Automatic detection (WPAD) is not supported.
+func parseWindowsProxy(output string) ProxyConfig {
+	values := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.HasPrefix(fields[1], "REG_") {
+			values[fields[0]] = strings.Join(fields[2:], " ")
+		}
+	}
+	var cfg ProxyConfig
+	if pac := values["AutoConfigURL"]; pac != "" {
+		cfg.PAC = pac
+	}
+	if values["ProxyEnable"] == "0x1" && values["ProxyServer"] != "" {
+		// The server is either one for every protocol or a list such as
+		// "http=proxy:80;https=proxy:443;socks=proxy:1080".
+		server := values["ProxyServer"]
+		if strings.Contains(server, "=") {
+			byProtocol := map[string]string{}
+			for _, entry := range strings.Split(server, ";") {
+				if i := strings.Index(entry, "="); i > 0 {
+					byProtocol[strings.ToLower(entry[:i])] = entry[i+1:]
+				}
+			}
+			switch {
+			case byProtocol["https"] != "":
+				server = "http://" + byProtocol["https"]
+			case byProtocol["http"] != "":
+				server = "http://" + byProtocol["http"]
+			case byProtocol["socks"] != "":
+				server = "socks5://" + byProtocol["socks"]
+			default:
+				server = ""
+			}
+		}
+		cfg.URL = server
+		cfg.NoProxy = strings.ReplaceAll(values["ProxyOverride"], ";", ",")
+	}
+	return cfg
+}
+
+// parseScutilProxy reads the output of scutil --proxy.
+func parseScutilProxy(output string) ProxyConfig {
+	values := map[string]string{}
+	var exceptions []string
+	inExceptions := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if inExceptions {
+			if line == "}" {
+				inExceptions = false
+			} else if i := strings.Index(line, " : "); i >= 0 {
+				exceptions = append(exceptions, line[i+3:])
+			}
+			continue
+		}
+		i := strings.Index(line, " : ")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+3:]
+		if key == "ExceptionsList" {
+			inExceptions = true
+			continue
+		}
+		values[key] = value
+	}
+
+	cfg := ProxyConfig{NoProxy: strings.Join(exceptions, ",")}
+	if values["ProxyAutoConfigEnable"] == "1" {
+		cfg.PAC = values["ProxyAutoConfigURLString"]
+	}
+	for _, p := range []struct{ key, scheme string }{{"HTTPS", "http"}, {"HTTP", "http"}, {"SOCKS", "socks5"}} {
+		if values[p.key+"Enable"] == "1" && values[p.key+"Proxy"] != "" {
+			cfg.URL = p.scheme + "://" + net.JoinHostPort(values[p.key+"Proxy"], values[p.key+"Port"])
+			break
+		}
+	}
+	if values["ExcludeSimpleHostnames"] == "1" {
+		cfg.NoProxy = strings.TrimPrefix(cfg.NoProxy+",<local>", ",")
+	}
+	return cfg
+}
+
+// parseProxyURL accepts a proxy with or without a scheme.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", raw, err)
+	}
+	if u.Scheme == "socks" {
+		u.Scheme = "socks5"
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", raw)
+	}
+	return u, nil
+}
+
+// loadPAC reads a PAC file from a URL or a path. A PAC file on the network
+// is fetched directly, as browsers do.
+func loadPAC(location string) (string, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{}}
+		resp, err := client.Get(location)
+		if err != nil {
+			return "", fmt.Errorf("error downloading PAC file: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("error downloading PAC file: status %d", resp.StatusCode)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("error downloading PAC file: %v", err)
+		}
+		return string(data), nil
+	}
+	data, err := ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+	if err != nil {
+		return "", fmt.Errorf("error reading PAC file: %v", err)
+	}
+	return string(data), nil
+}
+
+// resolveProxy turns the proxy options into settings, reading the system
+// settings and the PAC file as needed.
+func resolveProxy(ctx context.Context, cfg ProxyConfig, goos string) (*proxySettings, error) {
+	s := &proxySettings{source: "configuration"}
+	if cfg.PAC == "" && (cfg.URL == "" || cfg.URL == "system") {
+		system, source := systemProxy(ctx, goos)
+		s.source, s.system = "system settings", true
+		if source != "" {
+			s.source += " (" + source + ")"
+		}
+		cfg.URL, cfg.PAC = system.URL, system.PAC
+		cfg.NoProxy = strings.Trim(cfg.NoProxy+","+system.NoProxy, ",")
+	}
+
+	s.auth = strings.ToLower(cfg.Auth)
+	switch s.auth {
+	case "":
+		s.auth = "basic"
+	case "basic", "ntlm":
+	default:
+		return nil, fmt.Errorf("unsupported proxy authentication %q, expected basic or ntlm", cfg.Auth)
+	}
+	s.username, s.password, s.domain = cfg.Username, cfg.Password, cfg.Domain
+	if i := strings.Index(s.username, `\`); i >= 0 && s.domain == "" {
+		s.domain, s.username = s.username[:i], s.username[i+1:]
+	}
+	s.noProxy = splitList(cfg.NoProxy)
+
+	if cfg.PAC != "" {
+		src, err := loadPAC(cfg.PAC)
+		if err != nil {
+			return nil, err
+		}
+		if s.pac, err = parsePAC(src); err != nil {
+			return nil, fmt.Errorf("%v (set the proxy with -proxy instead)", err)
+		}
+	} else if cfg.URL != "" && cfg.URL != "direct" {
+		u, err := parseProxyURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		// Credentials in the URL are used unless they are set separately.
+		if u.User != nil {
+			if s.username == "" {
+				s.username = u.User.Username()
+				s.password, _ = u.User.Password()
+			}
+			u.User = nil
+		}
+		s.fixed = u
+	}
+	if s.username != "" && s.password == "" {
+		s.password = os.Getenv("PROXY_PASSWORD")
+	}
+	if s.auth == "ntlm" && s.username == "" {
+		return nil, fmt.Errorf("NTLM proxy authentication needs a username")
+	}
+	return s, nil
+}
+
+func (s *proxySettings) String() string {
+	switch {
+	case s.pac != nil:
+		return "PAC file from " + s.source
+	case s.fixed != nil:
+		desc := s.fixed.String() + " from " + s.source
+		if s.username != "" {
+			desc += ", " + s.auth + " authentication as " + s.username
+		}
+		return desc
+	}
+	return "no proxy (" + s.source + ")"
+}
+
+// bypass reports whether host is reached directly. With the system
+// settings, this machine itself always is; a configured proxy is also used
+// for it, so a local backend can be tested through a local proxy.
+func (s *proxySettings) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if s.system && (host == "localhost" || ip != nil && ip.IsLoopback()) {
+		return true
+	}
+	for _, pattern := range s.noProxy {
+		pattern = strings.ToLower(pattern)
+		_, network, cidrErr := net.ParseCIDR(pattern)
+		switch {
+		case pattern == "*":
+			return true
+		case pattern == "<local>":
+			if !strings.Contains(host, ".") {
+				return true
+			}
+		case strings.Contains(pattern, "*"):
+			if shExpMatch(host, pattern) {
+				return true
+			}
+		case cidrErr == nil:
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(pattern, "."):
+			if strings.HasSuffix(host, pattern) {
+				return true
+			}
+		default:
+			if host == pattern || strings.HasSuffix(host, "."+pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyFor returns the proxy for a URL, or nil to connect directly.
+func (s *proxySettings) proxyFor(target *url.URL) (*url.URL, error) {
+	if s.bypass(target.Hostname()) {
+		return nil, nil
+	}
+	if s.pac != nil {
+		result, err := s.pac.find(target.String(), target.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		return parsePACResult(result)
+	}
+	if s.fixed == nil {
+		return nil, nil
+	}
+	u := *s.fixed
+	return &u, nil
+}
+
+// transport returns an HTTP transport that uses the proxy. Basic
+// authentication is sent by the transport itself; NTLM needs a handshake on
+// the connection, so those proxies are reached through a CONNECT tunnel that
+// is authenticated before the transport uses it.
+func (s *proxySettings) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if s.auth == "ntlm" {
+		t.Proxy = nil
+		t.DialContext = s.dialNTLM
+		return t
+	}
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		u, err := s.proxyFor(r.URL)
+		if u != nil && s.username != "" {
+			u.User = url.UserPassword(s.username, s.password)
+		}
+		return u, err
+	}
+	return t
+}
+
+func proxyAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	ports := map[string]string{"http": "80", "https": "443", "socks5": "1080"}
+	return net.JoinHostPort(u.Hostname(), ports[u.Scheme])
+}
+
+func (s *proxySettings) dialNTLM(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	proxy, err := s.proxyFor(&url.URL{Scheme: "http", Host: addr, Path: "/"})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	if proxy.Scheme != "http" {
+		return nil, fmt.Errorf("NTLM authentication needs an http:// proxy, not %s", proxy.Scheme)
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyAddress(proxy))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to proxy %s: %v", proxy.Host, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r, err := ntlmConnect(conn, addr, s.username, s.password, s.domain)
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", proxy.Host, err)
+	}
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+// bufferedConn reads what was buffered while reading the proxy's response
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// proxyConnect asks the proxy for a tunnel to addr.
+func proxyConnect(conn net.Conn, r *bufio.Reader, addr, authorization string) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{"Proxy-Authorization": {authorization}, "Proxy-Connection": {"Keep-Alive"}},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("error sending CONNECT: %v", err)
+	}
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CONNECT response: %v", err)
+	}
+	// A refusal's body is read so the next request can use the connection.
+	// A tunnel has no body: what follows belongs to the destination.
+	if resp.StatusCode != http.StatusOK && (resp.ContentLength >= 0 || len(resp.TransferEncoding) > 0) {
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// ntlmConnect opens a tunnel to addr through a proxy that asks for NTLMv2
+// authentication. The whole handshake happens on one connection.
+func ntlmConnect(conn net.Conn, addr, username, password, domain string) (*bufio.Reader, error) {
+	negotiate, err := ntlmssp.NewNegotiateMessage(domain, "")
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := proxyConnect(conn, r, addr, "NTLM "+base64.StdEncoding.EncodeToString(negotiate))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return r, nil
+	}
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	var challenge []byte
+	for _, h := range resp.Header.Values("Proxy-Authenticate") {
+		if strings.HasPrefix(h, "NTLM ") {
+			if challenge, err = base64.StdEncoding.DecodeString(strings.TrimSpace(h[5:])); err != nil {
+				return nil, fmt.Errorf("invalid NTLM challenge: %v", err)
+			}
+		}
+	}
+	if challenge == nil {
+		return nil, fmt.Errorf("the proxy does not offer NTLM authentication")
+	}
+	msg, err := ntlmssp.ProcessChallenge(challenge, username, password, domain != "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid NTLM challenge: %v", err)
+	}
+	resp, err = proxyConnect(conn, r, addr, "NTLM "+base64.StdEncoding.EncodeToString(msg))
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return r, nil
+	case http.StatusProxyAuthRequired:
+		return nil, fmt.Errorf("the proxy rejected the NTLM credentials of %s", username)
+	}
+	return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+}
+
+// tunnelEnv returns the environment that makes the tunnel service use the
+// proxy. The tunnel only supports HTTP proxies with Basic authentication or
+// none; for others it is told why it will connect directly.
+func (s *proxySettings) tunnelEnv() ([]string, error) {
+	target, _ := url.Parse(netbirdManagementURL)
+	proxy, err := s.proxyFor(target)
+	if err != nil || proxy == nil {
+		return nil, err
+	}
+	if proxy.Scheme == "socks5" {
+		return nil, fmt.Errorf("the tunnel cannot use a SOCKS proxy and will connect directly")
+	}
+	if s.username != "" && s.auth == "basic" {
+		proxy.User = url.UserPassword(s.username, s.password)
+	}
+	env := []string{"HTTPS_PROXY=" + proxy.String(), "HTTP_PROXY=" + proxy.String()}
+	if len(s.noProxy) > 0 {
+		env = append(env, "NO_PROXY="+strings.Join(s.noProxy, ","))
+	}
+	if s.auth == "ntlm" {
+		return env, fmt.Errorf("the tunnel cannot log in to an NTLM proxy; it will only connect if the proxy lets it through without logging in")
+	}
+	return env, nil
+}
+
+// configureProxy sets up the API client and the tunnel's environment from
+// the proxy options.
+func configureProxy(ctx context.Context, cfg ProxyConfig) error {
+	s, err := resolveProxy(ctx, cfg, runtime.GOOS)
+	if err != nil {
+		return err
+	}
+	debugPrint("Proxy: %s\n", s)
+	proxyPassword = s.password
+	apiClient = &http.Client{Transport: s.transport()}
+	tunnelProxyEnv, err = s.tunnelEnv()
+	if err != nil {
+		fmt.Println("Warning:", err)
+	}
+	return nil
+}
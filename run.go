@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// scanOptions preset the answers to the interactive prompts. An unattended
+// run, such as a scheduled one, must not prompt and fails instead when an
+// answer is missing.
+type scanOptions struct {
+	Unattended bool
+	Email      string
+	Policy     string
+	ScanLAN    bool     // ask which local devices to scan as well
+	Targets    []string // local devices to scan, skipping the question
+	FailOn     int      // lowest severity that fails the run, 0 for none
+	Export     ExportOptions
+	UI         scanUI // asks the questions and shows progress, plain prompts when nil
+}
+
+// scanResult summarises a run for the scheduler, the agent and the history.
+type scanResult struct {
+	ExitCode     int
+	Err          error
+	ScanID       int
+	Policy       string
+	Credentialed bool
+	Final        scanProgress // last status seen before the scan ended
+	Started      time.Time
+	Duration     time.Duration // the whole run, tunnel and questions included
+	// ScanDuration is the time from starting the scan to its final status,
+	// zero when the scan did not finish.
+	ScanDuration time.Duration
+}
+
+// The steps that change this machine. The end-to-end tests replace them so
+// that a full run can be tested against the fake backend.
+var (
+	setUpTunnel    = installTunnel
+	tearDownTunnel = uninstallTunnel
+	prepareHost    = prepareThisHost
+)
+
+// apiSettleDelay gives the tunnel time to come up before the API is called.
+var apiSettleDelay = 5 * time.Second
+
+// prepareThisHost enables what a credentialed scan needs on this machine.
+// On Windows the settings it changes are saved first and the returned undo
+// action restores them.
+func prepareThisHost(lc *lifecycle) (*undoAction, error) {
+	if runtime.GOOS != "windows" {
+		if !isSSHRunning() {
+			return nil, errors.New("SSH is required for a credentialed scan")
+		}
+		return nil, nil
+	}
+	debugPrint("Storing current settings...\n")
+	var err error
+	settings, err = storeCurrentSettings()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the current settings: %v", err)
+	}
+	if dryRun {
+		planf("save the current settings to %s", filename)
+	} else if err := saveSettingsToFile(settings, filename); err != nil {
+		return nil, err
+	}
+	restoreSettings := lc.onUndo("restore Windows settings", func(ctx context.Context) error {
+		if dryRun {
+			return restoreOriginalSettings(settings)
+		}
+		return restore()
+	})
+	// Enable settings for Nessus scan
+	if err := setupWindowsNessus(settings); err != nil {
+		return restoreSettings, fmt.Errorf("error enabling the settings for the scan: %v", err)
+	}
+	return restoreSettings, nil
+}
+
+// runScan performs one full cycle: install the tunnel, start the scan, wait
+// for it, export the report and undo every change.
+func runScan(ctx context.Context, opts scanOptions) scanResult {
+	// Reset the state left over by a previous run of the scheduler or agent.
+	scanID = 0
+	tempBinaryPath, tempDir = "", ""
+	credentialedScan = false
+
+	result := scanResult{Started: time.Now()}
+	ui := opts.UI
+	if ui == nil {
+		ui = &plainUI{}
+	}
+
+	lc := &lifecycle{}
+	var email, username, password string
+	var policy ScanPolicy
+	var targets []string
+	var subnets []localSubnet
+	var restoreSettings *undoAction
+	var accepted []plannedChange
+	var consent *ConsentRecord
+
+	steps := []step{
+		{"consent", func(ctx context.Context) error {
+			// Unattended runs were agreed to when they were set up.
+			if opts.Unattended {
+				return nil
+			}
+			accepted = tunnelChanges(runtime.GOOS)
+			return askConsent(ctx, ui, accepted)
+		}},
+		{"install tunnel", func(ctx context.Context) error {
+			lc.onUndo("uninstall tunnel", func(ctx context.Context) error {
+				ui.Tunnel(false)
+				return tearDownTunnel()
+			})
+			if err := setUpTunnel(ctx); err != nil {
+				return withExitCode(exitTunnel, err)
+			}
+			ui.Tunnel(true)
+			return nil
+		}},
+		{"connect to API", func(ctx context.Context) error {
+			fmt.Println(tr("Attempting to connect to API"))
+			if err := sleepCtx(ctx, apiSettleDelay); err != nil {
+				return err
+			}
+			if !backend.Online(ctx) {
+				return withExitCode(exitAPIOffline, errors.New("API is offline"))
+			}
+			debugPrint("API is online.\n")
+			return nil
+		}},
+		{"scan options", func(ctx context.Context) error {
+			var err error
+			email = opts.Email
+			if email == "" {
+				if opts.Unattended {
+					return errors.New("no email address set for an unattended scan")
+				}
+				email, err = ui.Email(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := opts.Export.exportRequest(0, email); err != nil {
+				return err
+			}
+			if err := checkExport(backend, opts.Export); err != nil {
+				return err
+			}
+			fmt.Print(tr("Scan results will be sent to: %s\n", email))
+			policies := backend.Policies(ctx)
+			if opts.Unattended || opts.Policy != "" || len(policies) == 1 {
+				policy, err = choosePolicy(ctx, policies, opts.Policy)
+			} else {
+				policy, err = ui.Policy(ctx, policies)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Print(tr("Using scan policy: %s\n", policy.Name))
+			switch {
+			case opts.Unattended && policy.CredentialsRequired:
+				return fmt.Errorf("the %s policy needs credentials and cannot run unattended", policy.Name)
+			case opts.Unattended:
+				credentialedScan = false
+			case policy.CredentialsRequired:
+				fmt.Println(tr("This policy needs an account on this machine."))
+				credentialedScan = true
+			case policy.CredentialsSupported:
+				credentialedScan, err = ui.Credentialed(ctx)
+				if err != nil {
+					return err
+				}
+			default:
+				credentialedScan = false
+			}
+			if credentialedScan {
+				username, password, err = ui.Credentials(ctx)
+				if err != nil {
+					return err
+				}
+				debugPrint("Username: %s\n", username)
+			}
+			if len(opts.Targets) > 0 || opts.ScanLAN {
+				if err := checkRoutes(backend); err != nil {
+					return err
+				}
+				subnets, err = discoverSubnets()
+				if err != nil {
+					return err
+				}
+			}
+			if len(opts.Targets) > 0 {
+				for _, target := range opts.Targets {
+					if !onLocalSubnet(target, subnets) {
+						return fmt.Errorf("%s is not on one of the local networks", target)
+					}
+				}
+				targets = opts.Targets
+			} else if opts.ScanLAN && !opts.Unattended {
+				table, err := readNeighbourTable()
+				if err != nil {
+					debugPrint("%v\n", err)
+				}
+				targets, err = ui.LANTargets(ctx, subnets, discoverNeighbours(subnets, table))
+				if err != nil {
+					return err
+				}
+			}
+			if opts.Unattended {
+				return nil
+			}
+			// The changes for the chosen scan type are only known now.
+			changes := scanChanges(runtime.GOOS, credentialedScan, username, routeNetworks(targets, subnets))
+			if err := askConsent(ctx, ui, changes); err != nil {
+				return err
+			}
+			accepted = append(accepted, changes...)
+			ok, err := ui.Confirm(ctx, scanSummary{Email: email, Policy: policy, Credentialed: credentialedScan, Username: username, Targets: targets})
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("the scan was cancelled before it started")
+			}
+			key, err := loadSigningKey()
+			if err != nil {
+				return err
+			}
+			consent, err = newConsentRecord(email, policy.ID, accepted, key)
+			if err != nil {
+				return err
+			}
+			return saveConsentRecord(consent)
+		}},
+		{"prepare host", func(ctx context.Context) error {
+			if !credentialedScan {
+				fmt.Println(tr("Running a non-credentialed scan..."))
+				return nil
+			}
+			fmt.Println(tr("Running a credentialed/full scan..."))
+			var err error
+			restoreSettings, err = prepareHost(lc)
+			return err
+		}},
+		{"route LAN targets", func(ctx context.Context) error {
+			networks := routeNetworks(targets, subnets)
+			routes, ok := backend.(routeBackend)
+			if len(networks) == 0 || !ok {
+				return nil
+			}
+			// Withdrawn before the tunnel is uninstalled, while the API can
+			// still be reached through it.
+			lc.onUndo("withdraw routes", routes.DeleteRoutes)
+			return routes.AddRoutes(ctx, networks)
+		}},
+		{"start scan", func(ctx context.Context) error {
+			lc.onUndo("delete scan", func(ctx context.Context) error {
+				if scanID == 0 {
+					return nil
+				}
+				return backend.Delete(ctx, scanID)
+			})
+			var err error
+			scanID, err = backend.Start(ctx, scanRequest{Email: email, Policy: policy.ID, Username: username, Password: password, Targets: targets, Consent: consent})
+			if err != nil {
+				return err
+			}
+			notify(notificationEvent{Event: eventScanStarted, ScanID: scanID, Policy: policy.ID})
+			return nil
+		}},
+		{"wait for scan", func(ctx context.Context) error {
+			started := time.Now()
+			var err error
+			result.Final, err = statusLoop(ctx, backend, scanID, policy.ID, ui)
+			if err != nil {
+				return err
+			}
+			result.ScanDuration = time.Since(started)
+			fmt.Println("\n" + tr("Scan completed."))
+			return nil
+		}},
+		{"restore settings", func(ctx context.Context) error {
+			if restoreSettings == nil {
+				return nil
+			}
+			// Restore original settings
+			return lc.undoNow(restoreSettings)
+		}},
+		{"export report", func(ctx context.Context) error {
+			fmt.Println(tr("Exporting full report..."))
+			return backend.Export(ctx, scanID, email, opts.Export)
+		}},
+		{"compare findings", func(ctx context.Context) error {
+			// The comparison is a convenience; a failure here does not fail the scan.
+			if err := recordFindings(ctx, backend, scanID); err != nil && ctx.Err() == nil {
+				fmt.Println("Could not compare findings with the previous scan:", err)
+			}
+			return nil
+		}},
+	}
+
+	err := lc.run(ctx, steps)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("\n" + tr("Scan interrupted."))
+	} else if err != nil {
+		fmt.Println("\n"+tr("Error:"), err)
+	}
+	failed := lc.unwind()
+
+	result.ExitCode = exitCode(err, len(failed))
+	if result.ExitCode == exitOK && exceedsThreshold(result.Final, opts.FailOn) {
+		fmt.Print(tr("Findings of %s severity or higher remain.\n", severityName(opts.FailOn)))
+		result.ExitCode = exitFindings
+	}
+	result.Err = err
+	result.ScanID = scanID
+	result.Policy = policy.ID
+	result.Credentialed = credentialedScan
+	result.Duration = time.Since(result.Started)
+	if err := recordHistory(result); err != nil {
+		fmt.Println("Error recording scan history:", err)
+	}
+	notifyResult(result, failed)
+	ui.Done(result)
+	return result
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandRunner runs external commands. Every command the client runs goes
+// through runner so that the commands can be recorded, and replaced by a
+// fake in tests.
+type commandRunner interface {
+	// Run runs name with args and returns its combined standard output and
+	// standard error. A command that ran but failed returns a *commandError.
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// commandError is returned for a command that exited with a non-zero status.
+type commandError struct {
+	Command  string
+	ExitCode int
+	Output   string
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("%s exited with status %d", e.Command, e.ExitCode)
+}
+
+// formatCommand renders a command line, quoting arguments with spaces.
+func formatCommand(name string, args ...string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// execRunner runs commands on this machine.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = &commandError{Command: formatCommand(name, args...), ExitCode: exitErr.ExitCode(), Output: strings.TrimSpace(string(output))}
+	}
+	audit("command", formatCommand(name, args...), err)
+	return output, err
+}
+
+// commandRecord is one command run through a recordingRunner.
+type commandRecord struct {
+	Command  string
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// recordingRunner keeps a record of every command it runs.
+type recordingRunner struct {
+	next    commandRunner
+	mu      sync.Mutex
+	records []commandRecord
+}
+
+func (r *recordingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	line := formatCommand(name, args...)
+	debugPrint("Executing command: %s\n", line)
+	started := time.Now()
+	output, err := r.next.Run(ctx, name, args...)
+	if err != nil {
+		debugPrint("Command failed: %v\n%s\n", err, strings.TrimSpace(string(output)))
+	} else {
+		debugPrint("Command output: %s\n", strings.TrimSpace(string(output)))
+	}
+
+	r.mu.Lock()
+	r.records = append(r.records, commandRecord{Command: line, Started: started, Duration: time.Since(started), Err: err})
+	r.mu.Unlock()
+	return output, err
+}
+
+// Records returns the commands run so far, oldest first.
+func (r *recordingRunner) Records() []commandRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]commandRecord(nil), r.records...)
+}
+
+// commands records every command the client runs on this machine.
+var commandLog = &recordingRunner{next: execRunner{}}
+
+// runner is what the client runs commands with.
+var runner commandRunner = commandLog
+
+// runCommands runs each command in turn, stopping at the first failure.
+func runCommands(ctx context.Context, list [][]string) error {
+	for _, c := range list {
+		output, err := runner.Run(ctx, c[0], c[1:]...)
+		if err != nil {
+			return fmt.Errorf("error running %s: %v: %s", formatCommand(c[0], c[1:]...), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
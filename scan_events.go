@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// streamScanStatus follows the scan_events/{id} Server-Sent Events stream.
+// Every "status" event carries a scan_status response. The channel is closed
+// when the stream ends or fails, including when the server does not offer
+// it, and the caller then falls back to polling.
+func streamScanStatus(ctx context.Context, scanID int) <-chan scanProgress {
+	updates := make(chan scanProgress)
+	if dryRun {
+		close(updates)
+		return updates
+	}
+	go func() {
+		defer close(updates)
+		if err := readScanEvents(ctx, scanID, updates); err != nil && ctx.Err() == nil {
+			debugPrint("Status stream unavailable: %v\n", err)
+		}
+	}()
+	return updates
+}
+
+func readScanEvents(ctx context.Context, scanID int, updates chan<- scanProgress) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%sscan_events/%d", baseAPI, scanID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	// An event is a block of "field: value" lines ended by a blank line.
+	event, data := "", ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if (event == "" || event == "status") && data != "" {
+				var status ScanStatusResponse
+				if err := json.Unmarshal([]byte(data), &status); err != nil {
+					debugPrint("Skipping malformed status event: %v\n", err)
+				} else {
+					select {
+					case updates <- status.progress():
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, ":"):
+			// Comment, used by servers as a keep-alive.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != "" {
+				data += "\n"
+			}
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream ended")
+}
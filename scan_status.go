@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// scanState is the status of a scan as reported by Nessus in info.status.
+type scanState string
+
+const (
+	stateEmpty      scanState = "empty"
+	statePending    scanState = "pending"
+	stateRunning    scanState = "running"
+	stateProcessing scanState = "processing"
+	statePausing    scanState = "pausing"
+	statePaused     scanState = "paused"
+	stateResuming   scanState = "resuming"
+	stateStopping   scanState = "stopping"
+	stateCanceling  scanState = "canceling"
+	stateCanceled   scanState = "canceled"
+	stateAborted    scanState = "aborted"
+	stateCompleted  scanState = "completed"
+	stateImported   scanState = "imported"
+)
+
+// active reports whether the scan is still doing work on the server and has
+// to be stopped before it can be deleted.
+func (s scanState) active() bool {
+	switch s {
+	case statePending, stateRunning, stateProcessing, statePausing, statePaused, stateResuming:
+		return true
+	}
+	return false
+}
+
+// scanProgress is a single poll of scan_status for the scanned host.
+type scanProgress struct {
+	Critical   int
+	High       int
+	Medium     int
+	Low        int
+	Info       int
+	Current    int    // progress from 0 to 100
+	Percentage string // progress as displayed by the server
+	State      scanState
+	Phase      string // phase reported by the server, if any
+}
+
+// scanEndedError is returned when a scan finishes without completing.
+type scanEndedError struct {
+	State scanState
+}
+
+func (e *scanEndedError) Error() string {
+	return fmt.Sprintf("scan %s", e.State)
+}
+
+// scanAction is what statusLoop does after observing a state.
+type scanAction int
+
+const (
+	actionWait scanAction = iota
+	actionResume
+	actionDone
+	actionFail
+)
+
+// maxResumes is how many times in a row a paused scan is resumed before
+// giving up.
+const maxResumes = 3
+
+// resumeWaitPolls is how many polls a resume is given to take effect before
+// another one is sent.
+const resumeWaitPolls = 3
+
+// scanTracker holds what the state machine needs to remember between polls.
+type scanTracker struct {
+	resumes int // resumes sent since the scan was last seen running
+	waited  int // polls still paused since the last resume, 0 when none is pending
+}
+
+// observe returns the action for the latest state. The error is set when the
+// action is actionFail.
+func (t *scanTracker) observe(state scanState) (scanAction, error) {
+	if state != statePaused {
+		t.waited = 0
+		// A scan that is resuming has not run yet.
+		if state != stateResuming {
+			t.resumes = 0
+		}
+	}
+	switch state {
+	case stateCompleted, stateImported:
+		return actionDone, nil
+	case stateCanceled, stateAborted:
+		return actionFail, &scanEndedError{State: state}
+	case statePaused:
+		if t.waited > 0 && t.waited < resumeWaitPolls {
+			t.waited++
+			return actionWait, nil
+		}
+		if t.resumes >= maxResumes {
+			return actionFail, fmt.Errorf("scan is still paused after %d resume attempts", t.resumes)
+		}
+		t.resumes++
+		t.waited = 1
+		return actionResume, nil
+	case "", stateEmpty, statePending, stateRunning, stateProcessing, statePausing, stateResuming, stateStopping, stateCanceling:
+		return actionWait, nil
+	default:
+		debugPrint("Unknown scan status %q, waiting\n", state)
+		return actionWait, nil
+	}
+}
+
+// Poll intervals. Polls are spaced out while a scan is far from done and
+// come quicker near the end, so the report follows soon after completion.
+// While status events are pushed, polls only guard against a stalled stream.
+var (
+	scanPollSlow     = 30 * time.Second
+	scanPollFast     = 5 * time.Second
+	scanPollWithPush = 2 * time.Minute
+)
+
+// pollInterval returns how long to wait before polling again after p.
+func pollInterval(p scanProgress) time.Duration {
+	switch {
+	case p.State == stateProcessing || p.State == statePausing || p.State == stateResuming || p.State == stateStopping || p.State == stateCanceling:
+		return scanPollFast
+	case p.Current >= 90:
+		return scanPollFast
+	case p.Current >= 50:
+		return (scanPollSlow + scanPollFast) / 2
+	}
+	return scanPollSlow
+}
+
+// watchScan follows the scan until it reaches a final state, resuming it if
+// it is paused. Progress pushed on updates is used as it arrives; when
+// updates is nil or closed, poll is called after interval(last progress).
+// show is called with every progress received.
+func watchScan(ctx context.Context, updates <-chan scanProgress, interval func(scanProgress) time.Duration, poll func(context.Context) (scanProgress, error), resume func(context.Context) error, show func(scanProgress)) (scanProgress, error) {
+	var tracker scanTracker
+	progress, err := poll(ctx)
+	for {
+		if ctx.Err() != nil {
+			return progress, ctx.Err()
+		}
+		if err != nil {
+			return progress, fmt.Errorf("error getting scan status: %v", err)
+		}
+		show(progress)
+
+		action, failure := tracker.observe(progress.State)
+		switch action {
+		case actionDone:
+			return progress, nil
+		case actionFail:
+			return progress, failure
+		case actionResume:
+			fmt.Println("\n" + tr("Scan is paused, resuming..."))
+			if err := resume(ctx); err != nil {
+				return progress, fmt.Errorf("error resuming scan: %v", err)
+			}
+		}
+
+		wait := interval(progress)
+		if updates != nil && wait < scanPollWithPush {
+			wait = scanPollWithPush
+		}
+		var next scanProgress
+		next, err = nextProgress(ctx, updates, wait, poll)
+		if err == errUpdatesClosed {
+			debugPrint("Status stream closed, polling instead\n")
+			updates = nil
+			next, err = nextProgress(ctx, nil, interval(progress), poll)
+		}
+		if err == nil {
+			progress = next
+		}
+	}
+}
+
+var errUpdatesClosed = errors.New("status updates closed")
+
+// nextProgress waits for a pushed update or, after wait, polls.
+func nextProgress(ctx context.Context, updates <-chan scanProgress, wait time.Duration, poll func(context.Context) (scanProgress, error)) (scanProgress, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return scanProgress{}, ctx.Err()
+	case p, ok := <-updates:
+		if !ok {
+			return scanProgress{}, errUpdatesClosed
+		}
+		return p, nil
+	case <-timer.C:
+		return poll(ctx)
+	}
+}
+
+func resumeScan(ctx context.Context, scanID int) error {
+	return apiRequest(ctx, http.MethodPost, fmt.Sprintf("resume_scan/%d", scanID), nil, nil, nil, mutatePolicy)
+}
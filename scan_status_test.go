@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeScan replays a fixed sequence of states, repeating the last one.
+type fakeScan struct {
+	states  []scanState
+	polls   int
+	resumes int
+}
+
+func (f *fakeScan) poll(ctx context.Context) (scanProgress, error) {
+	i := f.polls
+	if i >= len(f.states) {
+		i = len(f.states) - 1
+	}
+	f.polls++
+	return scanProgress{State: f.states[i], Current: f.polls}, nil
+}
+
+func (f *fakeScan) resume(ctx context.Context) error {
+	f.resumes++
+	return nil
+}
+
+func TestWatchScan(t *testing.T) {
+	tests := []struct {
+		name        string
+		states      []scanState
+		wantPolls   int
+		wantResumes int
+		wantState   scanState // set when the scan should end with a scanEndedError
+		wantErr     bool
+	}{
+		{
+			name:      "completes",
+			states:    []scanState{statePending, stateRunning, stateRunning, stateCompleted},
+			wantPolls: 4,
+		},
+		{
+			name:      "processing is not finished",
+			states:    []scanState{stateRunning, stateProcessing, stateProcessing, stateCompleted},
+			wantPolls: 4,
+		},
+		{
+			name:      "no status yet",
+			states:    []scanState{"", stateEmpty, stateRunning, stateImported},
+			wantPolls: 4,
+		},
+		{
+			name:        "paused scan is resumed",
+			states:      []scanState{stateRunning, statePausing, statePaused, stateResuming, stateRunning, stateCompleted},
+			wantPolls:   6,
+			wantResumes: 1,
+		},
+		{
+			name:        "pending resume is not repeated",
+			states:      []scanState{stateRunning, statePaused, statePaused, stateResuming, stateRunning, stateCompleted},
+			wantPolls:   6,
+			wantResumes: 1,
+		},
+		{
+			name:        "paused again after running",
+			states:      []scanState{stateRunning, statePaused, stateRunning, statePaused, stateRunning, statePaused, stateRunning, statePaused, stateRunning, stateCompleted},
+			wantPolls:   10,
+			wantResumes: maxResumes + 1,
+		},
+		{
+			name:        "stuck paused",
+			states:      []scanState{stateRunning, statePaused},
+			wantPolls:   maxResumes*resumeWaitPolls + 2,
+			wantResumes: maxResumes,
+			wantErr:     true,
+		},
+		{
+			name:      "canceled",
+			states:    []scanState{stateRunning, stateCanceling, stateCanceled},
+			wantPolls: 3,
+			wantState: stateCanceled,
+			wantErr:   true,
+		},
+		{
+			name:      "aborted",
+			states:    []scanState{statePending, stateAborted},
+			wantPolls: 2,
+			wantState: stateAborted,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown states keep waiting",
+			states:    []scanState{"initializing", stateRunning, stateCompleted},
+			wantPolls: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeScan{states: tt.states}
+			shown := 0
+			final, err := watchScan(context.Background(), nil, noWait, fake.poll, fake.resume, func(scanProgress) { shown++ })
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("watchScan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fake.polls != tt.wantPolls || shown != tt.wantPolls {
+				t.Errorf("polls = %d, shown = %d, want %d", fake.polls, shown, tt.wantPolls)
+			}
+			if fake.resumes != tt.wantResumes {
+				t.Errorf("resumes = %d, want %d", fake.resumes, tt.wantResumes)
+			}
+			if final.Current != fake.polls {
+				t.Errorf("final progress is from poll %d, want %d", final.Current, fake.polls)
+			}
+
+			var ended *scanEndedError
+			if errors.As(err, &ended) != (tt.wantState != "") {
+				t.Fatalf("error %v, want scanEndedError: %v", err, tt.wantState != "")
+			}
+			if ended != nil && ended.State != tt.wantState {
+				t.Errorf("ended in %q, want %q", ended.State, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestWatchScanCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := &fakeScan{states: []scanState{stateRunning}}
+	poll := func(ctx context.Context) (scanProgress, error) {
+		if fake.polls == 2 {
+			cancel()
+		}
+		return fake.poll(ctx)
+	}
+
+	_, err := watchScan(ctx, nil, noWait, poll, fake.resume, func(scanProgress) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("watchScan() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWatchScanPushed(t *testing.T) {
+	old := scanPollWithPush
+	scanPollWithPush = time.Hour
+	defer func() { scanPollWithPush = old }()
+
+	updates := make(chan scanProgress, 3)
+	updates <- scanProgress{State: stateRunning, Current: 40}
+	updates <- scanProgress{State: stateRunning, Current: 95}
+	updates <- scanProgress{State: stateCompleted, Current: 100}
+	fake := &fakeScan{states: []scanState{statePending}}
+
+	final, err := watchScan(context.Background(), updates, noWait, fake.poll, fake.resume, func(scanProgress) {})
+	if err != nil {
+		t.Fatalf("watchScan() error = %v", err)
+	}
+	// Only the first status is polled, the rest is pushed.
+	if fake.polls != 1 || final.Current != 100 {
+		t.Errorf("polls = %d, final = %+v", fake.polls, final)
+	}
+}
+
+func TestWatchScanStreamClosed(t *testing.T) {
+	updates := make(chan scanProgress, 1)
+	updates <- scanProgress{State: stateRunning}
+	close(updates)
+	fake := &fakeScan{states: []scanState{statePending, stateRunning, stateCompleted}}
+
+	if _, err := watchScan(context.Background(), updates, noWait, fake.poll, fake.resume, func(scanProgress) {}); err != nil {
+		t.Fatalf("watchScan() error = %v", err)
+	}
+	if fake.polls != 3 {
+		t.Errorf("polls = %d, want 3 after the stream closed", fake.polls)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	if pollInterval(scanProgress{State: stateRunning, Current: 10}) <= pollInterval(scanProgress{State: stateRunning, Current: 95}) {
+		t.Error("polls do not speed up near completion")
+	}
+	if pollInterval(scanProgress{State: stateProcessing, Current: 100}) != scanPollFast {
+		t.Error("processing is not polled fast")
+	}
+}
+
+func TestStreamScanStatus(t *testing.T) {
+	useFakeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scan_events/7" || r.Header.Get("Accept") != "text/event-stream" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "event: status\ndata: {\"info\":{\"status\":\"running\"},\n")
+		fmt.Fprint(w, "data: \"hosts\":[{\"high\":2,\"scanprogresscurrent\":60}]}\n\n")
+		fmt.Fprint(w, "event: other\ndata: {}\n\n")
+		fmt.Fprint(w, "data: {\"info\":{\"status\":\"completed\"}}\n\n")
+	}))
+
+	var got []scanProgress
+	for p := range streamScanStatus(context.Background(), 7) {
+		got = append(got, p)
+	}
+	if len(got) != 2 || got[0].State != stateRunning || got[0].High != 2 || got[0].Current != 60 || got[1].State != stateCompleted {
+		t.Errorf("streamed %+v", got)
+	}
+
+	// Without the endpoint the stream closes straight away.
+	for range streamScanStatus(context.Background(), 8) {
+		t.Error("update from a missing stream")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		failed int
+		want   int
+	}{
+		{"completed", nil, 0, exitOK},
+		{"undo failed", nil, 1, exitRestore},
+		{"undo failed after an error", errors.New("boom"), 2, exitRestore},
+		{"api offline", wrapStep(withExitCode(exitAPIOffline, errors.New("API is offline"))), 0, exitAPIOffline},
+		{"canceled", &scanEndedError{State: stateCanceled}, 0, exitScanCanceled},
+		{"aborted in a step", wrapStep(&scanEndedError{State: stateAborted}), 0, exitScanAborted},
+		{"other error", errors.New("boom"), 0, exitFailure},
+		{"undo failed in a step", wrapStep(undoNowError()), 0, exitRestore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err, tt.failed); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusProgress(t *testing.T) {
+	var status ScanStatusResponse
+	body := `{"info": {"status": "running"}, "hosts": [
+		{"hostname": "100.64.0.7", "high": 1, "info": 10, "scanprogresscurrent": 80, "progress": "80%"},
+		{"hostname": "192.168.1.20", "critical": 1, "high": 2, "medium": 3, "scanprogresscurrent": 20, "progress": "20%"}]}`
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	// The findings of the LAN targets count as well as this machine's.
+	got := status.progress()
+	want := scanProgress{Critical: 1, High: 3, Medium: 3, Info: 10, Current: 50, Percentage: "50%", State: stateRunning}
+	if got != want {
+		t.Errorf("progress() = %+v, want %+v", got, want)
+	}
+
+	status.Hosts = status.Hosts[:1]
+	if got := status.progress(); got.Current != 80 || got.Percentage != "80%" || got.High != 1 {
+		t.Errorf("progress() of one host = %+v", got)
+	}
+}
+
+func TestExceedsThreshold(t *testing.T) {
+	counts := scanProgress{Critical: 0, High: 1, Medium: 3, Low: 0, Info: 12}
+	tests := []struct {
+		failOn string
+		want   bool
+	}{
+		{"", false},
+		{"critical", false},
+		{"high", true},
+		{"Medium", true},
+		{"low", true},
+	}
+	for _, tt := range tests {
+		threshold, err := parseFailOn(tt.failOn)
+		if err != nil {
+			t.Fatalf("parseFailOn(%q) error = %v", tt.failOn, err)
+		}
+		if got := exceedsThreshold(counts, threshold); got != tt.want {
+			t.Errorf("exceedsThreshold(%q) = %v, want %v", tt.failOn, got, tt.want)
+		}
+	}
+	if _, err := parseFailOn("severe"); err == nil {
+		t.Error("parseFailOn(\"severe\") succeeded, want error")
+	}
+}
+
+func noWait(scanProgress) time.Duration { return 0 }
+
+// wrapStep returns err the way lifecycle.run reports a failed step.
+func wrapStep(err error) error {
+	l := &lifecycle{}
+	return l.run(context.Background(), []step{{"wait for scan", func(context.Context) error { return err }}})
+}
+
+// undoNowError returns the error of an undo action run ahead of the unwind
+// that fails, as restoring the Windows settings can.
+func undoNowError() error {
+	l := &lifecycle{}
+	u := l.onUndo("restore Windows settings", func(context.Context) error { return errors.New("net stop RemoteRegistry exited with status 2") })
+	return l.undoNow(u)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ScheduleEntry is one recurring scan. Exactly one of Cron and Interval is set.
+type ScheduleEntry struct {
+	ID           int       `json:"id"`
+	Cron         string    `json:"cron,omitempty"`
+	Interval     string    `json:"interval,omitempty"`
+	Email        string    `json:"email"`
+	Policy       string    `json:"policy,omitempty"`
+	Targets      []string  `json:"targets,omitempty"`
+	Created      time.Time `json:"created"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastExitCode int       `json:"last_exit_code"`
+}
+
+// scheduleCheckInterval is how often the scheduler looks for due scans.
+const scheduleCheckInterval = time.Minute
+
+// minScheduleInterval stops a typo from scanning the machine continuously.
+const minScheduleInterval = time.Hour
+
+// parseInterval parses a Go duration, also accepting whole days such as "30d".
+func parseInterval(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", s)
+	}
+	return d, nil
+}
+
+// next returns when the entry should run after t.
+func (e ScheduleEntry) next(t time.Time) (time.Time, error) {
+	if e.Cron != "" {
+		c, err := parseCron(e.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return c.next(t), nil
+	}
+	d, err := parseInterval(e.Interval)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(d), nil
+}
+
+// due returns the next run time counted from the last run, or from creation
+// for a schedule that never ran. A time in the past means one or more runs
+// were missed, and they are caught up with a single run.
+func (e ScheduleEntry) due() (time.Time, error) {
+	from := e.LastRun
+	if from.IsZero() {
+		from = e.Created
+	}
+	return e.next(from)
+}
+
+func (e ScheduleEntry) describe() string {
+	if e.Cron != "" {
+		return "cron " + e.Cron
+	}
+	return "every " + e.Interval
+}
+
+func scheduleFile() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "schedule.json"), nil
+}
+
+func loadSchedule() ([]ScheduleEntry, error) {
+	path, err := scheduleFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading schedule: %v", err)
+	}
+	var entries []ScheduleEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing schedule %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+func saveSchedule(entries []ScheduleEntry) error {
+	path, err := scheduleFile()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling schedule: %v", err)
+	}
+	// Write to a temporary file first so a crash cannot leave half a schedule.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing schedule: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing schedule: %v", err)
+	}
+	return nil
+}
+
+// scheduleCommand implements the schedule subcommands and returns the exit code.
+func scheduleCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: schedule add|list|remove|run")
+		return exitFailure
+	}
+	if !isPrivileged() {
+		fmt.Println("Please run as root or administrator to manage scheduled scans.")
+		return exitPrivileges
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = scheduleAdd(args[1:])
+	case "list":
+		err = scheduleList()
+	case "remove":
+		err = scheduleRemove(args[1:])
+	case "run":
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handleInterrupts(cancel)
+		err = runScheduler(ctx)
+		if ctx.Err() != nil {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("unknown schedule command %q", args[0])
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func scheduleAdd(args []string) error {
+	fs := flag.NewFlagSet("schedule add", flag.ContinueOnError)
+	cronExpr := fs.String("cron", "", "Cron expression such as \"0 2 1 * *\" (02:00 on the 1st of every month)")
+	every := fs.String("every", "", "Interval between scans such as \"30d\" or \"168h\"")
+	email := fs.String("email", "", "Email address to receive the scan results")
+	policy := fs.String("policy", config.Policy, "ID of the scan policy")
+	targets := fs.String("targets", "", "Comma-separated local devices or networks to scan as well")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	entry := ScheduleEntry{Cron: *cronExpr, Interval: *every, Email: *email, Policy: *policy, Created: time.Now()}
+	if (entry.Cron == "") == (entry.Interval == "") {
+		return fmt.Errorf("exactly one of -cron and -every is required")
+	}
+	if entry.Cron != "" {
+		c, err := parseCron(entry.Cron)
+		if err != nil {
+			return err
+		}
+		if c.next(entry.Created).IsZero() {
+			return fmt.Errorf("cron expression %q never matches", entry.Cron)
+		}
+	} else {
+		d, err := parseInterval(entry.Interval)
+		if err != nil {
+			return err
+		}
+		if d < minScheduleInterval {
+			return fmt.Errorf("interval must be at least %s", minScheduleInterval)
+		}
+	}
+	if !emailRegex.MatchString(entry.Email) {
+		return fmt.Errorf("a valid -email is required")
+	}
+	for _, t := range strings.Split(*targets, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			entry.Targets = append(entry.Targets, t)
+		}
+	}
+
+	entries, err := loadSchedule()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.ID >= entry.ID {
+			entry.ID = e.ID + 1
+		}
+	}
+	if entry.ID == 0 {
+		entry.ID = 1
+	}
+	entries = append(entries, entry)
+	if err := saveSchedule(entries); err != nil {
+		return err
+	}
+
+	next, _ := entry.due()
+	fmt.Printf("Added scheduled scan %d, next run at %s\n", entry.ID, next.Format(time.RFC1123))
+	fmt.Println("Scheduled scans are run by the agent service; install it with \"agent install\" if it is not installed yet.")
+	return nil
+}
+
+func scheduleList() error {
+	entries, err := loadSchedule()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No scheduled scans.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSCHEDULE\tNEXT RUN\tLAST RUN\tEMAIL\tPOLICY\tTARGETS")
+	for _, e := range entries {
+		next := "invalid"
+		if t, err := e.due(); err == nil {
+			next = t.Format("2006-01-02 15:04")
+			if t.Before(time.Now()) {
+				next += " (missed)"
+			}
+		}
+		last := "never"
+		if !e.LastRun.IsZero() {
+			last = fmt.Sprintf("%s (exit %d)", e.LastRun.Format("2006-01-02 15:04"), e.LastExitCode)
+		}
+		policy := e.Policy
+		if policy == "" {
+			policy = "default"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.describe(), next, last, e.Email, policy, strings.Join(e.Targets, ","))
+	}
+	return w.Flush()
+}
+
+func scheduleRemove(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: schedule remove ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid schedule ID %q", args[0])
+	}
+
+	entries, err := loadSchedule()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if e.ID == id {
+			entries = append(entries[:i], entries[i+1:]...)
+			if err := saveSchedule(entries); err != nil {
+				return err
+			}
+			fmt.Printf("Removed scheduled scan %d\n", id)
+			return nil
+		}
+	}
+	return fmt.Errorf("no scheduled scan with ID %d", id)
+}
+
+// runScheduler runs due scans until ctx is cancelled. The schedule is read
+// again on every check so that add and remove take effect without a restart.
+func runScheduler(ctx context.Context) error {
+	fmt.Println("Scheduler started, waiting for scheduled scans...")
+	for {
+		if err := runDueSchedules(ctx, runScan); err != nil {
+			return err
+		}
+		if err := sleepCtx(ctx, scheduleCheckInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// runDueSchedules runs each scheduled scan that is due once with execute,
+// however many of its runs were missed, and records when it ran.
+func runDueSchedules(ctx context.Context, execute func(ctx context.Context, opts scanOptions) scanResult) error {
+	entries, err := loadSchedule()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var due []ScheduleEntry
+	for _, e := range entries {
+		t, err := e.due()
+		if err != nil {
+			fmt.Printf("Skipping scheduled scan %d: %v\n", e.ID, err)
+			continue
+		}
+		if !t.IsZero() && !t.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+
+	for _, e := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Printf("Running scheduled scan %d (%s)\n", e.ID, e.describe())
+		started := time.Now()
+		result := execute(ctx, scanOptions{Unattended: true, Email: e.Email, Policy: e.Policy, Targets: e.Targets, Export: config.Export})
+		fmt.Printf("Scheduled scan %d finished with exit code %d\n", e.ID, result.ExitCode)
+		if err := recordScheduledRun(e.ID, started, result.ExitCode); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+func recordScheduledRun(id int, started time.Time, code int) error {
+	entries, err := loadSchedule()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].ID == id {
+			entries[i].LastRun = started
+			entries[i].LastExitCode = code
+		}
+	}
+	return saveSchedule(entries)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func useScheduleDir(t *testing.T) {
+	t.Helper()
+	old := dataDirOverride
+	dataDirOverride = t.TempDir()
+	t.Cleanup(func() { dataDirOverride = old })
+}
+
+func TestScheduleDue(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	tests := []struct {
+		name  string
+		entry ScheduleEntry
+		want  time.Time
+	}{
+		{"never ran", ScheduleEntry{Interval: "24h", Created: now}, now.Add(24 * time.Hour)},
+		{"ran", ScheduleEntry{Interval: "30d", Created: now.AddDate(0, -6, 0), LastRun: now}, now.Add(30 * 24 * time.Hour)},
+		// Three runs were missed; the first of them is due.
+		{"missed", ScheduleEntry{Interval: "24h", Created: now.AddDate(0, -1, 0), LastRun: now.Add(-72 * time.Hour)}, now.Add(-48 * time.Hour)},
+		{"cron", ScheduleEntry{Cron: "0 * * * *", Created: now.Add(-90 * time.Minute), LastRun: now.Add(-90 * time.Minute)}, now.Add(-90 * time.Minute).Truncate(time.Hour).Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		if got, err := tt.entry.due(); err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: due() = %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := (ScheduleEntry{Interval: "often"}).due(); err == nil {
+		t.Error("due() accepted an invalid interval")
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	useScheduleDir(t)
+	now := time.Now()
+	entries := []ScheduleEntry{
+		{ID: 2, Interval: "24h", Email: "b@example.com", Created: now.AddDate(0, -1, 0), LastRun: now.Add(-72 * time.Hour)},
+		{ID: 1, Cron: "0 0 1 * *", Email: "a@example.com", Policy: "basic", Created: now.AddDate(0, -2, 0)},
+		{ID: 3, Interval: "24h", Email: "c@example.com", Created: now, LastRun: now.Add(-time.Hour), LastExitCode: exitFailure},
+	}
+	if err := saveSchedule(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []scanOptions
+	execute := func(ctx context.Context, opts scanOptions) scanResult {
+		ran = append(ran, opts)
+		return scanResult{ExitCode: exitScanAborted}
+	}
+	if err := runDueSchedules(context.Background(), execute); err != nil {
+		t.Fatal(err)
+	}
+	// Each due scan runs once however many runs it missed, in ID order.
+	if len(ran) != 2 || ran[0].Email != "a@example.com" || ran[0].Policy != "basic" || ran[1].Email != "b@example.com" || !ran[0].Unattended {
+		t.Fatalf("ran %+v", ran)
+	}
+
+	saved, err := loadSchedule()
+	if err != nil || len(saved) != 3 {
+		t.Fatalf("loadSchedule() = %+v, %v", saved, err)
+	}
+	for _, e := range saved {
+		switch e.ID {
+		case 1, 2:
+			if e.LastRun.Before(now) || e.LastExitCode != exitScanAborted {
+				t.Errorf("scan %d recorded as %s, exit %d", e.ID, e.LastRun, e.LastExitCode)
+			}
+		case 3:
+			if !e.LastRun.Equal(entries[2].LastRun) || e.LastExitCode != exitFailure {
+				t.Errorf("scan 3, not due, was changed: %+v", e)
+			}
+		}
+	}
+
+	// The runs are remembered, so nothing is due any more.
+	ran = nil
+	if err := runDueSchedules(context.Background(), execute); err != nil || len(ran) != 0 {
+		t.Errorf("second check ran %+v, %v", ran, err)
+	}
+}
+
+func TestLoadScheduleMissing(t *testing.T) {
+	useScheduleDir(t)
+	if entries, err := loadSchedule(); err != nil || entries != nil {
+		t.Errorf("loadSchedule() = %+v, %v", entries, err)
+	}
+}
+
+func TestAgentRunsSchedule(t *testing.T) {
+	useScheduleDir(t)
+	useFakeAPI(t, &fakeJobServer{results: map[string]JobResult{}})
+	if err := saveSchedule([]ScheduleEntry{{ID: 1, Interval: "24h", Email: "it@example.com", Created: time.Now().AddDate(0, 0, -2)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran []scanOptions
+	a := &agent{id: "agent-1", schedule: true, execute: func(ctx context.Context, opts scanOptions) scanResult {
+		ran = append(ran, opts)
+		cancel()
+		return scanResult{}
+	}}
+	done := make(chan error, 1)
+	go func() { done <- a.run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the agent did not run the scheduled scan")
+	}
+	if len(ran) != 1 || ran[0].Email != "it@example.com" {
+		t.Errorf("ran %+v", ran)
+	}
+}
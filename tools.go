@@ -0,0 +1,6 @@
+//go:build tools
+
+package main
+
+// The tools the build runs with go run, kept in go.mod by this import.
+import _ "github.com/tc-hib/go-winres"
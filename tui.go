@@ -0,0 +1,693 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// keyCode identifies a key press read by the terminal UI.
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyEnter
+	keyTab
+	keyBackspace
+	keyEscape
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyCtrlC
+)
+
+type key struct {
+	Code keyCode
+	Rune rune // the character typed, for keyRune
+}
+
+// readKey reads one key press from a terminal in raw mode.
+func readKey(r *bufio.Reader) (key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+	switch c {
+	case '\r', '\n':
+		return key{Code: keyEnter}, nil
+	case '\t':
+		return key{Code: keyTab}, nil
+	case 127, '\b':
+		return key{Code: keyBackspace}, nil
+	case 3:
+		return key{Code: keyCtrlC}, nil
+	case 27:
+		// A lone escape, or the start of an arrow key sequence, which the
+		// terminal sends in one piece.
+		if r.Buffered() == 0 {
+			return key{Code: keyEscape}, nil
+		}
+		if next, _ := r.Peek(1); next[0] != '[' && next[0] != 'O' {
+			return key{Code: keyEscape}, nil
+		}
+		r.ReadByte()
+		final, err := r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		switch final {
+		case 'A':
+			return key{Code: keyUp}, nil
+		case 'B':
+			return key{Code: keyDown}, nil
+		case 'C':
+			return key{Code: keyRight}, nil
+		case 'D':
+			return key{Code: keyLeft}, nil
+		}
+		return key{Code: keyEscape}, nil
+	}
+	return key{Code: keyRune, Rune: c}, nil
+}
+
+// isInteractiveTerminal reports whether the full-screen interface can be
+// used, that is when both standard input and output are a capable terminal.
+func isInteractiveTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd())) && terminal.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("TERM") != "dumb"
+}
+
+// ANSI sequences used to draw the screens.
+const (
+	ansiReset    = "\x1b[0m"
+	ansiBold     = "\x1b[1m"
+	ansiDim      = "\x1b[2m"
+	ansiReverse  = "\x1b[7m"
+	ansiRed      = "\x1b[31m"
+	ansiGreen    = "\x1b[32m"
+	ansiYellow   = "\x1b[33m"
+	ansiHome     = "\x1b[H"
+	ansiClearEOL = "\x1b[K"
+	ansiClearEOS = "\x1b[J"
+)
+
+// maxLogLines bounds the messages kept for the log pane.
+const maxLogLines = 500
+
+// terminalUI is a full-screen wizard and dashboard. While it is shown,
+// everything the rest of the program prints goes to a log pane below the
+// current screen instead of the terminal.
+type terminalUI struct {
+	cancel context.CancelFunc
+	out    io.Writer
+	size   func() (width, height int)
+	keys   chan key
+
+	restoreTerminal func()
+	closeOnce       sync.Once
+	logDone         chan struct{}
+
+	mu          sync.Mutex
+	title       string
+	page        []string // the wizard page shown, nil for the dashboard
+	hints       string
+	log         []string
+	tunnel      bool
+	scanID      int
+	progress    scanProgress
+	eta         string
+	summary     scanSummary
+	interrupted bool
+}
+
+// newTerminalUI switches the terminal to raw mode and the alternate screen.
+// The first Ctrl+C calls cancel, the second exits without undoing anything,
+// as with handleInterrupts. Close must be called before the program exits.
+func newTerminalUI(cancel context.CancelFunc) (*terminalUI, error) {
+	out := os.Stdout
+	restoreConsole, err := enableVirtualTerminal(out)
+	if err != nil {
+		return nil, fmt.Errorf("error enabling terminal sequences: %v", err)
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		restoreConsole()
+		return nil, fmt.Errorf("error switching the terminal to raw mode: %v", err)
+	}
+	logReader, logWriter, err := os.Pipe()
+	if err != nil {
+		terminal.Restore(fd, state)
+		restoreConsole()
+		return nil, fmt.Errorf("error creating log pipe: %v", err)
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = logWriter, logWriter
+	log.SetOutput(logWriter)
+
+	t := &terminalUI{
+		cancel: cancel,
+		out:    out,
+		size: func() (int, int) {
+			width, height, err := terminal.GetSize(int(out.Fd()))
+			if err != nil || width <= 0 || height <= 0 {
+				return 80, 24
+			}
+			return width, height
+		},
+		keys:    make(chan key, 16),
+		logDone: make(chan struct{}),
+	}
+	t.restoreTerminal = func() {
+		log.SetOutput(stderr)
+		os.Stdout, os.Stderr = stdout, stderr
+		logWriter.Close()
+		<-t.logDone
+		fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+		terminal.Restore(fd, state)
+		restoreConsole()
+	}
+
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	go t.readLog(logReader)
+	go t.readKeys(stdin)
+	t.update(func() {})
+	return t, nil
+}
+
+// Close puts the terminal back as it was and prints the log, so that the
+// messages remain in the terminal's scrollback.
+func (t *terminalUI) Close() {
+	t.closeOnce.Do(func() {
+		t.restoreTerminal()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, line := range t.log {
+			fmt.Println(line)
+		}
+	})
+}
+
+func (t *terminalUI) readLog(r io.ReadCloser) {
+	defer close(t.logDone)
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Keep only what a terminal would show after a carriage return.
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		t.update(func() {
+			t.log = append(t.log, line)
+			if len(t.log) > maxLogLines {
+				t.log = t.log[len(t.log)-maxLogLines:]
+			}
+		})
+	}
+}
+
+func (t *terminalUI) readKeys(r *bufio.Reader) {
+	for {
+		k, err := readKey(r)
+		if err != nil {
+			return
+		}
+		if k.Code == keyCtrlC {
+			t.interrupt()
+			continue
+		}
+		select {
+		case t.keys <- k:
+		default:
+		}
+	}
+}
+
+// interrupt handles Ctrl+C, which raw mode delivers as a key instead of a
+// signal.
+func (t *terminalUI) interrupt() {
+	t.mu.Lock()
+	second := t.interrupted
+	t.interrupted = true
+	t.mu.Unlock()
+	if second {
+		t.Close()
+		fmt.Println("\nExiting without restoring settings.")
+		os.Exit(1)
+	}
+	fmt.Println("Received an interrupt, restoring settings and exiting...")
+	fmt.Println("Press Ctrl+C again to exit immediately without restoring.")
+	t.cancel()
+}
+
+// nextKey waits for a key press.
+func (t *terminalUI) nextKey(ctx context.Context) (key, error) {
+	select {
+	case <-ctx.Done():
+		return key{}, ctx.Err()
+	case k := <-t.keys:
+		return k, nil
+	}
+}
+
+// show replaces the wizard page, or shows the dashboard when page is nil.
+func (t *terminalUI) show(title string, page []string, hints string) {
+	t.update(func() {
+		t.title, t.page, t.hints = title, page, hints
+	})
+}
+
+// update changes the state under the lock and redraws the screen.
+func (t *terminalUI) update(change func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change()
+	t.draw()
+}
+
+func (t *terminalUI) draw() {
+	width, height := t.size()
+	lines := []string{ansiReverse + ansiBold + pad(" Nessus Remote Scanner  "+t.title, width) + ansiReset, ""}
+	if t.page != nil {
+		lines = append(lines, t.page...)
+	} else {
+		lines = append(lines, t.dashboard(width)...)
+	}
+	lines = append(lines, "")
+
+	// The log pane takes whatever room is left above the hints.
+	room := height - len(lines) - 2
+	if room > 0 {
+		lines = append(lines, ansiDim+strings.Repeat("─", width)+ansiReset)
+		room--
+		start := len(t.log) - room
+		if start < 0 {
+			start = 0
+		}
+		for _, line := range t.log[start:] {
+			lines = append(lines, ansiDim+line+ansiReset)
+		}
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	lines = append(lines, ansiReverse+pad(" "+t.hints, width)+ansiReset)
+
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fit(line, width))
+		b.WriteString(ansiReset + ansiClearEOL)
+	}
+	b.WriteString(ansiClearEOS)
+	io.WriteString(t.out, b.String())
+}
+
+func (t *terminalUI) dashboard(width int) []string {
+	tunnel := ansiRed + tr("not connected") + ansiReset
+	if t.tunnel {
+		tunnel = ansiGreen + tr("connected") + ansiReset
+	}
+	lines := []string{"  " + tr("Tunnel: %s", tunnel), ""}
+	if t.scanID == 0 {
+		return append(lines, "  "+tr("Waiting for the scan to start..."))
+	}
+	p := t.progress
+	status := fmt.Sprintf("  %s  %s  %s", tr("Scan %d", t.scanID), ansiBold+tr(scanPhase(p))+ansiReset, t.eta)
+	barWidth := width - 14
+	if barWidth > 60 {
+		barWidth = 60
+	}
+	return append(lines, status, "", "  "+progressBar(p.Current, barWidth)+fmt.Sprintf(" %3d%%", p.Current), "", "  "+severityTiles(p))
+}
+
+// progressBar draws a bar of the given width for a percentage.
+func progressBar(percent, width int) string {
+	if width < 10 {
+		width = 10
+	}
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	done := percent * width / 100
+	return ansiGreen + strings.Repeat("█", done) + ansiDim + strings.Repeat("░", width-done) + ansiReset
+}
+
+// severityTiles shows the finding counts as coloured tiles.
+func severityTiles(p scanProgress) string {
+	tiles := []struct {
+		colour string
+		name   string
+		count  int
+	}{
+		{"\x1b[41;97m", "Critical", p.Critical},
+		{"\x1b[43;30m", "High", p.High},
+		{"\x1b[103;30m", "Medium", p.Medium},
+		{"\x1b[42;30m", "Low", p.Low},
+		{"\x1b[44;97m", "Info", p.Info},
+	}
+	var b strings.Builder
+	for _, tile := range tiles {
+		fmt.Fprintf(&b, "%s %s %d %s ", tile.colour, tr(tile.name), tile.count, ansiReset)
+	}
+	return b.String()
+}
+
+// fit cuts a line to the width of the terminal, not counting the escape
+// sequences in it.
+func fit(line string, width int) string {
+	visible := 0
+	inEscape := false
+	for i, r := range line {
+		switch {
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		case r == 0x1b:
+			inEscape = true
+		default:
+			if visible == width {
+				return line[:i]
+			}
+			visible++
+		}
+	}
+	return line
+}
+
+// pad fills a line with spaces up to the width, for the title and hint bars.
+func pad(line string, width int) string {
+	if n := width - utf8.RuneCountInString(line); n > 0 {
+		return line + strings.Repeat(" ", n)
+	}
+	return line
+}
+
+// editText applies a key press to a text field.
+func editText(value string, k key) string {
+	switch {
+	case k.Code == keyBackspace && value != "":
+		_, size := utf8.DecodeLastRuneInString(value)
+		return value[:len(value)-size]
+	case k.Code == keyRune && k.Rune >= ' ':
+		return value + string(k.Rune)
+	}
+	return value
+}
+
+// field draws a text field, highlighted when it has the focus.
+func field(label, value string, focused bool) string {
+	if focused {
+		return fmt.Sprintf("  %-12s %s%s%s", label, ansiReverse, value+" ", ansiReset)
+	}
+	return fmt.Sprintf("  %-12s %s", label, value)
+}
+
+// choice draws one entry of a list, marked when selected.
+func choice(text string, selected bool) string {
+	if selected {
+		return "  " + ansiReverse + "> " + text + " " + ansiReset
+	}
+	return "    " + text
+}
+
+// askYesNo shows a page with a question answered with Yes or No, starting
+// with answer selected.
+func (t *terminalUI) askYesNo(ctx context.Context, title string, page []string, question string, answer bool) (bool, error) {
+	for {
+		yes, no := choice(tr("Yes"), answer), choice(tr("No"), !answer)
+		t.show(title, append(append([]string{}, page...), "", "  "+question, "", yes, no), tr("↑/↓ choose · Enter confirm · Ctrl+C quit"))
+		k, err := t.nextKey(ctx)
+		if err != nil {
+			return false, err
+		}
+		switch k.Code {
+		case keyUp, keyDown, keyLeft, keyRight, keyTab:
+			answer = !answer
+		case keyEnter:
+			return answer, nil
+		case keyRune:
+			if a, ok := parseYesNo(string(k.Rune)); ok {
+				return a, nil
+			}
+		}
+	}
+}
+
+// Consent starts on No, so that the changes are only accepted on purpose.
+func (t *terminalUI) Consent(ctx context.Context, changes []plannedChange) (bool, error) {
+	page := []string{"  " + tr("The following changes will be made to this machine and undone when the scan ends:"), ""}
+	for _, c := range changes {
+		page = append(page, "   • "+c.Description)
+		for _, command := range c.Commands {
+			page = append(page, "       "+ansiDim+command+ansiReset)
+		}
+	}
+	return t.askYesNo(ctx, tr("Changes to this machine"), page, tr("Do you accept these changes?"), false)
+}
+
+func (t *terminalUI) Email(ctx context.Context) (string, error) {
+	var email, problem string
+	for {
+		page := []string{"  " + tr("The scan results will be sent to this address."), "", field(tr("Email"), email, true)}
+		if problem != "" {
+			page = append(page, "", "  "+ansiRed+problem+ansiReset)
+		}
+		t.show(tr("Email address"), page, tr("Enter confirm · Ctrl+C quit"))
+		k, err := t.nextKey(ctx)
+		if err != nil {
+			return "", err
+		}
+		if k.Code != keyEnter {
+			email = editText(email, k)
+			continue
+		}
+		if emailRegex.MatchString(strings.TrimSpace(email)) {
+			return strings.TrimSpace(email), nil
+		}
+		problem = strings.TrimSpace(tr("Invalid email address. Please enter a valid email address."))
+	}
+}
+
+func (t *terminalUI) Policy(ctx context.Context, policies []ScanPolicy) (ScanPolicy, error) {
+	selected := 0
+	for {
+		page := []string{"  " + tr("Available scan policies:"), ""}
+		for i, p := range policies {
+			page = append(page, choice(p.Name, i == selected))
+			page = append(page, "      "+ansiDim+p.Description+ansiReset)
+		}
+		t.show(tr("Scan type"), page, tr("↑/↓ choose · Enter confirm · Ctrl+C quit"))
+		k, err := t.nextKey(ctx)
+		if err != nil {
+			return ScanPolicy{}, err
+		}
+		switch k.Code {
+		case keyUp:
+			selected = (selected + len(policies) - 1) % len(policies)
+		case keyDown, keyTab:
+			selected = (selected + 1) % len(policies)
+		case keyEnter:
+			return policies[selected], nil
+		}
+	}
+}
+
+func (t *terminalUI) Credentialed(ctx context.Context) (bool, error) {
+	page := []string{
+		"  " + tr("A credentialed scan logs in to this machine and finds more issues,"),
+		"  " + tr("such as missing updates. It needs an account on this machine."),
+	}
+	return t.askYesNo(ctx, tr("Scan type"), page, tr("Do you want to run a credentialed/full scan?"), true)
+}
+
+func (t *terminalUI) Credentials(ctx context.Context) (string, string, error) {
+	var username, password string
+	focus, reveal := 0, false
+	for {
+		shown := strings.Repeat("*", utf8.RuneCountInString(password))
+		if reveal {
+			shown = password
+		}
+		box := "[ ]"
+		if reveal {
+			box = "[x]"
+		}
+		toggle := "  " + box + " " + tr("Show password")
+		if focus == 2 {
+			toggle = "  " + ansiReverse + box + " " + tr("Show password") + ansiReset
+		}
+		page := []string{
+			"  " + tr("Enter an account on this machine for the scanner to log in with."),
+			"",
+			field(tr("Username"), username, focus == 0),
+			field(tr("Password"), shown, focus == 1),
+			"",
+			toggle,
+		}
+		t.show(tr("Credentials"), page, tr("Tab next field · Space show/hide · Enter confirm · Ctrl+C quit"))
+		k, err := t.nextKey(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		switch {
+		case k.Code == keyTab || k.Code == keyDown:
+			focus = (focus + 1) % 3
+		case k.Code == keyUp:
+			focus = (focus + 2) % 3
+		case k.Code == keyEnter && username != "" && password != "":
+			return username, password, nil
+		case k.Code == keyEnter && username == "":
+			focus = 0
+		case k.Code == keyEnter:
+			focus = 1
+		case focus == 2 && k.Code == keyRune && k.Rune == ' ':
+			reveal = !reveal
+		case focus == 0:
+			username = editText(username, k)
+		case focus == 1:
+			password = editText(password, k)
+		}
+	}
+}
+
+func (t *terminalUI) LANTargets(ctx context.Context, subnets []localSubnet, neighbours []lanNeighbour) ([]string, error) {
+	if len(subnets) == 0 {
+		fmt.Println(tr("No local networks found, only this machine will be scanned."))
+		return nil, nil
+	}
+	choices := lanChoices(subnets, neighbours)
+	checked := make([]bool, len(choices))
+	selected := 0
+	for {
+		page := []string{"  " + tr("Other devices on your network that can be scanned:"), ""}
+		for i, c := range choices {
+			box := "[ ] "
+			if checked[i] {
+				box = "[x] "
+			}
+			page = append(page, choice(box+c.Label, i == selected))
+		}
+		t.show(tr("Devices to scan"), page, tr("↑/↓ move · Space select · a all devices · Enter confirm · Ctrl+C quit"))
+		k, err := t.nextKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case k.Code == keyUp:
+			selected = (selected + len(choices) - 1) % len(choices)
+		case k.Code == keyDown || k.Code == keyTab:
+			selected = (selected + 1) % len(choices)
+		case k.Code == keyRune && k.Rune == ' ':
+			checked[selected] = !checked[selected]
+		case k.Code == keyRune && k.Rune == 'a':
+			for i := range neighbours {
+				checked[i] = true
+			}
+		case k.Code == keyEnter:
+			var targets []string
+			for i, c := range choices {
+				if checked[i] {
+					targets = append(targets, c.Target)
+				}
+			}
+			return targets, nil
+		}
+	}
+}
+
+func (t *terminalUI) Confirm(ctx context.Context, summary scanSummary) (bool, error) {
+	t.mu.Lock()
+	t.summary = summary
+	t.mu.Unlock()
+
+	scanType := tr("non-credentialed")
+	if summary.Credentialed {
+		scanType = tr("credentialed, as %s", summary.Username)
+	}
+	targets := tr("this machine")
+	if len(summary.Targets) > 0 {
+		targets += ", " + strings.Join(summary.Targets, ", ")
+	}
+	page := []string{
+		fmt.Sprintf("  %-12s %s", tr("Email"), summary.Email),
+		fmt.Sprintf("  %-12s %s", tr("Policy"), summary.Policy.Name),
+		fmt.Sprintf("  %-12s %s", tr("Scan type"), scanType),
+		fmt.Sprintf("  %-12s %s", tr("Targets"), targets),
+	}
+	ok, err := t.askYesNo(ctx, tr("Summary"), page, tr("Start the scan?"), true)
+	if ok && err == nil {
+		t.show(tr("Scan"), nil, tr("Ctrl+C stop the scan and undo every change"))
+	}
+	return ok, err
+}
+
+func (t *terminalUI) Tunnel(up bool) {
+	t.update(func() { t.tunnel = up })
+}
+
+func (t *terminalUI) Progress(scanID int, p scanProgress, eta string) {
+	t.update(func() {
+		t.scanID, t.progress, t.eta = scanID, p, eta
+		t.title, t.page = tr("Scan"), nil
+	})
+}
+
+// Done shows the results until Enter is pressed.
+func (t *terminalUI) Done(result scanResult) {
+	t.mu.Lock()
+	summary := t.summary
+	t.mu.Unlock()
+
+	title := tr("Scan completed.")
+	switch {
+	case errors.Is(result.Err, context.Canceled):
+		title = tr("Scan interrupted.")
+	case result.Err != nil:
+		title = tr("Scan failed")
+	}
+	page := []string{
+		fmt.Sprintf("  %-12s %d", tr("Scan ID"), result.ScanID),
+		fmt.Sprintf("  %-12s %s", tr("Duration"), result.Duration.Round(time.Second)),
+		fmt.Sprintf("  %-12s %d", tr("Exit code"), result.ExitCode),
+		"",
+		"  " + severityTiles(result.Final),
+		"",
+	}
+	if result.Err != nil {
+		page = append(page, "  "+ansiRed+tr("Error:")+" "+result.Err.Error()+ansiReset)
+	} else if summary.Email != "" {
+		page = append(page, "  "+tr("The full report will be sent to %s.", summary.Email))
+	}
+	if result.ExitCode == exitRestore {
+		page = append(page, "  "+ansiYellow+tr("Some changes could not be undone, see the messages below.")+ansiReset)
+	} else {
+		page = append(page, "  "+tr("Every change made to this machine has been undone."))
+	}
+	t.show(title, page, tr("Enter exit"))
+	for {
+		if k, _ := t.nextKey(context.Background()); k.Code == keyEnter || k.Code == keyEscape {
+			return
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableVirtualTerminal is a no-op: terminals outside Windows understand
+// ANSI sequences already.
+func enableVirtualTerminal(out *os.File) (func(), error) {
+	return func() {}, nil
+}
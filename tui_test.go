@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("aé\r\t\x7f\x1b[A\x1b[D\x03"))
+	want := []key{
+		{Code: keyRune, Rune: 'a'},
+		{Code: keyRune, Rune: 'é'},
+		{Code: keyEnter},
+		{Code: keyTab},
+		{Code: keyBackspace},
+		{Code: keyUp},
+		{Code: keyLeft},
+		{Code: keyCtrlC},
+	}
+	for i, w := range want {
+		got, err := readKey(r)
+		if err != nil {
+			t.Fatalf("readKey() #%d: %v", i, err)
+		}
+		if got != w {
+			t.Errorf("readKey() #%d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+// scriptedUI returns a terminal UI that draws nowhere and reads the keys
+// typed by text, where \r is Enter and \t is Tab.
+func scriptedUI(text string) *terminalUI {
+	t := &terminalUI{
+		out:  ioutil.Discard,
+		size: func() (int, int) { return 80, 24 },
+		keys: make(chan key, len(text)),
+	}
+	r := bufio.NewReader(strings.NewReader(text))
+	for {
+		k, err := readKey(r)
+		if err != nil {
+			return t
+		}
+		t.keys <- k
+	}
+}
+
+func TestTerminalUIEmail(t *testing.T) {
+	ui := scriptedUI("nobody\rx\x7f@example.com\r")
+	got, err := ui.Email(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "nobody@example.com" {
+		t.Errorf("Email() = %q, want nobody@example.com", got)
+	}
+}
+
+func TestTerminalUICredentials(t *testing.T) {
+	// Enter with the password still empty moves to it, and the space toggles
+	// showing the password.
+	ui := scriptedUI("admin\rs3cret\t \r")
+	username, password, err := ui.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "admin" || password != "s3cret" {
+		t.Errorf("Credentials() = %q, %q, want admin, s3cret", username, password)
+	}
+}
+
+func TestTerminalUILANTargets(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.168.1.0/24")
+	subnets := []localSubnet{{Interface: "eth0", Address: net.ParseIP("192.168.1.10"), Network: network}}
+	neighbours := []lanNeighbour{
+		{IP: net.ParseIP("192.168.1.1"), Subnet: &subnets[0]},
+		{IP: net.ParseIP("192.168.1.20"), Subnet: &subnets[0]},
+	}
+	ui := scriptedUI("\x1b[B \r")
+	got, err := ui.LANTargets(context.Background(), subnets, neighbours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := neighbours[1].IP.String(); len(got) != 1 || got[0] != want {
+		t.Errorf("LANTargets() = %v, want [%s]", got, want)
+	}
+}
+
+func TestFit(t *testing.T) {
+	line := ansiRed + "abcdef" + ansiReset
+	if got := fit(line, 3); got != ansiRed+"abc" {
+		t.Errorf("fit() = %q", got)
+	}
+	if got := fit(line, 10); got != line {
+		t.Errorf("fit() = %q", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// setServiceEnvironment only applies to the Windows tunnel service;
+// writeTunnelEnv writes files on the other platforms.
+func setServiceEnvironment(env []string) error {
+	return errors.New("service environment is only stored in the registry on Windows")
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// setServiceEnvironment writes env to the Environment value of the tunnel
+// service's registry key. It is written through the registry API, not
+// reg.exe, so the proxy password is never on a command line, where any
+// local process could read it.
+func setServiceEnvironment(env []string) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, strings.TrimPrefix(netbirdServiceKey, `HKLM\`), registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", netbirdServiceKey, err)
+	}
+	defer k.Close()
+	return k.SetStringsValue("Environment", env)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// scanSummary is what the user is asked to confirm before the scan starts.
+type scanSummary struct {
+	Email        string     `json:"email"`
+	Policy       ScanPolicy `json:"policy"`
+	Credentialed bool       `json:"credentialed"`
+	Username     string     `json:"username,omitempty"`
+	Targets      []string   `json:"targets,omitempty"`
+}
+
+// scanUI asks the questions of an interactive run and shows its progress.
+// Unattended runs never ask, and only show progress.
+type scanUI interface {
+	// Consent asks the user to accept changes to this machine before they
+	// are made.
+	Consent(ctx context.Context, changes []plannedChange) (bool, error)
+	Email(ctx context.Context) (string, error)
+	Policy(ctx context.Context, policies []ScanPolicy) (ScanPolicy, error)
+	Credentialed(ctx context.Context) (bool, error)
+	Credentials(ctx context.Context) (username, password string, err error)
+	LANTargets(ctx context.Context, subnets []localSubnet, neighbours []lanNeighbour) ([]string, error)
+	Confirm(ctx context.Context, summary scanSummary) (bool, error)
+
+	// Tunnel reports whether the tunnel is up.
+	Tunnel(up bool)
+	// Progress shows the latest status of the scan.
+	Progress(scanID int, p scanProgress, eta string)
+	// Done shows the outcome once every change has been undone.
+	Done(result scanResult)
+}
+
+// chooseUI picks the interface of an interactive run: the browser when asked
+// for, the full-screen terminal interface when attached to a terminal, and
+// plain prompts otherwise. The returned function puts the terminal back or
+// stops the local server.
+func chooseUI(cancel context.CancelFunc, plain, browser bool) (scanUI, func()) {
+	if browser {
+		web, err := newWebUI(cancel)
+		if err == nil {
+			return web, web.Close
+		}
+		fmt.Println("Error starting the browser interface:", err)
+	}
+	if !plain && isInteractiveTerminal() {
+		tui, err := newTerminalUI(cancel)
+		if err == nil {
+			return tui, tui.Close
+		}
+		debugPrint("%v\n", err)
+	}
+	return &plainUI{}, func() {}
+}
+
+// plainUI is the line-by-line interface of a plain terminal, a service log
+// or a redirected standard input.
+type plainUI struct {
+	bar *progressbar.ProgressBar
+}
+
+// Consent lists the changes and needs an explicit yes.
+func (u *plainUI) Consent(ctx context.Context, changes []plannedChange) (bool, error) {
+	fmt.Println(tr("The following changes will be made to this machine and undone when the scan ends:"))
+	for _, c := range changes {
+		fmt.Printf("  - %s\n", c.Description)
+		for _, command := range c.Commands {
+			fmt.Printf("      %s\n", command)
+		}
+	}
+	for {
+		fmt.Print(tr("Do you accept these changes? y/n: "))
+		input, err := readLine(ctx)
+		if err != nil {
+			return false, err
+		}
+		if answer, ok := parseYesNo(input); ok && strings.TrimSpace(input) != "" {
+			return answer, nil
+		}
+		fmt.Println(tr("Please answer y or n."))
+	}
+}
+
+func (u *plainUI) Email(ctx context.Context) (string, error) { return getEmailAddress(ctx) }
+
+func (u *plainUI) Policy(ctx context.Context, policies []ScanPolicy) (ScanPolicy, error) {
+	return choosePolicy(ctx, policies, "")
+}
+
+func (u *plainUI) Credentialed(ctx context.Context) (bool, error) {
+	return askForCredentialedScan(ctx)
+}
+
+func (u *plainUI) Credentials(ctx context.Context) (string, string, error) {
+	return promptCredentials(ctx)
+}
+
+func (u *plainUI) LANTargets(ctx context.Context, subnets []localSubnet, neighbours []lanNeighbour) ([]string, error) {
+	return chooseLANTargets(ctx, subnets, neighbours)
+}
+
+func (u *plainUI) Confirm(ctx context.Context, summary scanSummary) (bool, error) { return true, nil }
+func (u *plainUI) Tunnel(up bool)                                                 {}
+
+func (u *plainUI) Progress(scanID int, p scanProgress, eta string) {
+	if u.bar == nil {
+		u.bar = progressbar.NewOptions(100,
+			progressbar.OptionSetWidth(40),
+			progressbar.OptionSetDescription("Scanning"),
+			progressbar.OptionFullWidth(),
+			progressbar.OptionSetPredictTime(false),
+			progressbar.OptionSetRenderBlankState(true),
+			progressbar.OptionEnableColorCodes(true),
+			progressbar.OptionShowDescriptionAtLineEnd(),
+		)
+	}
+	_ = u.bar.Set(p.Current)
+	u.bar.Describe(fmt.Sprintf("[reset]%s %s %s [red][Critical: %d][yellow][High: %d][light_yellow][Medium: %d][green][Low: %d][blue][Info: %d]", tr(scanPhase(p)), p.Percentage, eta, p.Critical, p.High, p.Medium, p.Low, p.Info))
+	fmt.Printf("\r%s", u.bar.String())
+}
+
+func (u *plainUI) Done(result scanResult) {}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed webui/index.html
+var webUIPage []byte
+
+// browserUI is set by -web or the browser_ui config option.
+var browserUI bool
+
+// webResultTimeout is how long Done waits for the browser to fetch the
+// results before the server is stopped anyway.
+var webResultTimeout = time.Minute
+
+// webQuestion is the question the browser is asked to answer.
+type webQuestion struct {
+	ID       int             `json:"id"`
+	Kind     string          `json:"kind"` // consent, email, policy, credentialed, credentials, lan or confirm
+	Policies []ScanPolicy    `json:"policies,omitempty"`
+	Choices  []lanChoice     `json:"choices,omitempty"`
+	Summary  *scanSummary    `json:"summary,omitempty"`
+	Changes  []plannedChange `json:"changes,omitempty"`
+
+	answers chan webAnswer
+}
+
+// webAnswer is posted by the browser to /api/answer. Only the fields of the
+// question's kind are used.
+type webAnswer struct {
+	ID       int      `json:"id"`
+	Yes      bool     `json:"yes"`
+	Email    string   `json:"email"`
+	Policy   string   `json:"policy"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Targets  []string `json:"targets"`
+}
+
+// webResult is the outcome shown on the results page.
+type webResult struct {
+	Outcome  string         `json:"outcome"` // completed, interrupted or failed
+	Error    string         `json:"error,omitempty"`
+	ExitCode int            `json:"exit_code"`
+	ScanID   int            `json:"scan_id"`
+	Duration string         `json:"duration"`
+	Counts   severityCounts `json:"counts"`
+	Email    string         `json:"email,omitempty"`
+	Restored bool           `json:"restored"` // every change was undone
+}
+
+// webState is returned by /api/state, which the page polls.
+type webState struct {
+	Question *webQuestion   `json:"question"`
+	Problem  string         `json:"problem,omitempty"`
+	Tunnel   bool           `json:"tunnel"`
+	ScanID   int            `json:"scan_id"`
+	Phase    string         `json:"phase"`
+	Progress int            `json:"progress"`
+	ETA      string         `json:"eta"`
+	Counts   severityCounts `json:"counts"`
+	Log      []string       `json:"log"`
+	Result   *webResult     `json:"result,omitempty"`
+}
+
+// webUI serves the interactive flow to the default browser, for users who
+// start the client by double-clicking it. The server only listens on the
+// loopback interface, and the page is only served to the browser that
+// opened the one-time link.
+type webUI struct {
+	cancel  context.CancelFunc
+	host    string // expected Host header, to refuse DNS rebinding
+	token   string // one-time token in the link, cleared once used
+	session string // cookie value given in exchange for the token
+	server  *http.Server
+
+	restoreOutput func()
+
+	mu       sync.Mutex
+	nextID   int
+	question *webQuestion
+	problem  string // why the last answer was refused
+	log      []string
+	tunnel   bool
+	scanID   int
+	progress scanProgress
+	eta      string
+	summary  scanSummary
+	result   *webResult
+	seen     chan struct{} // closed once the browser has fetched the result
+}
+
+// newWebUI starts the local server on a random port and opens the browser.
+// Everything printed meanwhile is shown in the page as well as the console.
+func newWebUI(cancel context.CancelFunc) (*webUI, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error starting the local server: %v", err)
+	}
+	u := &webUI{
+		cancel:  cancel,
+		host:    listener.Addr().String(),
+		token:   newIdempotencyKey(),
+		session: newIdempotencyKey(),
+		seen:    make(chan struct{}),
+	}
+	u.server = &http.Server{Handler: u, ReadHeaderTimeout: 10 * time.Second}
+	go u.server.Serve(listener)
+
+	if err := u.captureOutput(); err != nil {
+		u.server.Close()
+		return nil, err
+	}
+
+	link := fmt.Sprintf("http://%s/?token=%s", u.host, u.token)
+	fmt.Println(tr("Opening the scanner in your browser..."))
+	fmt.Print(tr("If it does not open, go to %s\n", link))
+	if err := openBrowser(link); err != nil {
+		debugPrint("Error opening the browser: %v\n", err)
+	}
+	return u, nil
+}
+
+// openBrowser opens a link in the default browser.
+func openBrowser(link string) error {
+	switch runtime.GOOS {
+	case "windows":
+		_, err := runner.Run(context.Background(), "rundll32", "url.dll,FileProtocolHandler", link)
+		return err
+	case "darwin":
+		_, err := runner.Run(context.Background(), "open", link)
+		return err
+	}
+	_, err := runner.Run(context.Background(), "xdg-open", link)
+	return err
+}
+
+// captureOutput copies standard output to the log shown in the page.
+func (u *webUI) captureOutput() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("error creating log pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lines := bufio.NewReader(r)
+		for {
+			line, err := lines.ReadString('\n')
+			stdout.WriteString(line)
+			if text := strings.TrimRight(line, "\r\n"); text != "" {
+				if i := strings.LastIndex(text, "\r"); i >= 0 {
+					text = text[i+1:]
+				}
+				u.mu.Lock()
+				u.log = append(u.log, text)
+				if len(u.log) > maxLogLines {
+					u.log = u.log[len(u.log)-maxLogLines:]
+				}
+				u.mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	u.restoreOutput = func() {
+		os.Stdout = stdout
+		w.Close()
+		<-done
+		r.Close()
+	}
+	return nil
+}
+
+// Close stops the server and puts standard output back.
+func (u *webUI) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	u.server.Shutdown(ctx)
+	if u.restoreOutput != nil {
+		u.restoreOutput()
+	}
+}
+
+func (u *webUI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Host != u.host {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/" && r.URL.Query().Get("token") != "" {
+		u.exchangeToken(w, r)
+		return
+	}
+	if cookie, err := r.Cookie("session"); err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(u.session)) != 1 {
+		http.Error(w, tr("This page can only be opened from the link the scanner opened."), http.StatusForbidden)
+		return
+	}
+	// A JSON body cannot be posted by another site without a preflight.
+	if r.Method == http.MethodPost && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "expected a JSON body", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webUIPage)
+	case r.URL.Path == "/api/state" && r.Method == http.MethodGet:
+		writeJSON(w, u.state())
+	case r.URL.Path == "/api/answer" && r.Method == http.MethodPost:
+		u.answer(w, r)
+	case r.URL.Path == "/api/cancel" && r.Method == http.MethodPost:
+		fmt.Println(tr("Stopping the scan and undoing every change..."))
+		u.cancel()
+		writeJSON(w, struct{}{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// exchangeToken swaps the one-time token for a session cookie, so that the
+// link cannot be used a second time.
+func (u *webUI) exchangeToken(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	ok := u.token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(u.token)) == 1
+	if ok {
+		u.token = ""
+	}
+	u.mu.Unlock()
+	if !ok {
+		http.Error(w, tr("This link has already been used."), http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "session", Value: u.session, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (u *webUI) state() webState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p := u.progress
+	s := webState{
+		Question: u.question,
+		Problem:  u.problem,
+		Tunnel:   u.tunnel,
+		ScanID:   u.scanID,
+		Phase:    tr(scanPhase(p)),
+		Progress: p.Current,
+		ETA:      u.eta,
+		Counts:   severityCounts{p.Critical, p.High, p.Medium, p.Low, p.Info},
+		Log:      append([]string{}, u.log...),
+		Result:   u.result,
+	}
+	if u.result != nil {
+		select {
+		case <-u.seen:
+		default:
+			close(u.seen)
+		}
+	}
+	return s
+}
+
+func (u *webUI) answer(w http.ResponseWriter, r *http.Request) {
+	var a webAnswer
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&a); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding answer: %v", err), http.StatusBadRequest)
+		return
+	}
+	// Taking the question under the lock means only the first answer to it
+	// is used.
+	u.mu.Lock()
+	q := u.question
+	if q != nil && q.ID == a.ID {
+		u.question = nil
+	}
+	u.mu.Unlock()
+	if q == nil || q.ID != a.ID {
+		http.Error(w, "this question has already been answered", http.StatusConflict)
+		return
+	}
+	q.answers <- a
+	writeJSON(w, struct{}{})
+}
+
+// ask shows a question and waits for its answer. problem explains why the
+// previous answer was refused, if it was.
+func (u *webUI) ask(ctx context.Context, q *webQuestion, problem string) (webAnswer, error) {
+	q.answers = make(chan webAnswer, 1)
+	u.mu.Lock()
+	u.nextID++
+	q.ID = u.nextID
+	u.question, u.problem = q, problem
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.question, u.problem = nil, ""
+		u.mu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return webAnswer{}, ctx.Err()
+	case a := <-q.answers:
+		return a, nil
+	}
+}
+
+func (u *webUI) Consent(ctx context.Context, changes []plannedChange) (bool, error) {
+	a, err := u.ask(ctx, &webQuestion{Kind: "consent", Changes: changes}, "")
+	return a.Yes, err
+}
+
+func (u *webUI) Email(ctx context.Context) (string, error) {
+	problem := ""
+	for {
+		a, err := u.ask(ctx, &webQuestion{Kind: "email"}, problem)
+		if err != nil {
+			return "", err
+		}
+		if email := strings.TrimSpace(a.Email); emailRegex.MatchString(email) {
+			return email, nil
+		}
+		problem = strings.TrimSpace(tr("Invalid email address. Please enter a valid email address."))
+	}
+}
+
+func (u *webUI) Policy(ctx context.Context, policies []ScanPolicy) (ScanPolicy, error) {
+	for {
+		a, err := u.ask(ctx, &webQuestion{Kind: "policy", Policies: policies}, "")
+		if err != nil {
+			return ScanPolicy{}, err
+		}
+		if p, ok := findPolicy(policies, a.Policy); ok {
+			return p, nil
+		}
+	}
+}
+
+func (u *webUI) Credentialed(ctx context.Context) (bool, error) {
+	a, err := u.ask(ctx, &webQuestion{Kind: "credentialed"}, "")
+	return a.Yes, err
+}
+
+func (u *webUI) Credentials(ctx context.Context) (string, string, error) {
+	problem := ""
+	for {
+		a, err := u.ask(ctx, &webQuestion{Kind: "credentials"}, problem)
+		if err != nil {
+			return "", "", err
+		}
+		if a.Username != "" && a.Password != "" {
+			return a.Username, a.Password, nil
+		}
+		problem = tr("Enter both a username and a password.")
+	}
+}
+
+func (u *webUI) LANTargets(ctx context.Context, subnets []localSubnet, neighbours []lanNeighbour) ([]string, error) {
+	if len(subnets) == 0 {
+		fmt.Println(tr("No local networks found, only this machine will be scanned."))
+		return nil, nil
+	}
+	choices := lanChoices(subnets, neighbours)
+	problem := ""
+	for {
+		a, err := u.ask(ctx, &webQuestion{Kind: "lan", Choices: choices}, problem)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkLANChoices(a.Targets, choices); err != nil {
+			problem = err.Error()
+			continue
+		}
+		return a.Targets, nil
+	}
+}
+
+// checkLANChoices makes sure the browser only picked devices it was offered.
+func checkLANChoices(targets []string, choices []lanChoice) error {
+	for _, target := range targets {
+		offered := false
+		for _, c := range choices {
+			offered = offered || c.Target == target
+		}
+		if !offered {
+			return fmt.Errorf("%s is not one of the devices listed", target)
+		}
+	}
+	return nil
+}
+
+func (u *webUI) Confirm(ctx context.Context, summary scanSummary) (bool, error) {
+	u.mu.Lock()
+	u.summary = summary
+	u.mu.Unlock()
+	a, err := u.ask(ctx, &webQuestion{Kind: "confirm", Summary: &summary}, "")
+	return a.Yes, err
+}
+
+func (u *webUI) Tunnel(up bool) {
+	u.mu.Lock()
+	u.tunnel = up
+	u.mu.Unlock()
+}
+
+func (u *webUI) Progress(scanID int, p scanProgress, eta string) {
+	u.mu.Lock()
+	u.scanID, u.progress, u.eta = scanID, p, eta
+	u.mu.Unlock()
+}
+
+// Done publishes the results and waits until the page has shown them.
+func (u *webUI) Done(result scanResult) {
+	r := &webResult{
+		Outcome:  "completed",
+		ExitCode: result.ExitCode,
+		ScanID:   result.ScanID,
+		Duration: result.Duration.Round(time.Second).String(),
+		Counts:   severityCounts{result.Final.Critical, result.Final.High, result.Final.Medium, result.Final.Low, result.Final.Info},
+		Restored: result.ExitCode != exitRestore,
+	}
+	switch {
+	case errors.Is(result.Err, context.Canceled):
+		r.Outcome = "interrupted"
+	case result.Err != nil:
+		r.Outcome = "failed"
+	}
+	if result.Err != nil {
+		r.Error = result.Err.Error()
+	}
+	u.mu.Lock()
+	r.Email = u.summary.Email
+	u.result = r
+	u.mu.Unlock()
+
+	select {
+	case <-u.seen:
+	case <-time.After(webResultTimeout):
+	}
+}
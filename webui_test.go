@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startWebUI serves a web UI without opening a browser and returns a client
+// that has exchanged the one-time token.
+func startWebUI(t *testing.T) (*webUI, *httptest.Server, *http.Client) {
+	t.Helper()
+	u := &webUI{cancel: func() {}, token: "one-time", session: "session", seen: make(chan struct{})}
+	server := httptest.NewServer(u)
+	t.Cleanup(server.Close)
+	u.host = strings.TrimPrefix(server.URL, "http://")
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(server.URL + "/?token=one-time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET with token: %s", resp.Status)
+	}
+	return u, server, client
+}
+
+func TestWebUIToken(t *testing.T) {
+	_, server, _ := startWebUI(t)
+
+	// The link only works once, and the API needs the session cookie.
+	for _, path := range []string{"/?token=one-time", "/", "/api/state"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s without the session = %s, want 403", path, resp.Status)
+		}
+	}
+}
+
+func TestWebUIHost(t *testing.T) {
+	_, server, client := startWebUI(t)
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/state", nil)
+	req.Host = "attacker.example:80"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET with another Host = %s, want 403", resp.Status)
+	}
+}
+
+// answerWeb waits for a question of the given kind and answers it.
+func answerWeb(t *testing.T, server *httptest.Server, client *http.Client, kind string, a webAnswer) webState {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var s webState
+		resp, err := client.Get(server.URL + "/api/state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&s)
+		resp.Body.Close()
+		if s.Question == nil || s.Question.Kind != kind {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		a.ID = s.Question.ID
+		body, _ := json.Marshal(a)
+		resp, err = client.Post(server.URL+"/api/answer", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST answer to %s: %s", kind, resp.Status)
+		}
+		return s
+	}
+	t.Fatalf("no %s question asked", kind)
+	return webState{}
+}
+
+func TestWebUIEmail(t *testing.T) {
+	u, server, client := startWebUI(t)
+	done := make(chan string)
+	go func() {
+		email, _ := u.Email(context.Background())
+		done <- email
+	}()
+
+	answerWeb(t, server, client, "email", webAnswer{Email: "nobody"})
+	if s := answerWeb(t, server, client, "email", webAnswer{Email: " nobody@example.com "}); s.Problem == "" {
+		t.Error("invalid address was not explained")
+	}
+	if got := <-done; got != "nobody@example.com" {
+		t.Errorf("Email() = %q, want nobody@example.com", got)
+	}
+}
+
+func TestWebUIAnswerNeedsJSON(t *testing.T) {
+	_, server, client := startWebUI(t)
+	resp, err := client.PostForm(server.URL+"/api/cancel", url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("form POST = %s, want 415", resp.Status)
+	}
+}
+
+func TestCheckLANChoices(t *testing.T) {
+	choices := []lanChoice{{Target: "192.168.1.20"}, {Target: "192.168.1.0/24"}}
+	if err := checkLANChoices([]string{"192.168.1.0/24"}, choices); err != nil {
+		t.Error(err)
+	}
+	if err := checkLANChoices([]string{"10.0.0.1"}, choices); err == nil {
+		t.Error("a device that was not offered was accepted")
+	}
+}